@@ -0,0 +1,117 @@
+package goproxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// AccessLog logs the requests served by the Proxy it wraps as structured
+// slog records, with the method, path, service name/version, endpoint,
+// status and latency, along with the endpoint tags of a registry.Tagger.
+//
+// At high request rates, SampleRate logs only 1 request in SampleRate.
+// The errors, i.e. 5xx responses, are logged regardless of the sampling
+// when AlwaysLogErrors is set.
+type AccessLog struct {
+	// Logger receives the records, slog.Default() when nil. The 5xx
+	// responses are logged at the error level, the others at the info level.
+	Logger *slog.Logger
+
+	// SampleRate, when greater than 1, logs 1 request in SampleRate.
+	SampleRate int
+
+	// AlwaysLogErrors logs all the 5xx responses, sampled or not.
+	AlwaysLogErrors bool
+
+	count atomic.Uint64
+}
+
+// AccessLogMiddleware creates an AccessLog middleware logging 1 request in
+// sampleRate, and all the errors.
+func AccessLogMiddleware(logger *slog.Logger, sampleRate int) Middleware {
+	return (&AccessLog{Logger: logger, SampleRate: sampleRate, AlwaysLogErrors: true}).Wrap
+}
+
+// Wrap implements Middleware.
+func (a *AccessLog) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The Proxy rewrites the request path.
+		start, path := time.Now(), req.URL.Path
+		var r *route
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), routeSlotContextKey, &r)))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		sampled := a.SampleRate <= 1 || a.count.Add(1)%uint64(a.SampleRate) == 0
+		if !sampled && !(a.AlwaysLogErrors && status >= 500) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("path", path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if r != nil {
+			attrs = append(attrs,
+				slog.String("name", r.name),
+				slog.String("version", r.version),
+				slog.String("endpoint", r.endpoint),
+			)
+			if len(r.tags) > 0 {
+				keys := make([]string, 0, len(r.tags))
+				for k := range r.tags {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				tags := make([]any, 0, len(keys))
+				for _, k := range keys {
+					tags = append(tags, slog.String(k, r.tags[k]))
+				}
+				attrs = append(attrs, slog.Group("tags", tags...))
+			}
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		logger := a.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.LogAttrs(req.Context(), level, "request", attrs...)
+	})
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	// Informational responses can be followed by another status, except 101.
+	if w.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the Flusher and Hijacker.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
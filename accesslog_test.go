@@ -0,0 +1,86 @@
+package goproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestAccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", endpoint)
+
+	buf := &bytes.Buffer{}
+	handler := AccessLogMiddleware(slog.New(slog.NewJSONHandler(buf, nil)), 3)(NewProxy(reg))
+	for i := 0; i < 6; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1/v1/ok", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1/v1/fail", nil))
+
+	// 2 sampled successes out of 6, and the error.
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Unexpected records: %v", records)
+	}
+	last := records[2]
+	for key, expect := range map[string]any{
+		"level":    "ERROR",
+		"name":     "service1",
+		"version":  "v1",
+		"endpoint": endpoint,
+		"path":     "/service1/v1/fail",
+		"status":   float64(http.StatusServiceUnavailable),
+	} {
+		if last[key] != expect {
+			t.Errorf("Unexpected %s: %v, expected %v", key, last[key], expect)
+		}
+	}
+}
+
+func TestAccessLogEndpointTags(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+	reg := registry.NewTaggedRegistry(registry.DefaultRegistry{})
+	reg.AddWithTags("service1", "v1", endpoint, map[string]string{"zone": "a", "region": "eu"})
+
+	// The tags are available to the proxy hooks and to the access log.
+	var tags map[string]string
+	p := NewProxy(reg)
+	p.ModifyResponse = func(resp *http.Response) error {
+		tags, _ = EndpointTagsFromContext(resp.Request.Context())
+		return nil
+	}
+	buf := &bytes.Buffer{}
+	AccessLogMiddleware(slog.New(slog.NewJSONHandler(buf, nil)), 1)(p).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1/v1/", nil))
+
+	if tags["zone"] != "a" {
+		t.Fatalf("Unexpected tags from the context: %v", tags)
+	}
+	var record struct{ Tags map[string]string }
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Tags["zone"] != "a" || !strings.Contains(buf.String(), `"tags":{"region":"eu","zone":"a"}`) {
+		t.Fatalf("Unexpected logged tags: %s", buf.String())
+	}
+}
@@ -0,0 +1,182 @@
+package goproxy
+
+import (
+	"container/heap"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AdmissionQueue bounds the requests handled concurrently by the handler it
+// wraps, typically the Proxy. Past MaxConcurrent, the requests wait in a
+// queue and are admitted by priority as the slots free up: the higher
+// priorities first, then in arrival order. The requests still queued after
+// their maximum wait are rejected with a 503 Service Unavailable, so that
+// under sustained load the low priorities are rejected while the high ones
+// keep getting through.
+//
+// Upgrade requests, such as websockets, are hijacked and long-lived: they
+// are not queued and don't hold a slot.
+type AdmissionQueue struct {
+	// MaxConcurrent is the number of requests handled concurrently.
+	// When not positive, the requests are not limited.
+	MaxConcurrent int
+
+	// Priority returns the priority of the request, the higher the sooner
+	// admitted, e.g. with PriorityHeader. When nil, all the requests have
+	// the same priority and are admitted in arrival order. A priority read
+	// from the request, e.g. a header, is set by the clients: it must be
+	// set or stripped by a trusted edge, otherwise any client can jump the
+	// queue.
+	Priority func(req *http.Request) int
+
+	// MaxWait bounds the time a request waits in the queue before being
+	// rejected. When zero, it waits until the client goes away.
+	MaxWait time.Duration
+
+	// MaxWaits overrides MaxWait by priority, e.g. to reject the best-effort
+	// requests sooner.
+	MaxWaits map[int]time.Duration
+
+	lock     sync.Mutex
+	inFlight int
+	queue    admissionHeap
+	seq      uint64
+}
+
+// AdmissionQueueMiddleware creates an AdmissionQueue middleware handling
+// maxConcurrent requests by priority, rejecting them after maxWait in queue.
+func AdmissionQueueMiddleware(maxConcurrent int, priority func(req *http.Request) int, maxWait time.Duration) Middleware {
+	return (&AdmissionQueue{MaxConcurrent: maxConcurrent, Priority: priority, MaxWait: maxWait}).Wrap
+}
+
+// PriorityHeader creates an AdmissionQueue.Priority using the integer value
+// of the given header as priority, 0 when missing or invalid. The header is
+// set by the clients: it must be set or stripped by a trusted edge, otherwise
+// any client can jump the queue.
+func PriorityHeader(name string) func(req *http.Request) int {
+	return func(req *http.Request) int {
+		priority, _ := strconv.Atoi(req.Header.Get(name))
+		return priority
+	}
+}
+
+// Wrap implements Middleware.
+func (q *AdmissionQueue) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if q.MaxConcurrent <= 0 || isUpgrade(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if !q.acquire(req) {
+			http.Error(w, "Proxy overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		defer q.release()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// admissionWaiter is a request waiting in the queue.
+type admissionWaiter struct {
+	priority int
+	seq      uint64
+	index    int           // Position in the heap, -1 once admitted.
+	admitted chan struct{} // Closed once admitted.
+}
+
+// acquire takes a slot for the request, waiting in the queue when there is
+// none available. It reports false when the wait times out or the request
+// is canceled.
+func (q *AdmissionQueue) acquire(req *http.Request) bool {
+	q.lock.Lock()
+	if q.inFlight < q.MaxConcurrent && q.queue.Len() == 0 {
+		q.inFlight++
+		q.lock.Unlock()
+		return true
+	}
+	waiter := &admissionWaiter{seq: q.seq, admitted: make(chan struct{})}
+	if q.Priority != nil {
+		waiter.priority = q.Priority(req)
+	}
+	q.seq++
+	heap.Push(&q.queue, waiter)
+	q.lock.Unlock()
+
+	maxWait := q.MaxWait
+	if d, ok := q.MaxWaits[waiter.priority]; ok {
+		maxWait = d
+	}
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-waiter.admitted:
+		return true
+	case <-timeout:
+	case <-req.Context().Done():
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if waiter.index == -1 {
+		// Admitted in the meantime: give the slot back.
+		q.releaseLocked()
+		return false
+	}
+	heap.Remove(&q.queue, waiter.index)
+	return false
+}
+
+// release frees the slot of a request.
+func (q *AdmissionQueue) release() {
+	q.lock.Lock()
+	q.releaseLocked()
+	q.lock.Unlock()
+}
+
+// releaseLocked hands the slot over to the first queued request, if any.
+func (q *AdmissionQueue) releaseLocked() {
+	if q.queue.Len() == 0 {
+		q.inFlight--
+		return
+	}
+	waiter := heap.Pop(&q.queue).(*admissionWaiter)
+	close(waiter.admitted)
+}
+
+// admissionHeap orders the waiters by decreasing priority, then by arrival.
+type admissionHeap []*admissionWaiter
+
+func (h admissionHeap) Len() int { return len(h) }
+
+func (h admissionHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h admissionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *admissionHeap) Push(x any) {
+	waiter := x.(*admissionWaiter)
+	waiter.index = len(*h)
+	*h = append(*h, waiter)
+}
+
+func (h *admissionHeap) Pop() any {
+	old := *h
+	waiter := old[len(old)-1]
+	old[len(old)-1] = nil
+	waiter.index = -1
+	*h = old[:len(old)-1]
+	return waiter
+}
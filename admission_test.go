@@ -0,0 +1,95 @@
+package goproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueue(t *testing.T) {
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var order []string
+	handler := AdmissionQueueMiddleware(1, PriorityHeader("X-Priority"), time.Second)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/block" {
+			<-release
+			return
+		}
+		lock.Lock()
+		order = append(order, req.URL.Path)
+		lock.Unlock()
+	}))
+	queue := func(path string, priority int) chan int {
+		done := make(chan int, 1)
+		go func() {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("X-Priority", strconv.Itoa(priority))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			done <- w.Code
+		}()
+		return done
+	}
+
+	// The slot is held while the requests are queued, then handed over
+	// by priority.
+	blocked := queue("/block", 0)
+	time.Sleep(20 * time.Millisecond)
+	var done []chan int
+	for i, priority := range []int{0, 0, 5, 1} {
+		done = append(done, queue(fmt.Sprintf("/%d", i), priority))
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	for _, ch := range append(done, blocked) {
+		if code := <-ch; code != http.StatusOK {
+			t.Fatalf("Unexpected status: %d", code)
+		}
+	}
+	if expect := "[/2 /3 /0 /1]"; fmt.Sprint(order) != expect {
+		t.Fatalf("Unexpected admission order: %v, expected %s", order, expect)
+	}
+}
+
+func TestAdmissionQueueMaxWait(t *testing.T) {
+	release := make(chan struct{})
+	q := &AdmissionQueue{MaxConcurrent: 1, Priority: PriorityHeader("X-Priority"), MaxWait: time.Second, MaxWaits: map[int]time.Duration{0: 20 * time.Millisecond}}
+	handler := q.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/block" {
+			<-release
+		}
+	}))
+	blocked := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+		close(blocked)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The best-effort request is rejected after its wait, the critical one
+	// gets the slot once released.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status: %d", w.Code)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Priority", "1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", w.Code)
+	}
+	<-blocked
+	if q.inFlight != 0 || q.queue.Len() != 0 {
+		t.Fatalf("Unexpected state: %d in flight, %d queued", q.inFlight, q.queue.Len())
+	}
+}
@@ -0,0 +1,62 @@
+package goproxy
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BackoffPolicy computes the delay before a new attempt.
+// attempt is 0 for the first retry.
+type BackoffPolicy interface {
+	Next(attempt int) time.Duration
+}
+
+// DefaultBackoff is the exponential backoff with full jitter used when
+// no policy is set.
+var DefaultBackoff BackoffPolicy = FullJitterBackoff{Base: 10 * time.Millisecond, Max: time.Second}
+
+// ConstantBackoff always waits the same delay.
+type ConstantBackoff time.Duration
+
+// Next implements BackoffPolicy.
+func (b ConstantBackoff) Next(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// ExponentialBackoff doubles the delay at each attempt, starting at Base,
+// up to Max when set, or to the largest time.Duration otherwise.
+type ExponentialBackoff struct {
+	Base, Max time.Duration
+}
+
+// Next implements BackoffPolicy.
+func (b ExponentialBackoff) Next(attempt int) time.Duration {
+	d := b.Base
+	for i := 0; i < attempt && (b.Max == 0 || d < b.Max); i++ {
+		if d > math.MaxInt64/2 {
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+	}
+	if b.Max != 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// FullJitterBackoff waits a random delay between 0 and the exponential backoff,
+// which spreads the retries of concurrent clients.
+type FullJitterBackoff struct {
+	Base, Max time.Duration
+}
+
+// Next implements BackoffPolicy.
+func (b FullJitterBackoff) Next(attempt int) time.Duration {
+	d := ExponentialBackoff(b).Next(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
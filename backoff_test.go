@@ -0,0 +1,33 @@
+package goproxy
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicies(t *testing.T) {
+	if d := ConstantBackoff(time.Second).Next(5); d != time.Second {
+		t.Fatalf("Unexpected constant backoff: %s", d)
+	}
+
+	exp := ExponentialBackoff{Base: 10 * time.Millisecond, Max: 100 * time.Millisecond}
+	for attempt, expect := range []time.Duration{10, 20, 40, 80, 100, 100} {
+		if d := exp.Next(attempt); d != expect*time.Millisecond {
+			t.Fatalf("Unexpected exponential backoff for attempt %d: %s", attempt, d)
+		}
+	}
+	if d := (ExponentialBackoff{Base: time.Millisecond}).Next(10); d != 1024*time.Millisecond {
+		t.Fatalf("Unexpected uncapped exponential backoff: %s", d)
+	}
+	if d := (ExponentialBackoff{Base: time.Second}).Next(100); d != math.MaxInt64 {
+		t.Fatalf("Unexpected overflowed exponential backoff: %s", d)
+	}
+
+	jitter := FullJitterBackoff(exp)
+	for attempt := 0; attempt < 10; attempt++ {
+		if d := jitter.Next(attempt); d < 0 || d >= exp.Next(attempt) {
+			t.Fatalf("Unexpected jittered backoff for attempt %d: %s", attempt, d)
+		}
+	}
+}
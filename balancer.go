@@ -0,0 +1,156 @@
+package goproxy
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// Balancers creates a Proxy.Balancer selecting balancers by
+// `<name>/<version>` first, then by `<name>`.
+func Balancers(balancers map[string]LoadBalancer) func(name, version string) LoadBalancer {
+	return func(name, version string) LoadBalancer {
+		if lb, ok := balancers[name+"/"+version]; ok {
+			return lb
+		}
+		return balancers[name]
+	}
+}
+
+// LoadBalanceP2C is a weighted least-request balancer using the power of two
+// choices: it picks two distinct endpoints at random, proportionally to their
+// weight, and connects to the one with the least load relative to its weight.
+// When a single endpoint is available, it is used directly.
+//
+// Weights are read with registry.Weight, from the registry or the ones it
+// wraps, and the load from Connections: the active connections, plus the
+// streams in flight of the multiplexed connections, e.g. of an HTTP2Transport,
+// so that the endpoints are balanced by requests rather than by connections.
+// It can be used as LoadBalance.
+func LoadBalanceP2C(network, serviceName, serviceVersion string, reg registry.Registry) (net.Conn, error) {
+	weight := func(endpoint string) int { return registry.Weight(reg, serviceName, serviceVersion, endpoint) }
+	return balance(network, serviceName, serviceVersion, reg, func(endpoints []string) int {
+		if len(endpoints) == 1 {
+			return 0
+		}
+		weights := make([]int, len(endpoints))
+		total := 0
+		for i, endpoint := range endpoints {
+			if weights[i] = weight(endpoint); weights[i] < 0 {
+				weights[i] = 0
+			}
+			total += weights[i]
+		}
+		if total == 0 {
+			// No usable weight, fallback to uniform selection.
+			for i := range weights {
+				weights[i] = 1
+			}
+			total = len(weights)
+		}
+		i := pickWeighted(weights, total, -1)
+		j := pickWeighted(weights, total-weights[i], i)
+		if j == -1 {
+			return i
+		}
+		// Compare active/weight ratios without dividing.
+		if Connections.Load(endpoints[j])*weights[i] < Connections.Load(endpoints[i])*weights[j] {
+			return j
+		}
+		return i
+	})
+}
+
+// pickWeighted randomly selects an index proportionally to weights, skipping
+// the excluded index. total is the sum of the weights, excluded one aside.
+// Returns -1 when there is nothing to pick.
+func pickWeighted(weights []int, total, exclude int) int {
+	if total <= 0 {
+		return -1
+	}
+	n := rand.Intn(total)
+	for i, w := range weights {
+		if i == exclude {
+			continue
+		}
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return -1
+}
+
+// LoadBalanceSubset creates a balancer using deterministic subsetting to limit
+// the connection fan-out between a fleet of proxies and a fleet of backends:
+// the proxy instance with the given ID only connects to a subset of size
+// endpoints of each tier, selected randomly. See Subset.
+//
+// The subsets are computed over the sorted Lookup result, so all the proxy
+// instances agree on them as long as they see the same endpoints. When the
+// endpoints change, the subsets are recomputed: adding or removing an
+// endpoint may move the instances to other subsets, hence other connections.
+// There is no fallback outside of the subset when all its endpoints fail.
+func LoadBalanceSubset(instanceID, size int) LoadBalancer {
+	return func(network, serviceName, serviceVersion string, reg registry.Registry) (net.Conn, error) {
+		return balance(network, serviceName, serviceVersion, subsetRegistry{Registry: reg, instanceID: instanceID, size: size}, pickRandom)
+	}
+}
+
+// Subset returns the subset of endpoints of the given proxy instance,
+// following the deterministic subsetting of the Google SRE book: the instances
+// are grouped in rounds of len(endpoints)/size instances, each round shuffles
+// the sorted endpoints with its own seed and gives each of its instances a
+// distinct slice of size endpoints. Each endpoint gets connections from about
+// the same number of instances.
+// All the endpoints are returned when there are not more than size of them.
+// The instance IDs are meant to be 0 to N-1, a negative ID gets the subset
+// of a round before the first one.
+func Subset(endpoints []string, instanceID, size int) []string {
+	if size <= 0 || len(endpoints) <= size {
+		return endpoints
+	}
+	sorted := append([]string(nil), endpoints...)
+	sort.Strings(sorted)
+
+	count := len(sorted) / size
+	round, slot := instanceID/count, instanceID%count
+	if slot < 0 {
+		round, slot = round-1, slot+count
+	}
+	rand.New(rand.NewSource(int64(round))).Shuffle(len(sorted), func(i, j int) {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	})
+	start := slot * size
+	return sorted[start : start+size]
+}
+
+// subsetRegistry wraps a Registry to only return the subset of
+// the endpoints of a proxy instance.
+type subsetRegistry struct {
+	registry.Registry
+	instanceID, size int
+}
+
+// Lookup returns the subset of the endpoints of the given service name/version.
+func (r subsetRegistry) Lookup(name, version string) ([]string, error) {
+	endpoints, err := r.Registry.Lookup(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return Subset(endpoints, r.instanceID, r.size), nil
+}
+
+// LookupTiers returns the subset of each endpoint tier of the given service name/version.
+func (r subsetRegistry) LookupTiers(name, version string) ([][]string, error) {
+	tiers, err := registry.LookupTiers(r.Registry, name, version)
+	if err != nil {
+		return nil, err
+	}
+	for i, tier := range tiers {
+		tiers[i] = Subset(tier, r.instanceID, r.size)
+	}
+	return tiers, nil
+}
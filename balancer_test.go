@@ -0,0 +1,193 @@
+package goproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestLoadBalanceP2C(t *testing.T) {
+	busy, idle := newBackend(t, "busy"), newBackend(t, "idle")
+	busyEndpoint, idleEndpoint := strings.TrimPrefix(busy.URL, "http://"), strings.TrimPrefix(idle.URL, "http://")
+
+	reg := registry.NewWeightedRegistry(registry.DefaultRegistry{})
+	reg.Add("service1", "v1", busyEndpoint)
+	reg.Add("service1", "v1", idleEndpoint)
+
+	// Simulate active connections on the busy endpoint.
+	for i := 0; i < 3; i++ {
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		defer Connections.track(c1, busyEndpoint).Close()
+	}
+	if n := Connections.Active(busyEndpoint); n != 3 {
+		t.Fatalf("Expected 3 active connections, got %d", n)
+	}
+
+	for i := 0; i < 20; i++ {
+		conn, err := LoadBalanceP2C("tcp", "service1", "v1", reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if conn.RemoteAddr().String() != idleEndpoint {
+			t.Fatalf("Expected the idle endpoint to be selected, got %s", conn.RemoteAddr())
+		}
+	}
+
+	// With enough weight, the busy endpoint is preferred again, even
+	// with the weights read through a wrapper.
+	reg.SetWeight("service1", "v1", busyEndpoint, 10)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	defer Connections.track(c1, idleEndpoint).Close()
+	conn, err := LoadBalanceP2C("tcp", "service1", "v1", registry.NewDrainingRegistry(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if conn.RemoteAddr().String() != busyEndpoint {
+		t.Fatalf("Expected the heavier endpoint to be selected, got %s", conn.RemoteAddr())
+	}
+}
+
+func TestLoadBalanceP2CSingleEndpoint(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	conn, err := LoadBalanceP2C("tcp", "service1", "v1", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if n := Connections.Active(strings.TrimPrefix(backend.URL, "http://")); n != 0 {
+		t.Fatalf("Expected no active connection once closed, got %d", n)
+	}
+}
+
+func TestProxyBalancer(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	reg := registry.DefaultRegistry{}
+	for _, name := range []string{"service1", "service2"} {
+		reg.Add(name, "v1", strings.TrimPrefix(backend.URL, "http://"))
+		reg.Add(name, "v2", strings.TrimPrefix(backend.URL, "http://"))
+	}
+
+	var used []string
+	counting := func(id string) LoadBalancer {
+		return func(network, name, version string, reg registry.Registry) (net.Conn, error) {
+			used = append(used, id+":"+name+"/"+version)
+			return loadBalance(network, name, version, reg)
+		}
+	}
+	p := NewProxy(reg)
+	p.Balancer = Balancers(map[string]LoadBalancer{
+		"service1":    counting("service"),
+		"service1/v2": counting("version"),
+	})
+	// Disable keep-alive so each request dials.
+	p.transport.DisableKeepAlives = true
+
+	for _, path := range []string{"/service1/v1/", "/service1/v2/", "/service2/v1/"} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected status for %s: %d", path, w.Code)
+		}
+	}
+	if fmt.Sprint(used) != "[service:service1/v1 version:service1/v2]" {
+		t.Fatalf("Unexpected balancers used: %v", used)
+	}
+}
+
+func TestPickRandomDistribution(t *testing.T) {
+	const iterations = 30000
+	endpoints := make([]string, 6)
+	counts := make([]int, len(endpoints))
+	for i := 0; i < iterations; i++ {
+		counts[pickRandom(endpoints)]++
+	}
+	// Each endpoint should get its share within 10%.
+	expect := iterations / len(endpoints)
+	for i, n := range counts {
+		if n < expect*9/10 || n > expect*11/10 {
+			t.Fatalf("Unexpected distribution for endpoint %d: %d, expected ~%d (%v)", i, n, expect, counts)
+		}
+	}
+	if i := pickRandom(nil); i != -1 {
+		t.Fatalf("Expected -1 for an empty list, got %d", i)
+	}
+}
+
+func TestSubset(t *testing.T) {
+	var endpoints []string
+	for i := 0; i < 12; i++ {
+		endpoints = append(endpoints, fmt.Sprintf("host%02d:80", i))
+	}
+
+	// The instances of a round get disjoint subsets covering all the endpoints,
+	// so each endpoint gets connections from the same number of instances.
+	fanIn := map[string]int{}
+	for id := 0; id < 12; id++ {
+		subset := Subset(endpoints, id, 3)
+		if len(subset) != 3 {
+			t.Fatalf("Unexpected subset size for %d: %v", id, subset)
+		}
+		if fmt.Sprint(subset) != fmt.Sprint(Subset(endpoints, id, 3)) {
+			t.Fatalf("Subset of %d is not deterministic", id)
+		}
+		for _, endpoint := range subset {
+			fanIn[endpoint]++
+		}
+	}
+	for _, endpoint := range endpoints {
+		if fanIn[endpoint] != 3 {
+			t.Fatalf("Unexpected fan-in of %s: %d", endpoint, fanIn[endpoint])
+		}
+	}
+
+	// The negative IDs get valid subsets.
+	for id := -5; id < 0; id++ {
+		if subset := Subset(endpoints, id, 3); len(subset) != 3 {
+			t.Fatalf("Unexpected subset for %d: %v", id, subset)
+		}
+	}
+
+	// The order of the lookup doesn't matter.
+	reversed := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		reversed[len(endpoints)-1-i] = endpoint
+	}
+	if fmt.Sprint(Subset(reversed, 5, 3)) != fmt.Sprint(Subset(endpoints, 5, 3)) {
+		t.Fatal("Subset depends on the lookup order")
+	}
+	if len(Subset(endpoints[:2], 5, 3)) != 2 {
+		t.Fatal("Small endpoint sets should not be subset")
+	}
+}
+
+func TestLoadBalanceSubset(t *testing.T) {
+	reg := registry.DefaultRegistry{}
+	for i := 0; i < 4; i++ {
+		reg.Add("service1", "v1", strings.TrimPrefix(newBackend(t, fmt.Sprint(i)).URL, "http://"))
+	}
+	endpoints, _ := reg.Lookup("service1", "v1")
+	subset := Subset(endpoints, 1, 2)
+	lb := LoadBalanceSubset(1, 2)
+	for i := 0; i < 20; i++ {
+		conn, err := lb("tcp", "service1", "v1", reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if addr := conn.RemoteAddr().String(); addr != subset[0] && addr != subset[1] {
+			t.Fatalf("Endpoint %s selected out of the subset %v", addr, subset)
+		}
+	}
+}
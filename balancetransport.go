@@ -0,0 +1,115 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// BalancingTransport is an http.RoundTripper sending each request to one of
+// the endpoints of its service, set with WithService: it selects the endpoint,
+// rewrites the request URL host to it and delegates to the inner Transport.
+// Unlike the Director/Dial pair of the Proxy, e.g. NewDirector and NewDialer,
+// the service name/version is never encoded as request host, so any name can
+// be used, and the connections are pooled per endpoint by the inner Transport.
+//
+//	rp := &httputil.ReverseProxy{
+//		Director: func(req *http.Request) {
+//			*req = *goproxy.WithService(req, "service1", "v1")
+//		},
+//		Transport: &goproxy.BalancingTransport{Registry: reg},
+//	}
+//
+// The endpoints are tried tier by tier like with LoadBalance. When the inner
+// Transport can't get a connection to the selected endpoint, the failure is
+// reported to the registry and the request is retried on another endpoint:
+// the body was not read yet. The requests failing once connected are not retried.
+type BalancingTransport struct {
+	// Registry is used to look up the endpoints of the services.
+	Registry registry.Registry
+
+	// Transport sends the requests to the selected endpoints.
+	// When nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// Pick, when set, returns the index of the endpoint to try in the given
+	// non-empty list. When nil, the endpoints are selected randomly.
+	Pick func(endpoints []string) int
+}
+
+// WithService returns a copy of the request routed by BalancingTransport
+// to the given service name/version.
+func WithService(req *http.Request, name, version string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), routeContextKey, &route{name: name, version: version}))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *BalancingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := routeFromContext(req.Context())
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrInvalidService
+	}
+	transport, pick := t.Transport, t.Pick
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if pick == nil {
+		pick = pickRandom
+	}
+	// The Transport closes the body on failure: keep it open for the next
+	// attempts, it is closed along the response body.
+	body := req.Body
+
+	var resp *http.Response
+	var err error
+	tryErr := tryEndpoints(r.name, r.version, t.Registry, pick, func(endpoint string) error {
+		var connected bool
+		ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(httptrace.GotConnInfo) { connected = true },
+		})
+		attempt := req.Clone(ctx)
+		attempt.URL.Host = endpoint
+		if attempt.URL.Scheme == "" {
+			attempt.URL.Scheme = "http"
+		}
+		if body != nil {
+			attempt.Body = io.NopCloser(body)
+		}
+		resp, err = transport.RoundTrip(attempt)
+		if err != nil && !connected && req.Context().Err() == nil {
+			t.Registry.Failure(r.name, r.version, endpoint, err)
+			return err
+		}
+		r.endpoint = endpoint
+		return nil
+	})
+	if tryErr != nil {
+		resp, err = nil, tryErr
+	}
+	if body != nil {
+		if err != nil {
+			body.Close()
+		} else {
+			resp.Body = &closeBoth{ReadCloser: resp.Body, other: body}
+		}
+	}
+	return resp, err
+}
+
+// closeBoth is a response body closing along the request body.
+type closeBoth struct {
+	io.ReadCloser
+	other io.Closer
+}
+
+// Close closes both bodies.
+func (c *closeBoth) Close() error {
+	c.other.Close()
+	return c.ReadCloser.Close()
+}
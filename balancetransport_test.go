@@ -0,0 +1,54 @@
+package goproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestBalancingTransport(t *testing.T) {
+	backend := newBackend(t, "backend")
+	// Closed listener: connections are refused.
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	// The service name doesn't need to be a valid host.
+	reg := registry.NewDrainingRegistry(registry.DefaultRegistry{})
+	reg.Add("my service", "v1", down)
+	reg.Add("my service", "v1", backend.Listener.Addr().String())
+
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			*req = *WithService(req, "my service", "v1")
+		},
+		Transport: &BalancingTransport{Registry: reg},
+	}
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		rp.ServeHTTP(w, httptest.NewRequest("POST", "/users", strings.NewReader("body")))
+		if w.Code != http.StatusOK || w.Body.String() != "backend /users" {
+			t.Fatalf("Unexpected response: %d %q", w.Code, w.Body.String())
+		}
+	}
+
+	reg.Drain("my service", "v1", backend.Listener.Addr().String())
+	transport := &BalancingTransport{Registry: reg}
+	req := WithService(httptest.NewRequest("GET", "/", nil), "my service", "v1")
+	if _, err := transport.RoundTrip(req); !errors.Is(err, ErrEndpointsDown) {
+		t.Fatalf("Unexpected error with the endpoints down: %v", err)
+	}
+	if _, err := transport.RoundTrip(httptest.NewRequest("GET", "/", io.NopCloser(strings.NewReader("")))); !errors.Is(err, ErrInvalidService) {
+		t.Fatalf("Unexpected error without service: %v", err)
+	}
+}
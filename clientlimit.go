@@ -0,0 +1,48 @@
+package goproxy
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientUpgrades returns the number of upgraded connections and CONNECT
+// tunnels of the given client, when MaxClientUpgrades is set.
+func (p *Proxy) ClientUpgrades(key string) int {
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
+	return p.clientUpgrades[key]
+}
+
+// clientKey returns the key of the client of req.
+func (p *Proxy) clientKey(req *http.Request) string {
+	if p.ClientKey != nil {
+		return p.ClientKey(req)
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// acquireUpgrade counts a new upgrade for the client, unless its cap is reached.
+func (p *Proxy) acquireUpgrade(key string) bool {
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
+	if p.clientUpgrades[key] >= p.MaxClientUpgrades {
+		return false
+	}
+	if p.clientUpgrades == nil {
+		p.clientUpgrades = map[string]int{}
+	}
+	p.clientUpgrades[key]++
+	return true
+}
+
+// releaseUpgrade stops counting an upgrade acquired for the client.
+func (p *Proxy) releaseUpgrade(key string) {
+	p.clientLock.Lock()
+	defer p.clientLock.Unlock()
+	if p.clientUpgrades[key]--; p.clientUpgrades[key] <= 0 {
+		delete(p.clientUpgrades, key)
+	}
+}
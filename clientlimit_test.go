@@ -0,0 +1,81 @@
+package goproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyMaxClientUpgrades(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		io.Copy(conn, rw)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("service2", "v1", "")
+
+	p := NewProxy(reg)
+	p.MaxClientUpgrades = 2
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	upgrade := func(service string) (net.Conn, int) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "GET /"+service+"/v1/ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, resp.StatusCode
+	}
+	waitUpgrades := func(n int) {
+		for i := 0; p.ClientUpgrades("127.0.0.1") != n; i++ {
+			if i == 1000 {
+				t.Fatalf("Unexpected client upgrades: %d, expected %d", p.ClientUpgrades("127.0.0.1"), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	conn1, status := upgrade("service1")
+	defer conn1.Close()
+	conn2, _ := upgrade("service1")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("Unexpected status: %d", status)
+	}
+	conn3, status := upgrade("service1")
+	conn3.Close()
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("Unexpected status past the cap: %d", status)
+	}
+
+	// Closing a bridge releases its slot.
+	conn2.Close()
+	waitUpgrades(1)
+
+	// So do the failed upgrades.
+	conn4, status := upgrade("service2")
+	conn4.Close()
+	if status != http.StatusBadGateway {
+		t.Fatalf("Unexpected status for a failed upgrade: %d", status)
+	}
+	waitUpgrades(1)
+}
@@ -0,0 +1,215 @@
+package goproxy
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Coalesce shares the response of identical concurrent GET and HEAD requests:
+// the first one is proxied while the others wait for its response, so only
+// one of them hits the backend, like golang.org/x/sync/singleflight.
+// The requests are identical when their method, host, URL and VaryHeaders
+// values match. It is meant for expensive idempotent requests.
+//
+// The shared response is buffered in memory, up to MaxBodyBytes per distinct
+// request in flight, and written to each waiting client. The larger responses
+// and the event streams are streamed to their client instead, and the waiting
+// requests are proxied on their own, as are the responses setting cookies.
+// While buffering, the flushes are delayed until the response ends, which
+// delays the chunks of long-polling responses.
+type Coalesce struct {
+	// MaxBodyBytes caps the size of the shared responses. When zero, 1MB is used.
+	MaxBodyBytes int
+
+	// VaryHeaders lists the request headers telling requests apart, e.g. the
+	// ones the responses depend on. When nil, DefaultCoalesceVaryHeaders is used.
+	VaryHeaders []string
+
+	lock  sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// DefaultCoalesceVaryHeaders are the request headers telling requests apart
+// by default: content negotiation, credentials and ranges.
+var DefaultCoalesceVaryHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie", "Range"}
+
+// CoalesceMiddleware creates a Coalesce middleware sharing the responses up to maxBodyBytes.
+func CoalesceMiddleware(maxBodyBytes int) Middleware {
+	return (&Coalesce{MaxBodyBytes: maxBodyBytes}).Wrap
+}
+
+// coalescedCall is a request in flight, shared with the identical requests.
+type coalescedCall struct {
+	done chan struct{}
+	resp *coalescedResponse // Set before done is closed when the response can be shared.
+}
+
+// coalescedResponse is a buffered response.
+type coalescedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// Wrap implements Middleware.
+func (c *Coalesce) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if (req.Method != http.MethodGet && req.Method != http.MethodHead) || isUpgrade(req) ||
+			(req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		key := c.key(req)
+
+		c.lock.Lock()
+		if c.calls == nil {
+			c.calls = map[string]*coalescedCall{}
+		}
+		if call, ok := c.calls[key]; ok {
+			c.lock.Unlock()
+			select {
+			case <-call.done:
+			case <-req.Context().Done():
+				return
+			}
+			if call.resp == nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+			call.resp.writeTo(w)
+			return
+		}
+		call := &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.lock.Unlock()
+
+		// Release the waiting requests even when the handler panics,
+		// e.g. with http.ErrAbortHandler: they are proxied on their own.
+		defer func() {
+			c.lock.Lock()
+			delete(c.calls, key)
+			c.lock.Unlock()
+			close(call.done)
+		}()
+		max := c.MaxBodyBytes
+		if max <= 0 {
+			max = 1 << 20
+		}
+		cw := &coalesceWriter{ResponseWriter: w, max: max}
+		next.ServeHTTP(cw, req)
+		call.resp = cw.finish()
+	})
+}
+
+// key identifies the request among the identical ones.
+func (c *Coalesce) key(req *http.Request) string {
+	vary := c.VaryHeaders
+	if vary == nil {
+		vary = DefaultCoalesceVaryHeaders
+	}
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.Host)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.RequestURI())
+	for _, name := range vary {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// writeTo writes the buffered response to w.
+func (r *coalescedResponse) writeTo(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range r.header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body)
+}
+
+// coalesceWriter buffers the response until it ends, or streams it once it
+// turns out not to be shareable.
+type coalesceWriter struct {
+	http.ResponseWriter
+	max       int
+	status    int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (w *coalesceWriter) WriteHeader(code int) {
+	if w.streaming {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	// Informational responses can be followed by another status, except 101.
+	if code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") || code == http.StatusSwitchingProtocols {
+			w.stream()
+		}
+	}
+}
+
+func (w *coalesceWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.streaming && w.buf.Len()+len(b) > w.max {
+		w.stream()
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush flushes the streamed responses. The buffered ones are flushed at the end.
+func (w *coalesceWriter) Flush() {
+	if w.streaming {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// stream writes the response buffered so far and stops buffering.
+func (w *coalesceWriter) stream() {
+	w.streaming = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf = bytes.Buffer{}
+	}
+}
+
+// finish writes the buffered response and returns it when it can be shared.
+func (w *coalesceWriter) finish() *coalescedResponse {
+	if w.streaming {
+		return nil
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	resp := &coalescedResponse{status: w.status, header: w.Header().Clone(), body: w.buf.Bytes()}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(resp.body)
+	if _, ok := resp.header["Set-Cookie"]; ok {
+		return nil
+	}
+	return resp
+}
+
+// Unwrap gives http.ResponseController access to the Hijacker.
+func (w *coalesceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,63 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	var hits atomic.Int32
+	backend := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("X-Path", req.URL.Path)
+		w.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	})
+	handler := CoalesceMiddleware(8)(backend)
+
+	serve := func(path, accept string) {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", path, nil)
+				req.Header.Set("Accept", accept)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				if w.Code != http.StatusOK || w.Body.String() != path[1:] || w.Header().Get("X-Path") != path {
+					t.Errorf("Unexpected response for %s: %d %q", path, w.Code, w.Body.String())
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	serve("/small", "text/plain")
+	if n := hits.Swap(0); n != 1 {
+		t.Fatalf("Unexpected backend hits for identical requests: %d", n)
+	}
+
+	// The requests vary by Accept.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve("/small", "text/html")
+	}()
+	serve("/small", "application/json")
+	<-done
+	if n := hits.Swap(0); n != 2 {
+		t.Fatalf("Unexpected backend hits for distinct requests: %d", n)
+	}
+
+	// Larger responses are streamed, the waiting requests proxied on their own.
+	serve("/larger-than-8-bytes", "text/plain")
+	if n := hits.Swap(0); n < 2 {
+		t.Fatalf("Unexpected backend hits for large responses: %d", n)
+	}
+}
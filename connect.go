@@ -0,0 +1,58 @@
+package goproxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// AuthorityRoute extracts the service name/version from the host of
+// a CONNECT authority: `<name>.<version>.example.com:443`.
+func AuthorityRoute(authority string) (name, version string, err error) {
+	if host, _, err := net.SplitHostPort(authority); err == nil {
+		authority = host
+	}
+	return SNIRoute(authority)
+}
+
+// serveConnect tunnels the CONNECT request to an endpoint of the service
+// mapped from the requested authority by ConnectRoute.
+func (p *Proxy) serveConnect(w http.ResponseWriter, req *http.Request, start time.Time) {
+	if p.ConnectRoute == nil {
+		http.Error(w, "CONNECT not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, version, err := p.ConnectRoute(req.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := p.dialService("tcp", name, version, p.balancer(name, version))
+	if err != nil {
+		p.errorHandler(w, req, err)
+		return
+	}
+	defer target.Close()
+
+	// The ResponseController reaches the Hijacker through the middleware writers.
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, "CONNECT not supported", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+	// The client may have sent data along with the request, read it from the buffer first.
+	tunnel(conn, rw.Reader, target)
+
+	r := &route{name: name, version: version, endpoint: target.(*proxyConn).Endpoint(), status: http.StatusOK}
+	publishRoute(req, r)
+	p.logRequest(req, r, start)
+}
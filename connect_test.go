@@ -0,0 +1,81 @@
+package goproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", l.Addr().String())
+	p := NewProxy(reg)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	connect := func() (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("CONNECT service1.v1.example.com:443 HTTP/1.1\r\nHost: service1.v1.example.com:443\r\n\r\n"))
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, br, resp
+	}
+
+	// Opt-in only.
+	conn, _, resp := connect()
+	conn.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Unexpected status without ConnectRoute: %d", resp.StatusCode)
+	}
+
+	p.ConnectRoute = AuthorityRoute
+	conn, br, resp := connect()
+	defer conn.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Unexpected tunneled data: %q (%v)", buf, err)
+	}
+}
+
+func TestAuthorityRoute(t *testing.T) {
+	if name, version, err := AuthorityRoute("service1.v1.example.com:443"); err != nil || name != "service1" || version != "v1" {
+		t.Fatalf("Unexpected route: %s/%s (%v)", name, version, err)
+	}
+	if _, _, err := AuthorityRoute("localhost:443"); err == nil {
+		t.Fatal("Expected an error for an authority without version")
+	}
+}
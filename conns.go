@@ -0,0 +1,62 @@
+package goproxy
+
+// The http.Transport only closes its idle connections globally. To scope the
+// close to a service, the Proxy tracks the upstream connections it dials, along
+// with their service, and whether they are idle: a connection is busy from its
+// dial, or from the moment the Transport gets it for a request, until the
+// Transport puts it back in its idle pool. Closing an idle connection makes
+// the Transport drop it from the pool, so the next request re-dials.
+
+// CloseServiceConns closes the pooled idle upstream connections to the given
+// service name/version, e.g. during an upstream deployment, so that the next
+// requests dial fresh endpoints. When active is set, the connections in use
+// are closed as well, failing their requests, upgraded connections and
+// CONNECT tunnels. It returns the number of closed connections.
+//
+// A connection put idle concurrently may be handed to a new request right
+// before being closed: like for a connection closed by the upstream, the
+// Transport retries the idempotent requests on a new connection.
+func (p *Proxy) CloseServiceConns(name, version string, active bool) int {
+	return p.closeConns(func(conn *proxyConn) bool {
+		return conn.name == name && conn.version == version && (active || conn.idle())
+	})
+}
+
+// closeIdleConns closes the idle upstream connections of all the services.
+func (p *Proxy) closeIdleConns() int {
+	return p.closeConns(func(conn *proxyConn) bool { return conn.idle() })
+}
+
+// closeConns closes the upstream connections matching the given filter.
+func (p *Proxy) closeConns(match func(*proxyConn) bool) int {
+	p.upstreamLock.Lock()
+	var conns []*proxyConn
+	for conn := range p.upstreams {
+		if match(conn) {
+			conns = append(conns, conn)
+		}
+	}
+	p.upstreamLock.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// trackUpstream registers the connection for CloseServiceConns.
+func (p *Proxy) trackUpstream(conn *proxyConn) {
+	p.upstreamLock.Lock()
+	if p.upstreams == nil {
+		p.upstreams = map[*proxyConn]struct{}{}
+	}
+	p.upstreams[conn] = struct{}{}
+	p.upstreamLock.Unlock()
+}
+
+// untrackUpstream forgets the closed connection.
+func (p *Proxy) untrackUpstream(conn *proxyConn) {
+	p.upstreamLock.Lock()
+	delete(p.upstreams, conn)
+	p.upstreamLock.Unlock()
+}
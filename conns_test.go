@@ -0,0 +1,99 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyCloseServiceConns(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/block" {
+			<-release
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("service2", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	for _, path := range []string{"/service1/v1/", "/service2/v1/"} {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	// The connections are put idle once the response body is read.
+	waitConns := func(expect int) {
+		t.Helper()
+		for i := 0; p.Stats().Conns != expect; i++ {
+			if i == 1000 {
+				t.Fatalf("Unexpected open connections: %d, expected %d", p.Stats().Conns, expect)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	idle := func() {
+		t.Helper()
+		for i := 0; ; i++ {
+			busy := 0
+			p.upstreamLock.Lock()
+			for conn := range p.upstreams {
+				if !conn.idle() {
+					busy++
+				}
+			}
+			p.upstreamLock.Unlock()
+			if busy == 0 {
+				return
+			}
+			if i == 1000 {
+				t.Fatal("Connections not put idle")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	idle()
+	if n := p.CloseServiceConns("service1", "v1", false); n != 1 {
+		t.Fatalf("Unexpected closed connections: %d", n)
+	}
+	waitConns(1)
+
+	// Active connections are only closed on demand. A fresh connection
+	// is used: the Transport retries the requests severed on reused ones.
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/block", nil))
+		done <- w.Code
+	}()
+	for i := 0; ; i++ {
+		p.upstreamLock.Lock()
+		busy := false
+		for conn := range p.upstreams {
+			busy = busy || !conn.idle()
+		}
+		p.upstreamLock.Unlock()
+		if busy {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("Blocking request not sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := p.CloseServiceConns("service1", "v1", false); n != 0 {
+		t.Fatalf("Unexpected closed idle connections: %d", n)
+	}
+	if n := p.CloseServiceConns("service1", "v1", true); n != 1 {
+		t.Fatalf("Unexpected closed active connections: %d", n)
+	}
+	if code := <-done; code != http.StatusBadGateway {
+		t.Fatalf("Unexpected status of the severed request: %d", code)
+	}
+	waitConns(1)
+}
@@ -0,0 +1,99 @@
+package goproxy
+
+import (
+	"net"
+	"sync"
+)
+
+// Connections tracks the active connections dialed by the default balancers.
+var Connections = &ConnTracker{}
+
+// ConnTracker counts the active connections per endpoint, and the streams
+// in flight of their multiplexed connections.
+type ConnTracker struct {
+	lock    sync.RWMutex
+	active  map[string]int
+	streams map[string]int
+}
+
+// Active returns the number of active connections to the given endpoint.
+func (t *ConnTracker) Active(endpoint string) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.active[endpoint]
+}
+
+// Streams returns the number of streams in flight on the multiplexed
+// connections to the given endpoint, see HTTP2Transport.
+func (t *ConnTracker) Streams(endpoint string) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.streams[endpoint]
+}
+
+// Load returns the load of the given endpoint: its active connections
+// plus the streams in flight of its multiplexed connections.
+func (t *ConnTracker) Load(endpoint string) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.active[endpoint] + t.streams[endpoint]
+}
+
+// addStream counts delta streams in flight to the given endpoint.
+func (t *ConnTracker) addStream(endpoint string, delta int) {
+	t.lock.Lock()
+	if t.streams == nil {
+		t.streams = map[string]int{}
+	}
+	if t.streams[endpoint] += delta; t.streams[endpoint] <= 0 {
+		delete(t.streams, endpoint)
+	}
+	t.lock.Unlock()
+}
+
+// track counts conn as active until it is closed.
+func (t *ConnTracker) track(conn net.Conn, endpoint string) *endpointConn {
+	t.lock.Lock()
+	if t.active == nil {
+		t.active = map[string]int{}
+	}
+	t.active[endpoint]++
+	t.lock.Unlock()
+	return &endpointConn{Conn: conn, endpoint: endpoint, tracker: t}
+}
+
+func (t *ConnTracker) release(endpoint string) {
+	t.lock.Lock()
+	if t.active[endpoint]--; t.active[endpoint] <= 0 {
+		delete(t.active, endpoint)
+	}
+	t.lock.Unlock()
+}
+
+// endpointConn is a connection to a registry endpoint.
+// It allows the proxy to know which endpoint served a request.
+type endpointConn struct {
+	net.Conn
+	endpoint string
+	tracker  *ConnTracker
+	once     sync.Once
+}
+
+// Endpoint returns the registry endpoint the connection was dialed to.
+func (c *endpointConn) Endpoint() string {
+	return c.endpoint
+}
+
+// Close closes the connection and stops tracking it.
+func (c *endpointConn) Close() error {
+	c.once.Do(func() { c.tracker.release(c.endpoint) })
+	return c.Conn.Close()
+}
+
+// CloseWrite shuts down the writing side of the connection when supported.
+func (c *endpointConn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return c.Close()
+}
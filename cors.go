@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS answers the CORS preflight requests at the proxy level instead of
+// forwarding them, and sets the CORS headers on the proxied responses.
+//
+// AllowedOrigins entries are either `*`, an exact origin such as
+// `https://example.com`, or a wildcard origin such as `https://*.example.com`.
+// The origins only matched by `*` get the literal `*` and never the
+// credentials: AllowCredentials only applies to the other entries.
+type CORS struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORSMiddleware creates a CORS middleware for the given origins, methods and headers.
+func CORSMiddleware(origins, methods, headers []string) Middleware {
+	return (&CORS{AllowedOrigins: origins, AllowedMethods: methods, AllowedHeaders: headers}).Wrap
+}
+
+// Wrap implements Middleware.
+func (c *CORS) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		allowed := c.allowOrigin(origin)
+
+		// Preflight request, answer it directly.
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed == "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			c.setHeaders(w.Header(), allowed)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+		hw := &headerWriter{ResponseWriter: w, before: func(h http.Header) {
+			c.setHeaders(h, allowed)
+			if len(c.ExposedHeaders) != 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+		}}
+		next.ServeHTTP(hw, req)
+		hw.finish()
+	})
+}
+
+// setHeaders sets the headers common to preflight and actual responses,
+// allowing the origin returned by allowOrigin.
+func (c *CORS) setHeaders(h http.Header, allowed string) {
+	h.Set("Access-Control-Allow-Origin", allowed)
+	h.Add("Vary", "Origin")
+	if c.AllowCredentials && allowed != "*" {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin:
+// the origin itself when it matches one of the AllowedOrigins, `*` when it
+// is only matched by `*`, empty when it is not allowed.
+func (c *CORS) allowOrigin(origin string) string {
+	var anyOrigin bool
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			anyOrigin = true
+			continue
+		}
+		if allowed == origin {
+			return origin
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			return origin
+		}
+	}
+	if anyOrigin {
+		return "*"
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	var forwarded int
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		forwarded++
+		w.Header().Set("Access-Control-Allow-Origin", "https://upstream.example.com")
+	})
+	handler := CORSMiddleware([]string{"https://app.example.com", "https://*.example.org"}, []string{"GET", "POST"}, []string{"Authorization"})(next)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/service1/v1/", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	w := preflight("https://app.example.com")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || w.Header().Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Fatalf("Unexpected preflight response: %d %v", w.Code, w.Header())
+	}
+	if w := preflight("https://sub.example.org"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected wildcard origin to be allowed, got %d", w.Code)
+	}
+	for _, origin := range []string{"https://evil.com", "https://.example.org", "http://sub.example.org"} {
+		if w := preflight(origin); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected %s to be rejected, got %d", origin, w.Code)
+		}
+	}
+	if forwarded != 0 {
+		t.Fatal("Preflight requests should not be forwarded")
+	}
+
+	req := httptest.NewRequest("GET", "/service1/v1/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if forwarded != 1 || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("Unexpected actual response: %v", w.Header())
+	}
+}
+
+func TestCORSAnyOriginCredentials(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	handler := (&CORS{AllowedOrigins: []string{"*", "https://app.example.com"}, AllowCredentials: true}).Wrap(next)
+
+	for origin, expect := range map[string]string{
+		"https://evil.com":        "*",
+		"https://app.example.com": "https://app.example.com",
+	} {
+		req := httptest.NewRequest("GET", "/service1/v1/", nil)
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		credentials := w.Header().Get("Access-Control-Allow-Credentials") == "true"
+		if w.Header().Get("Access-Control-Allow-Origin") != expect || credentials != (expect != "*") {
+			t.Fatalf("Unexpected response for %s: %v", origin, w.Header())
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package goproxy
+
+import (
+	"time"
+)
+
+// The upstream timeouts are idle timeouts: each read or write pushes the
+// deadline back, so a streaming response only times out when the upstream
+// stalls, not when it takes long overall. They are armed when the Transport
+// gets the connection for a request, and disarmed when it puts it back in its
+// idle pool or the connection is upgraded, e.g. to a websocket, as the
+// Transport keeps reading the idle connections to detect their close.
+
+// acquire marks the connection busy for a new request, armed with its
+// timeouts, and returns the use identifying the request for release.
+func (c *proxyConn) acquire(read, write time.Duration) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.uses++
+	c.owner.Store(c.uses)
+	c.arm(read, write)
+	return c.uses
+}
+
+// release marks the connection idle and disarms it, unless it was
+// acquired by another request since the given use.
+func (c *proxyConn) release(use uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.owner.CompareAndSwap(use, 0) {
+		c.disarm()
+	}
+}
+
+// arm sets the timeouts of the request the connection is used for.
+func (c *proxyConn) arm(read, write time.Duration) {
+	c.readTimeout.Store(int64(read))
+	c.writeTimeout.Store(int64(write))
+	if read > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(read))
+	}
+}
+
+// disarm clears the timeouts once the connection is idle or upgraded.
+func (c *proxyConn) disarm() {
+	if c.readTimeout.Swap(0) > 0 {
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+	if c.writeTimeout.Swap(0) > 0 {
+		c.Conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// Read reads from the connection, pushing back the read deadline when armed.
+func (c *proxyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if timeout := time.Duration(c.readTimeout.Load()); n > 0 && timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	return n, err
+}
+
+// Write writes to the connection within the write timeout, when armed.
+func (c *proxyConn) Write(b []byte) (int, error) {
+	if timeout := time.Duration(c.writeTimeout.Load()); timeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	return c.Conn.Write(b)
+}
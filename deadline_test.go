@@ -0,0 +1,88 @@
+package goproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyUpstreamReadTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/stream":
+			// Longer than the timeout overall, but never idle for that long.
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("chunk"))
+				w.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+		case "/stall":
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("chunk"))
+		}
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.UpstreamReadTimeout = 50 * time.Millisecond
+	front := httptest.NewServer(p)
+	defer front.Close()
+
+	get := func(path string) (int, string, error) {
+		resp, err := http.Get(front.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	if status, _, _ := get("/service1/v1/slow"); status != http.StatusGatewayTimeout {
+		t.Fatalf("Unexpected status for a stalled upstream: %d", status)
+	}
+	if status, body, err := get("/service1/v1/stream"); err != nil || status != http.StatusOK || body != strings.Repeat("chunk", 5) {
+		t.Fatalf("Unexpected streamed response: %d %q, %v", status, body, err)
+	}
+	// The idle pooled connection is disarmed: it is reused past the timeout.
+	time.Sleep(100 * time.Millisecond)
+	if status, _, err := get("/service1/v1/"); err != nil || status != http.StatusOK {
+		t.Fatalf("Unexpected response on the idle connection: %d, %v", status, err)
+	}
+	if _, body, err := get("/service1/v1/stall"); err == nil || body != "chunk" {
+		t.Fatalf("Unexpected response for a stalled body: %q, %v", body, err)
+	}
+}
+
+func TestProxyConnRelease(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &proxyConn{Conn: client, onClose: func() {}}
+	defer conn.Close()
+
+	// The Transport pools the connection and hands it to the next request
+	// before telling the first one it is put idle: the late release must
+	// not clear the state of the next request.
+	first := conn.acquire(time.Second, time.Second)
+	next := conn.acquire(time.Minute, time.Minute)
+	conn.release(first)
+	if conn.idle() || time.Duration(conn.readTimeout.Load()) != time.Minute || time.Duration(conn.writeTimeout.Load()) != time.Minute {
+		t.Fatalf("Unexpected state after a stale release: idle %t, timeouts %d/%d", conn.idle(), conn.readTimeout.Load(), conn.writeTimeout.Load())
+	}
+	conn.release(next)
+	if !conn.idle() || conn.readTimeout.Load() != 0 || conn.writeTimeout.Load() != 0 {
+		t.Fatalf("Unexpected state after release: idle %t, timeouts %d/%d", conn.idle(), conn.readTimeout.Load(), conn.writeTimeout.Load())
+	}
+}
@@ -0,0 +1,33 @@
+package goproxy
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// debugEndpoint strips the DebugEndpointParam from the request query and
+// returns its value, once validated against the endpoints of the service.
+// It returns an empty string when the parameter is absent.
+func (p *Proxy) debugEndpoint(req *http.Request, name, version string) (string, error) {
+	query := req.URL.Query()
+	if !query.Has(p.DebugEndpointParam) {
+		return "", nil
+	}
+	endpoint := query.Get(p.DebugEndpointParam)
+	req.URL.RawQuery = removeQueryParam(req.URL.RawQuery, p.DebugEndpointParam)
+
+	tiers, err := registry.LookupTiers(p.registry, name, version)
+	if err != nil {
+		return "", fmt.Errorf("Invalid endpoint %q for %s/%s: %w", endpoint, name, version, err)
+	}
+	for _, endpoints := range tiers {
+		for _, e := range endpoints {
+			if e != "" && e == endpoint {
+				return endpoint, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Invalid endpoint %q for %s/%s", endpoint, name, version)
+}
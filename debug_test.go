@@ -0,0 +1,56 @@
+package goproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyDebugEndpointParam(t *testing.T) {
+	backend1, backend2 := newBackend(t, "backend1"), newBackend(t, "backend2")
+	endpoint2 := strings.TrimPrefix(backend2.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend1.URL, "http://"))
+	reg.Add("service1", "v1", endpoint2)
+
+	p := NewProxy(reg)
+	p.DebugEndpointParam = "__endpoint"
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/path?a=b&__endpoint="+endpoint2, nil))
+		if w.Body.String() != "backend2 /path" {
+			t.Fatalf("Unexpected response for the forced endpoint: %q", w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/path?__endpoint=other:80", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Unexpected status for an unregistered endpoint: %d", w.Code)
+	}
+
+	// The other parameters are forwarded as is, e.g. for signed URLs.
+	query := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.URL.RawQuery)
+	}))
+	defer query.Close()
+	endpoint3 := strings.TrimPrefix(query.URL, "http://")
+	reg.Add("service2", "v1", endpoint3)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service2/v1/path?z=%2F&__endpoint="+endpoint3+"&a=b+c", nil))
+	if w.Body.String() != "z=%2F&a=b+c" {
+		t.Fatalf("Unexpected forwarded query: %q", w.Body.String())
+	}
+
+	// Disabled, the parameter is forwarded as is.
+	p = NewProxy(reg)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/path?__endpoint=other:80", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status when disabled: %d", w.Code)
+	}
+}
@@ -0,0 +1,167 @@
+package goproxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// NewDirector returns a httputil.ReverseProxy Director routing the requests
+// to the given service name/version. The inbound X-Forwarded-For is not
+// trusted. It is meant to be used along with a Transport dialing with
+// NewDialer, e.g. to compose a custom ReverseProxy:
+//
+//	rp := &httputil.ReverseProxy{
+//		Director:  goproxy.NewDirector("service1", "v1"),
+//		Transport: &http.Transport{Dial: goproxy.NewDialer(reg)},
+//	}
+func NewDirector(name, version string) func(*http.Request) {
+	return func(req *http.Request) {
+		routeRequest(req, name, version, '/', nil)
+	}
+}
+
+// NewDialer returns a Transport Dial function connecting, with LoadBalance,
+// to an endpoint of the service name/version set as host by NewDirector.
+func NewDialer(reg registry.Registry) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		name, version, err := parseServiceHost(addr, '/')
+		if err != nil {
+			return nil, err
+		}
+		return LoadBalance(network, name, version, reg)
+	}
+}
+
+// routeRequest sets the outgoing request host to the encoded service name/version
+// and adds the X-Forwarded-Host and X-Forwarded-Proto headers.
+// The ReverseProxy adds the client address to X-Forwarded-For, the inbound
+// value is only kept when the peer is one of the trusted proxies.
+func routeRequest(req *http.Request, name, version string, sep byte, trustedProxies []netip.Prefix) {
+	if !isTrustedPeer(req.RemoteAddr, trustedProxies) {
+		req.Header.Del("X-Forwarded-For")
+	}
+	if req.Host != "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	if req.TLS != nil {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		req.Header.Set("X-Forwarded-Proto", "http")
+	}
+	req.URL.Scheme = "http"
+	req.URL.Host = encodeServiceHost(name, version, sep)
+}
+
+// ForwardingHeaders selects the headers describing the proxy hop.
+type ForwardingHeaders int
+
+// Forwarding headers.
+const (
+	XForwardedHeaders     ForwardingHeaders = iota // X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto.
+	ForwardedHeader                                // RFC 7239 Forwarded.
+	BothForwardingHeaders                          // X-Forwarded-* and Forwarded.
+)
+
+// forwarded adds the Forwarded element of the proxy hop to the outgoing
+// request and removes the X-Forwarded-* headers, according to Forwarding.
+// Like X-Forwarded-For, the inbound Forwarded is only extended when the peer
+// is one of the trusted proxies, otherwise it is replaced.
+func (p *Proxy) forwarded(req *http.Request) {
+	if p.Forwarding == XForwardedHeaders {
+		return
+	}
+	if p.Forwarding == ForwardedHeader {
+		// A nil value prevents the ReverseProxy from adding X-Forwarded-For.
+		req.Header["X-Forwarded-For"] = nil
+		req.Header.Del("X-Forwarded-Host")
+		req.Header.Del("X-Forwarded-Proto")
+	}
+
+	var elem []string
+	if p.ForwardedBy != "" {
+		elem = append(elem, "by="+forwardedValue(p.ForwardedBy))
+	}
+	if addrPort, err := netip.ParseAddrPort(req.RemoteAddr); err == nil {
+		addr := addrPort.Addr().Unmap()
+		if addr.Is6() {
+			elem = append(elem, `for="[`+addr.String()+`]"`)
+		} else {
+			elem = append(elem, "for="+addr.String())
+		}
+	}
+	if req.Host != "" {
+		elem = append(elem, "host="+forwardedValue(req.Host))
+	}
+	if req.TLS != nil {
+		elem = append(elem, "proto=https")
+	} else {
+		elem = append(elem, "proto=http")
+	}
+
+	value := strings.Join(elem, ";")
+	if prior := req.Header.Values("Forwarded"); len(prior) > 0 && isTrustedPeer(req.RemoteAddr, p.TrustedProxies) {
+		value = strings.Join(prior, ", ") + ", " + value
+	}
+	req.Header.Set("Forwarded", value)
+}
+
+// quotedPairEscaper escapes the quoted string values.
+var quotedPairEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// forwardedValue returns v as a token when possible, as a quoted string otherwise.
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + quotedPairEscaper.Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+// isTokenChar reports whether c is a RFC 7230 tchar.
+func isTokenChar(c rune) bool {
+	return c < 0x7f && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c))
+}
+
+// PathPrefixes creates a Proxy.PathPrefix selecting prefixes by
+// `<name>/<version>` first, then by `<name>`.
+func PathPrefixes(prefixes map[string]string) func(name, version string) string {
+	return func(name, version string) string {
+		if prefix, ok := prefixes[name+"/"+version]; ok {
+			return prefix
+		}
+		return prefixes[name]
+	}
+}
+
+// prependPath prepends prefix to the path of u.
+func prependPath(u *url.URL, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if u.RawPath != "" {
+		u.RawPath = (&url.URL{Path: prefix}).EscapedPath() + u.RawPath
+	}
+	u.Path = prefix + u.Path
+}
+
+// isTrustedPeer reports whether the address of the peer is in one of the trusted prefixes.
+func isTrustedPeer(remoteAddr string, trusted []netip.Prefix) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package goproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestNewDirector(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("X-Forwarded-Host") + " " + req.Header.Get("X-Forwarded-Proto") + " " + req.URL.Path))
+	}))
+	defer backend.Close()
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service/1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	rp := &httputil.ReverseProxy{
+		Director:  NewDirector("service/1", "v1"),
+		Transport: &http.Transport{Dial: NewDialer(reg)},
+	}
+	w := httptest.NewRecorder()
+	rp.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/users", nil))
+	body, _ := io.ReadAll(w.Body)
+	if w.Code != http.StatusOK || string(body) != "example.com http /users" {
+		t.Fatalf("Unexpected response: %d %q", w.Code, body)
+	}
+}
+
+func TestProxyTrustedProxies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("X-Forwarded-For")))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	for _, elem := range []struct {
+		trusted []netip.Prefix
+		expect  string
+	}{
+		{nil, "127.0.0.1"},
+		{[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, "127.0.0.1"},
+		{[]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, "1.2.3.4, 127.0.0.1"},
+	} {
+		p := NewProxy(reg)
+		p.TrustedProxies = elem.trusted
+		proxy := httptest.NewServer(p)
+
+		req, _ := http.NewRequest("GET", proxy.URL+"/service1/v1/", nil)
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		proxy.Close()
+		if string(body) != elem.expect {
+			t.Fatalf("Unexpected X-Forwarded-For with trusted proxies %v: %q, expected %q", elem.trusted, body, elem.expect)
+		}
+	}
+}
+
+func TestProxyPathPrefix(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.RequestURI))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	for _, name := range []string{"service1", "service2"} {
+		reg.Add(name, "v1", strings.TrimPrefix(backend.URL, "http://"))
+		reg.Add(name, "v2", strings.TrimPrefix(backend.URL, "http://"))
+	}
+
+	p := NewProxy(reg)
+	p.PathPrefix = PathPrefixes(map[string]string{
+		"service1":    "/api/",
+		"service1/v2": "/api/v2",
+	})
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for path, expect := range map[string]string{
+		"/service1/v1/users":     "/api/users",
+		"/service1/v2/users?a=b": "/api/v2/users?a=b",
+		"/service2/v1/users":     "/users",
+	} {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != expect {
+			t.Fatalf("Unexpected upstream path for %s: %q, expected %q", path, body, expect)
+		}
+	}
+}
+
+func TestProxyForwarding(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.Header.Get("Forwarded") + " | " + req.Header.Get("X-Forwarded-For") + " " + req.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	for _, elem := range []struct {
+		forwarding ForwardingHeaders
+		remoteAddr string
+		trusted    []netip.Prefix
+		expect     string
+	}{
+		{XForwardedHeaders, "1.2.3.4:1234", nil, "for=9.9.9.9 | 1.2.3.4 http"},
+		{ForwardedHeader, "1.2.3.4:1234", nil, `by=_proxy1;for=1.2.3.4;host="example.com:8080";proto=http |  `},
+		{BothForwardingHeaders, "[2001:db8::1]:1234", nil, `by=_proxy1;for="[2001:db8::1]";host="example.com:8080";proto=http | 2001:db8::1 http`},
+		{ForwardedHeader, "10.0.0.1:1234", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, `for=9.9.9.9, by=_proxy1;for=10.0.0.1;host="example.com:8080";proto=http |  `},
+	} {
+		p := NewProxy(reg)
+		p.Forwarding = elem.forwarding
+		p.ForwardedBy = "_proxy1"
+		p.TrustedProxies = elem.trusted
+		req := httptest.NewRequest("GET", "http://example.com:8080/service1/v1/", nil)
+		req.RemoteAddr = elem.remoteAddr
+		req.Header.Set("Forwarded", "for=9.9.9.9")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if w.Body.String() != elem.expect {
+			t.Errorf("Unexpected forwarding headers with %d from %s: %q, expected %q", elem.forwarding, elem.remoteAddr, w.Body.String(), elem.expect)
+		}
+	}
+}
+
+func TestForwardedValue(t *testing.T) {
+	for v, expect := range map[string]string{
+		"example.com":      "example.com",
+		"example.com:8080": `"example.com:8080"`,
+		`a"b\c`:            `"a\"b\\c"`,
+	} {
+		if got := forwardedValue(v); got != expect {
+			t.Errorf("Unexpected value for %q: %s, expected %s", v, got, expect)
+		}
+	}
+}
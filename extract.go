@@ -0,0 +1,92 @@
+package goproxy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ExtractNameVersionRegexp returns an extractor, suitable for ExtractNameVersion,
+// which looks up the name and version from the `name` and `version` named groups
+// of the given pattern.
+// The target Path is then rewritten using pathTemplate, which can reference any
+// capture group of the pattern using the regexp.Expand syntax (`$1`, `${rest}`).
+// It panics if the pattern has no `name` or `version` group. A path where
+// one of them is optional and not matched fails with ErrInvalidService.
+func ExtractNameVersionRegexp(pattern *regexp.Regexp, pathTemplate string) func(*url.URL) (string, string, error) {
+	nameIdx, versionIdx := pattern.SubexpIndex("name"), pattern.SubexpIndex("version")
+	if nameIdx == -1 || versionIdx == -1 {
+		panic(fmt.Sprintf("goproxy: invalid pattern %s: missing `name` or `version` group", pattern))
+	}
+
+	return func(target *url.URL) (name, version string, err error) {
+		match := pattern.FindStringSubmatchIndex(target.Path)
+		if match == nil {
+			return "", "", fmt.Errorf("Invalid path %q: does not match %s", target.Path, pattern)
+		}
+		if match[2*nameIdx] < 0 || match[2*versionIdx] < 0 {
+			return "", "", fmt.Errorf("Invalid path %q: missing name or version: %w", target.Path, ErrInvalidService)
+		}
+		name = target.Path[match[2*nameIdx]:match[2*nameIdx+1]]
+		version = target.Path[match[2*versionIdx]:match[2*versionIdx+1]]
+		if name == "" || version == "" {
+			return "", "", fmt.Errorf("Invalid path %q: empty name or version", target.Path)
+		}
+		path := string(pattern.ExpandString(nil, pathTemplate, target.Path, match))
+		if len(path) == 0 || path[0] != '/' {
+			path = "/" + path
+		}
+		target.Path = path
+		return name, version, nil
+	}
+}
+
+// ExtractNameVersionQuery returns an extractor, suitable for ExtractNameVersion,
+// which takes the name from the first path segment and the version from the
+// given query parameter: `/<name>/...?<param>=<version>`.
+// The parameter is removed from the forwarded query, the other parameters
+// are forwarded untouched, e.g. for signed URLs.
+//
+// The query parameter takes precedence: when present, the whole path after
+// the name is forwarded, even if it starts with a version. When absent, the
+// default `/<name>/<version>/...` format is expected.
+func ExtractNameVersionQuery(param string) func(*url.URL) (string, string, error) {
+	return func(target *url.URL) (name, version string, err error) {
+		query := target.Query()
+		version = query.Get(param)
+		if version == "" {
+			return extractNameVersion(target)
+		}
+		path := target.Path
+		if len(path) > 1 && path[0] == '/' {
+			path = path[1:]
+		}
+		name, path, _ = strings.Cut(path, "/")
+		if name == "" {
+			return "", "", fmt.Errorf("Invalid path %q: missing name", target.Path)
+		}
+		target.Path, target.RawQuery = "/"+path, removeQueryParam(target.RawQuery, param)
+		return name, version, nil
+	}
+}
+
+// removeQueryParam removes the given parameter from the raw query, keeping
+// the other parameters as is: unlike url.Values.Encode, their order and
+// escaping are preserved.
+func removeQueryParam(rawQuery, param string) string {
+	var b strings.Builder
+	for rawQuery != "" {
+		var pair string
+		pair, rawQuery, _ = strings.Cut(rawQuery, "&")
+		key, _, _ := strings.Cut(pair, "=")
+		if k, err := url.QueryUnescape(key); err == nil && k == param {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(pair)
+	}
+	return b.String()
+}
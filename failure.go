@@ -0,0 +1,55 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// FailureKind categorizes the endpoint failures, see ClassifyFailure.
+type FailureKind int
+
+// Failure kinds.
+const (
+	FailureOther   FailureKind = iota // Unclassified failure, e.g. an unexpected status code.
+	FailureTimeout                    // The endpoint didn't answer in time, it may only be slow.
+	FailureReset                      // The connection was reset or broken.
+	FailureRefused                    // The connection was refused, nothing listens on the endpoint.
+)
+
+// DefaultFailurePenalties are the default HealthChecker.FailurePenalties:
+// a refused connection costs more than a reset, which costs more than a
+// slow response.
+var DefaultFailurePenalties = map[FailureKind]int{
+	FailureOther:   1,
+	FailureTimeout: 1,
+	FailureReset:   2,
+	FailureRefused: 4,
+}
+
+// ClassifyFailure is called to categorize the failures reported to
+// HealthChecker.Failure. It can be overridden to categorize custom errors,
+// falling back to the default classification:
+//
+//	goproxy.ClassifyFailure = func(err error) goproxy.FailureKind {
+//		if errors.Is(err, errOverloaded) {
+//			return goproxy.FailureTimeout
+//		}
+//		return goproxy.DefaultClassifyFailure(err)
+//	}
+var ClassifyFailure = DefaultClassifyFailure
+
+// DefaultClassifyFailure categorizes the network errors.
+func DefaultClassifyFailure(err error) FailureKind {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNABORTED):
+		return FailureReset
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	}
+	return FailureOther
+}
@@ -0,0 +1,121 @@
+package goproxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ForwardAuth delegates the authorization of each request to an external
+// service, like nginx's auth_request.
+//
+// The original method, end-to-end headers, URI and host are sent to the auth
+// service, the body is not. On a 2xx answer, the CopyHeaders of the auth
+// response are set on the forwarded request, replacing the client values:
+// the headers the auth service leaves out are removed. On a 5xx answer, the
+// auth service is considered unavailable. Otherwise, the auth service
+// response is returned to the client: its redirects, e.g. to a login page,
+// are not followed.
+type ForwardAuth struct {
+	URL         string
+	CopyHeaders []string
+
+	// FailOpen lets the requests through when the auth service can't be
+	// reached or answers with a 5xx. By default, they are rejected with
+	// 503 Service Unavailable.
+	FailOpen bool
+
+	// Client is used to query the auth service, http.DefaultClient when nil.
+	// Its CheckRedirect is overridden not to follow the redirects.
+	Client *http.Client
+}
+
+// ForwardAuthMiddleware creates a fail-closed ForwardAuth middleware.
+func ForwardAuthMiddleware(authURL string, copyHeaders []string) Middleware {
+	return (&ForwardAuth{URL: authURL, CopyHeaders: copyHeaders}).Wrap
+}
+
+// Wrap implements Middleware.
+func (a *ForwardAuth) Wrap(next http.Handler) http.Handler {
+	client := &http.Client{}
+	if a.Client != nil {
+		*client = *a.Client
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authReq, err := http.NewRequestWithContext(req.Context(), req.Method, a.URL, nil)
+		if err != nil {
+			log.Printf("forward auth: invalid auth request: %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		authReq.Header = req.Header.Clone()
+		removeHopHeaders(authReq.Header)
+		authReq.Header.Del("Content-Length")
+		authReq.Header.Set("X-Forwarded-Method", req.Method)
+		authReq.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+		authReq.Header.Set("X-Forwarded-Host", req.Host)
+
+		resp, err := client.Do(authReq)
+		if err == nil && resp.StatusCode >= 500 {
+			resp.Body.Close()
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		if err != nil {
+			log.Printf("forward auth: error querying %s: %s", a.URL, err)
+			if a.FailOpen {
+				next.ServeHTTP(w, req)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		for _, k := range a.CopyHeaders {
+			req.Header.Del(k)
+			if v := resp.Header.Values(k); len(v) != 0 {
+				req.Header[http.CanonicalHeaderKey(k)] = v
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// hopHeaders are the hop-by-hop headers, not to be forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes the hop-by-hop headers from h, including
+// the ones listed by Connection.
+func removeHopHeaders(h http.Header) {
+	for _, value := range h["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				h.Del(token)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
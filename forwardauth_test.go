@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardAuthMiddleware(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "secret" {
+			w.Header().Set("WWW-Authenticate", "Basic")
+			http.Error(w, "denied "+req.Header.Get("X-Forwarded-Uri"), http.StatusUnauthorized)
+			return
+		}
+		if req.Header.Get("Keep-Alive") != "" || req.Header.Get("X-Hop") != "" {
+			http.Error(w, "hop-by-hop header forwarded", http.StatusBadRequest)
+			return
+		}
+		if req.Header.Get("X-Anonymous") == "" {
+			w.Header().Set("X-User", "user1")
+		}
+		w.Header().Set("X-Other", "other")
+	}))
+	defer auth.Close()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "user="+req.Header.Get("X-User")+" other="+req.Header.Get("X-Other"))
+	})
+	handler := ForwardAuthMiddleware(auth.URL, []string{"X-User"})(next)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/users?id=1", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Basic" || w.Body.String() != "denied /service1/v1/users?id=1\n" {
+		t.Fatalf("Unexpected denied response: %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/service1/v1/users", nil)
+	req.Header.Set("Authorization", "secret")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "user=user1 other=" {
+		t.Fatalf("Unexpected allowed response: %d %q", w.Code, w.Body.String())
+	}
+
+	// The client values of the CopyHeaders are not forwarded.
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/service1/v1/users", nil)
+	req.Header.Set("Authorization", "secret")
+	req.Header.Set("X-Anonymous", "1")
+	req.Header.Set("X-User", "admin")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "user= other=" {
+		t.Fatalf("Unexpected spoofed response: %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestForwardAuthUnavailable(t *testing.T) {
+	closed := httptest.NewServer(nil)
+	closed.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+	// An auth service answering 5xx is unavailable, as one not reachable.
+	for _, authURL := range []string{closed.URL, failing.URL} {
+		for failOpen, status := range map[bool]int{false: http.StatusServiceUnavailable, true: http.StatusOK} {
+			w := httptest.NewRecorder()
+			(&ForwardAuth{URL: authURL, FailOpen: failOpen}).Wrap(next).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			if w.Code != status {
+				t.Errorf("Unexpected status for %s with FailOpen %t: %d, expected %d", authURL, failOpen, w.Code, status)
+			}
+		}
+	}
+}
+
+func TestForwardAuthRedirect(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/login" {
+			io.WriteString(w, "login")
+			return
+		}
+		http.Redirect(w, req, "/login", http.StatusFound)
+	}))
+	defer auth.Close()
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("Unexpected request to the backend: %s", req.URL)
+	})
+
+	// A redirect denies the request, be the Client user supplied or not.
+	for _, client := range []*http.Client{nil, {}} {
+		w := httptest.NewRecorder()
+		(&ForwardAuth{URL: auth.URL + "/auth", Client: client}).Wrap(next).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+			t.Fatalf("Unexpected redirect response: %d %v", w.Code, w.Header())
+		}
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"strings"
 	"time"
@@ -20,7 +19,11 @@ import (
 
 // Common errors
 var (
-	ErrInvalidService = errors.New("invalid service/version")
+	ErrInvalidService  = errors.New("invalid service/version")
+	ErrInvalidEndpoint = errors.New("invalid endpoint")
+	ErrTooManyConns    = errors.New("too many upstream connections")
+	ErrBodyTooLarge    = errors.New("upstream response body too large")
+	ErrEndpointsDown   = errors.New("all endpoints failed")
 )
 
 // ExtractNameVersion is called to lookup the service name / version from
@@ -28,86 +31,213 @@ var (
 // expectation.
 var ExtractNameVersion = extractNameVersion
 
+// LoadBalancer connects to one of the endpoints of the given service name/version.
+type LoadBalancer func(network, serviceName, serviceVersion string, reg registry.Registry) (net.Conn, error)
+
 // LoadBalance is the default balancer which will use a random endpoint
 // for the given service name/version.
-var LoadBalance = loadBalance
+var LoadBalance LoadBalancer = loadBalance
+
+// Dialer is used by the default balancer to connect to the endpoints.
+// Its KeepAlive, KeepAliveConfig and Control fields can be set in order
+// to tune the upstream sockets.
+var Dialer = &net.Dialer{}
+
+// ConnWrapper, when set, wraps the upstream connections dialed by the
+// default balancers, e.g. for byte counting, deadlines or logging.
+// Traffic.Wrap counts the bytes exchanged for Stats.
+var ConnWrapper func(conn net.Conn, name, version, endpoint string) net.Conn
+
+// ResolveRetries is the number of times the default balancers retry dialing
+// an endpoint whose host name could not be resolved because of a transient
+// failure (timeout, unreachable or failing DNS server), waiting ResolveBackoff
+// in between. Hard resolution failures (NXDOMAIN) are never retried.
+// Set it to 0 to disable the retries.
+var ResolveRetries = 2
+
+// ResolveBackoff is the jittered delay between the resolution retries.
+var ResolveBackoff BackoffPolicy = FullJitterBackoff{Base: 10 * time.Millisecond, Max: 100 * time.Millisecond}
+
+// TCPNoDelay controls the TCP_NODELAY option of the upstream connections
+// dialed by the default balancer.
+var TCPNoDelay = true
 
 // extractNameVersion lookup the target path and extract the name and version.
 // It updates the target Path trimming version and name.
 // Expected format: `/<name>/<version>/...`
+// It relies on index based slicing to avoid allocating on the hot path.
 func extractNameVersion(target *url.URL) (name, version string, err error) {
 	path := target.Path
 	if len(path) > 1 && path[0] == '/' {
 		path = path[1:]
 	}
-	tmp := strings.Split(path, "/")
-	if len(tmp) < 2 {
+	i := strings.IndexByte(path, '/')
+	if i == -1 {
 		return "", "", fmt.Errorf("Invalid path")
 	}
-	name, version = tmp[0], tmp[1]
-	target.Path = "/" + strings.Join(tmp[2:], "/")
+	name, path = path[:i], path[i+1:]
+	if j := strings.IndexByte(path, '/'); j != -1 {
+		// Keep the separator as leading slash of the new path.
+		version, target.Path = path[:j], path[j:]
+	} else {
+		version, target.Path = path, "/"
+	}
 	return name, version, nil
 }
 
 // loadBalance is a basic loadBalancer which randomly
 // tries to connect to one of the endpoints and try again
 // in case of failure.
+// When the registry is a registry.TieredRegistry, the endpoints of a tier
+// are only tried once all the endpoints of the previous tiers failed.
+// When the registry is a registry.SingleLookuper, the endpoint it returns is
+// tried first, the full list is only looked up when it fails.
 func loadBalance(network, serviceName, serviceVersion string, reg registry.Registry) (net.Conn, error) {
-	endpoints, err := reg.Lookup(serviceName, serviceVersion)
-	if err != nil {
-		return nil, err
-	}
-	for {
-		// No more endpoint, stop
-		if len(endpoints) == 0 {
-			break
+	if single, ok := reg.(registry.SingleLookuper); ok {
+		endpoint, err := single.LookupOne(serviceName, serviceVersion)
+		if err != nil {
+			return nil, err
+		}
+		if endpoint != "" {
+			conn, err := dial(network, endpoint)
+			if err == nil {
+				return connected(conn, serviceName, serviceVersion, endpoint), nil
+			}
+			dialFailed(reg, serviceName, serviceVersion, endpoint, err)
 		}
-		// Select a random endpoint
-		i := rand.Int() % len(endpoints)
-		endpoint := endpoints[i]
+	}
+	return balance(network, serviceName, serviceVersion, reg, pickRandom)
+}
 
-		// Try to connect
-		conn, err := net.Dial(network, endpoint)
+// pickRandom selects a random endpoint, uniformly.
+func pickRandom(endpoints []string) int {
+	if len(endpoints) == 0 {
+		return -1
+	}
+	return rand.Intn(len(endpoints))
+}
+
+// balance tries to connect to the endpoints selected by pick, tier by tier,
+// until one of them succeeds. pick is called with a non-empty list and
+// returns the index of the endpoint to try. The order of the list is not
+// preserved across the calls.
+func balance(network, serviceName, serviceVersion string, reg registry.Registry, pick func(endpoints []string) int) (net.Conn, error) {
+	var conn net.Conn
+	err := tryEndpoints(serviceName, serviceVersion, reg, pick, func(endpoint string) error {
+		c, err := dial(network, endpoint)
 		if err != nil {
-			reg.Failure(serviceName, serviceVersion, endpoint, err)
-			// Failure: remove the endpoint from the current list and try again.
-			endpoints = append(endpoints[:i], endpoints[i+1:]...)
-			continue
+			dialFailed(reg, serviceName, serviceVersion, endpoint, err)
+			return err
 		}
-		// Success: return the connection.
+		conn = connected(c, serviceName, serviceVersion, endpoint)
+		return nil
+	})
+	return conn, err
+}
+
+// tryEndpoints calls try with the endpoints selected by pick, tier by tier,
+// until one of them succeeds, see balance.
+func tryEndpoints(serviceName, serviceVersion string, reg registry.Registry, pick func(endpoints []string) int, try func(endpoint string) error) error {
+	tiers, err := registry.LookupTiers(reg, serviceName, serviceVersion)
+	if err != nil {
+		return err
+	}
+	var tried bool
+	for _, endpoints := range tiers {
+		tried = tried || len(endpoints) > 0
+		owned := false
+		for {
+			// No more endpoint in this tier, move on to the next one
+			if len(endpoints) == 0 {
+				break
+			}
+			i := pick(endpoints)
+			endpoint := endpoints[i]
+
+			// Try the endpoint, skipping invalid entries.
+			if endpoint == "" {
+				err = ErrInvalidEndpoint
+			} else {
+				err = try(endpoint)
+			}
+			if err != nil {
+				// Failure: remove the endpoint from the current list and try again.
+				// The list is copied once as it may be shared with the registry,
+				// then the endpoint is swapped with the last one to avoid shifting.
+				if !owned {
+					endpoints, owned = append([]string(nil), endpoints...), true
+				}
+				last := len(endpoints) - 1
+				endpoints[i] = endpoints[last]
+				endpoints = endpoints[:last]
+				continue
+			}
+			// Success: stop trying.
+			return nil
+		}
+	}
+	// No available endpoint: tell an empty pool from endpoints all down.
+	if !tried {
+		return fmt.Errorf("%w: %s/%s", registry.ErrNoEndpoints, serviceName, serviceVersion)
+	}
+	return fmt.Errorf("No endpoint available for %s/%s: %w, last error: %w", serviceName, serviceVersion, ErrEndpointsDown, err)
+}
+
+// connected counts the selection of the endpoint and tracks
+// the new connection to it, wrapped by ConnWrapper.
+func connected(conn net.Conn, name, version, endpoint string) net.Conn {
+	Selections.Inc(name, version, endpoint)
+	DefaultMetrics.IncDial(name, version, endpoint)
+	if ConnWrapper != nil {
+		conn = ConnWrapper(conn, name, version, endpoint)
+	}
+	return Connections.track(conn, endpoint)
+}
+
+// dialFailed reports the dial failure of the endpoint to the registry and DefaultMetrics.
+func dialFailed(reg registry.Registry, name, version, endpoint string, err error) {
+	reg.Failure(name, version, endpoint, err)
+	DefaultMetrics.IncDialFailure(name, version, endpoint, err)
+}
+
+// dial returns the warm connection to the given endpoint opened by a
+// WarmingRegistry, if any, or connects to it with dialNew.
+func dial(network, endpoint string) (net.Conn, error) {
+	if conn := warmConns.take(network, endpoint); conn != nil {
 		return conn, nil
 	}
-	// No available endpoint.
-	return nil, fmt.Errorf("No endpoint available for %s/%s", serviceName, serviceVersion)
+	return dialNew(network, endpoint)
 }
 
-// NewMultipleHostReverseProxy creates a reverse proxy handler
-// that will randomly select a host from the passed `targets`
-func NewMultipleHostReverseProxy(reg registry.Registry) http.HandlerFunc {
-	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		Dial: func(network, addr string) (net.Conn, error) {
-			addr = strings.Split(addr, ":")[0]
-			tmp := strings.Split(addr, "/")
-			if len(tmp) != 2 {
-				return nil, ErrInvalidService
-			}
-			return LoadBalance(network, tmp[0], tmp[1], reg)
-		},
-		TLSHandshakeTimeout: 10 * time.Second,
+// dialNew connects to the given endpoint using Dialer and applies TCPNoDelay.
+// Transient resolution failures are retried up to ResolveRetries times.
+func dialNew(network, endpoint string) (net.Conn, error) {
+	conn, err := Dialer.Dial(network, endpoint)
+	for attempt := 0; err != nil && attempt < ResolveRetries && isTransientDNSError(err); attempt++ {
+		time.Sleep(ResolveBackoff.Next(attempt))
+		conn, err = Dialer.Dial(network, endpoint)
 	}
-	return func(w http.ResponseWriter, req *http.Request) {
-		name, version, err := ExtractNameVersion(req.URL)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(TCPNoDelay); err != nil {
+			conn.Close()
+			return nil, err
 		}
-		(&httputil.ReverseProxy{
-			Director: func(req *http.Request) {
-				req.URL.Scheme = "http"
-				req.URL.Host = name + "/" + version
-			},
-			Transport: transport,
-		}).ServeHTTP(w, req)
 	}
+	return conn, nil
+}
+
+// isTransientDNSError reports whether err is a resolution failure which may
+// succeed later, as opposed to a connection failure or a missing host.
+func isTransientDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && !dnsErr.IsNotFound
+}
+
+// NewMultipleHostReverseProxy creates a reverse proxy handler
+// that will randomly select a host from the passed `targets`
+func NewMultipleHostReverseProxy(reg registry.Registry) http.HandlerFunc {
+	return NewProxy(reg).ServeHTTP
 }
@@ -0,0 +1,1046 @@
+package goproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+	"github.com/creack/goproxy/registry/registrytest"
+)
+
+// newBackend starts a test server replying with its name and the requested path.
+func newBackend(t *testing.T, name string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, req.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxy(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	proxy := httptest.NewServer(NewProxy(reg))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/service1/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "backend1 /users" {
+		t.Fatalf("Unexpected response: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestExtractNameVersionRegexp(t *testing.T) {
+	extract := ExtractNameVersionRegexp(regexp.MustCompile(`^/api/(?P<version>v\d+)/(?P<name>[^/]+)(?P<rest>/.*)?$`), "/internal${rest}")
+
+	for _, tc := range []struct {
+		path, name, version, rewritten string
+	}{
+		{"/api/v1/service1/users", "service1", "v1", "/internal/users"},
+		{"/api/v2/service2", "service2", "v2", "/internal"},
+	} {
+		u := &url.URL{Path: tc.path}
+		name, version, err := extract(u)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", tc.path, err)
+		}
+		if name != tc.name || version != tc.version || u.Path != tc.rewritten {
+			t.Fatalf("Unexpected result for %s: %s/%s %s", tc.path, name, version, u.Path)
+		}
+	}
+
+	u := &url.URL{Path: "/other/path"}
+	if _, _, err := extract(u); err == nil {
+		t.Fatal("Expected an error for a non matching path")
+	}
+	if u.Path != "/other/path" {
+		t.Fatalf("Path should be left untouched on error, got %s", u.Path)
+	}
+}
+
+func TestExtractNameVersionRegexpMissingGroup(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic for a pattern without version group")
+		}
+	}()
+	ExtractNameVersionRegexp(regexp.MustCompile(`^/(?P<name>[^/]+)`), "/")
+}
+
+func TestExtractNameVersionRegexpOptionalGroup(t *testing.T) {
+	extract := ExtractNameVersionRegexp(regexp.MustCompile(`^/(?P<name>[^/]+)(?:/(?P<version>v\d+))?`), "/")
+	if _, _, err := extract(&url.URL{Path: "/service1/users"}); !errors.Is(err, ErrInvalidService) {
+		t.Fatalf("Unexpected error for an unmatched version group: %v", err)
+	}
+	if name, version, err := extract(&url.URL{Path: "/service1/v1"}); err != nil || name != "service1" || version != "v1" {
+		t.Fatalf("Unexpected result: %s/%s %v", name, version, err)
+	}
+}
+
+func TestLoadBalanceTiers(t *testing.T) {
+	// Reserve then release a port so the primary endpoint refuses connections.
+	down := httptest.NewServer(nil)
+	down.Close()
+	backup := newBackend(t, "backup")
+
+	reg := registry.NewPriorityRegistry()
+	reg.AddWithPriority("service1", "v1", strings.TrimPrefix(down.URL, "http://"), 0)
+	reg.AddWithPriority("service1", "v1", strings.TrimPrefix(backup.URL, "http://"), 1)
+
+	conn, err := loadBalance("tcp", "service1", "v1", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if expect := strings.TrimPrefix(backup.URL, "http://"); conn.RemoteAddr().String() != expect {
+		t.Fatalf("Expected connection to the backup tier %s, got %s", expect, conn.RemoteAddr())
+	}
+}
+
+func TestLoadBalanceErrors(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+	reg := registry.DefaultRegistry{"empty": {"v1": nil}}
+	reg.Add("down", "v1", strings.TrimPrefix(down.URL, "http://"))
+
+	_, err := loadBalance("tcp", "empty", "v1", reg)
+	if !errors.Is(err, registry.ErrNoEndpoints) || errors.Is(err, ErrEndpointsDown) {
+		t.Fatalf("Unexpected error for an empty pool: %v", err)
+	}
+	_, err = loadBalance("tcp", "down", "v1", reg)
+	if !errors.Is(err, ErrEndpointsDown) || !errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, registry.ErrNoEndpoints) {
+		t.Fatalf("Unexpected error for endpoints all down: %v", err)
+	}
+}
+
+func TestLoadBalanceTiersPrecedence(t *testing.T) {
+	primary, backup := newBackend(t, "primary"), newBackend(t, "backup")
+
+	reg := registry.NewPriorityRegistry()
+	reg.AddWithPriority("service1", "v1", strings.TrimPrefix(backup.URL, "http://"), 1)
+	reg.AddWithPriority("service1", "v1", strings.TrimPrefix(primary.URL, "http://"), 0)
+
+	for i := 0; i < 20; i++ {
+		conn, err := loadBalance("tcp", "service1", "v1", reg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if expect := strings.TrimPrefix(primary.URL, "http://"); conn.RemoteAddr().String() != expect {
+			t.Fatalf("Expected connection to the primary tier %s, got %s", expect, conn.RemoteAddr())
+		}
+	}
+}
+
+func TestExtractNameVersion(t *testing.T) {
+	for _, tc := range []struct {
+		path, name, version, rewritten string
+	}{
+		{"/service1/v1", "service1", "v1", "/"},
+		{"/service1/v1/", "service1", "v1", "/"},
+		{"/service1/v1/users/42", "service1", "v1", "/users/42"},
+		{"service1/v1/users", "service1", "v1", "/users"},
+		{"/service1//users", "service1", "", "/users"},
+	} {
+		u := &url.URL{Path: tc.path}
+		name, version, err := extractNameVersion(u)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", tc.path, err)
+		}
+		if name != tc.name || version != tc.version || u.Path != tc.rewritten {
+			t.Fatalf("Unexpected result for %s: %s/%s %s", tc.path, name, version, u.Path)
+		}
+	}
+	for _, path := range []string{"", "/service1"} {
+		if _, _, err := extractNameVersion(&url.URL{Path: path}); err == nil {
+			t.Fatalf("Expected an error for %q", path)
+		}
+	}
+}
+
+func TestParseServiceHost(t *testing.T) {
+	if name, version, err := parseServiceHost("service1/v1:80", '/'); err != nil || name != "service1" || version != "v1" {
+		t.Fatalf("Unexpected result: %s/%s, %v", name, version, err)
+	}
+	for _, addr := range []string{"service1:80", "a/b/c:80", ""} {
+		if _, _, err := parseServiceHost(addr, '/'); err != ErrInvalidService {
+			t.Fatalf("Expected %v for %q, got %v", ErrInvalidService, addr, err)
+		}
+	}
+}
+
+func BenchmarkExtractNameVersion(b *testing.B) {
+	u := &url.URL{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		u.Path = "/service1/v1/users/42"
+		if _, _, err := extractNameVersion(u); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseServiceHost(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := parseServiceHost("service1/v1:80", '/'); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadBalanceFailures(b *testing.B) {
+	// Invalid entries fail without dialing: only the selection
+	// and the removal of the failed endpoints are measured.
+	endpoints := make([]string, 10000)
+	reg := registry.DefaultRegistry{"service1": {"v1": endpoints}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := loadBalance("tcp", "service1", "v1", reg); err == nil {
+			b.Fatal("Expected all the endpoints to fail")
+		}
+	}
+}
+
+// failureRegistry is a DefaultRegistry recording the reported failures.
+type failureRegistry struct {
+	registry.DefaultRegistry
+	failures chan string
+}
+
+func (r failureRegistry) Failure(name, version, endpoint string, err error) {
+	r.failures <- name + "/" + version + " " + endpoint + ": " + err.Error()
+}
+
+func TestProxyFailureStatusCodes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+
+	reg := failureRegistry{DefaultRegistry: registry.DefaultRegistry{}, failures: make(chan string, 10)}
+	reg.Add("service1", "v1", endpoint)
+	p := NewProxy(reg)
+	p.FailureStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, path := range []string{"/service1/v1/up", "/service1/v1/down"} {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	select {
+	case failure := <-reg.failures:
+		if expect := "service1/v1 " + endpoint + ": Unexpected status 503 Service Unavailable"; failure != expect {
+			t.Fatalf("Unexpected failure: %q, expected %q", failure, expect)
+		}
+	default:
+		t.Fatal("Expected a failure to be reported")
+	}
+	if len(reg.failures) != 0 {
+		t.Fatalf("Unexpected extra failure: %s", <-reg.failures)
+	}
+}
+
+// dialRegistry is a DefaultRegistry recording the endpoints reported to Failure.
+type dialRegistry struct {
+	registry.DefaultRegistry
+	lock     sync.Mutex
+	failures []string
+}
+
+func (r *dialRegistry) Failure(name, version, endpoint string, err error) {
+	r.lock.Lock()
+	r.failures = append(r.failures, endpoint)
+	r.lock.Unlock()
+}
+
+func TestLoadBalanceConcurrentDelete(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	up := strings.TrimPrefix(backend.URL, "http://")
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	reg := &dialRegistry{DefaultRegistry: registry.DefaultRegistry{}}
+	stop, done := make(chan struct{}), make(chan struct{})
+	var cycles atomic.Int32
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// Yield between the updates for the lookups to see them all.
+			for j := 0; j < 5; j++ {
+				reg.Add("service1", "v1", fmt.Sprintf("%s%d", strings.TrimPrefix(down.URL, "http://"), j))
+				runtime.Gosched()
+			}
+			reg.Add("service1", "v1", up)
+			runtime.Gosched()
+			for j := 0; j < 5; j++ {
+				reg.Delete("service1", "v1", fmt.Sprintf("%s%d", strings.TrimPrefix(down.URL, "http://"), j))
+				runtime.Gosched()
+			}
+			reg.Delete("service1", "v1", up)
+			cycles.Add(1)
+		}
+	}()
+	// Keep balancing until the registry went through enough updates.
+	for i := 0; i < 500 || cycles.Load() < 100; i++ {
+		if endpoints, err := reg.Lookup("service1", "v1"); err == nil {
+			for _, endpoint := range endpoints {
+				if endpoint == "" {
+					t.Fatal("Lookup returned an empty endpoint")
+				}
+			}
+		}
+		if conn, err := loadBalance("tcp", "service1", "v1", reg); err == nil {
+			conn.Close()
+		}
+	}
+	close(stop)
+	<-done
+
+	// The failed dials are reported with their endpoint.
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	if len(reg.failures) == 0 {
+		t.Fatal("Expected failures to be reported")
+	}
+	for _, endpoint := range reg.failures {
+		if endpoint == "" {
+			t.Fatal("Empty endpoint reported to Failure")
+		}
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	backend := newBackend(t, "backend1")
+
+	defer func(d *net.Dialer, noDelay bool) { Dialer, TCPNoDelay = d, noDelay }(Dialer, TCPNoDelay)
+	var controlled bool
+	Dialer = &net.Dialer{
+		KeepAliveConfig: net.KeepAliveConfig{Enable: true, Idle: 10 * time.Second},
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = true
+			return nil
+		},
+	}
+	TCPNoDelay = false
+
+	conn, err := dial("tcp", strings.TrimPrefix(backend.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if !controlled {
+		t.Fatal("Expected the Dialer to be used")
+	}
+}
+
+func TestProxyRequestLogger(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", endpoint)
+
+	buf := &bytes.Buffer{}
+	p := NewProxy(reg)
+	p.RequestLogger = log.New(buf, "", 0)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/service1/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if expect := "service1/v1 " + endpoint + ": GET /users 200 "; !strings.HasPrefix(buf.String(), expect) {
+		t.Fatalf("Unexpected log: %q, expected prefix %q", buf.String(), expect)
+	}
+}
+
+func TestProxyExtractError(t *testing.T) {
+	defer func(extract func(*url.URL) (string, string, error)) { ExtractNameVersion = extract }(ExtractNameVersion)
+	ExtractNameVersion = func(target *url.URL) (string, string, error) {
+		if target.Path == "/panic" {
+			panic("boom")
+		}
+		return "", "", errors.New("Invalid path")
+	}
+
+	buf := &bytes.Buffer{}
+	p := NewProxy(registry.DefaultRegistry{})
+	p.RequestLogger = log.New(buf, "", 0)
+	for path, expect := range map[string]string{
+		"/invalid": "Invalid path\n",
+		"/panic":   "Failed to extract name/version: boom\n",
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusInternalServerError || w.Body.String() != expect {
+			t.Fatalf("Unexpected response for %s: %d %q", path, w.Code, w.Body.String())
+		}
+	}
+
+	// The ExtractErrorHandler responses are logged with their status.
+	buf.Reset()
+	p = NewProxy(registry.DefaultRegistry{})
+	p.RequestLogger = log.New(buf, "", 0)
+	p.ExtractErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/invalid", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status: %d", w.Code)
+	}
+	if expect := "/ : GET /invalid 404 "; !strings.HasPrefix(buf.String(), expect) {
+		t.Fatalf("Unexpected log: %q, expected prefix %q", buf.String(), expect)
+	}
+}
+
+func TestProxyMaxConns(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/block" {
+			<-release
+		}
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.MaxConns = 1
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Get(proxy.URL + "/service1/v1/block")
+		if err != nil {
+			t.Error(err)
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	for p.Stats().Conns != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, err := http.Get(proxy.URL + "/service1/v1/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected %d when the connection cap is reached, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if stats := p.Stats(); stats.Conns != 1 || stats.MaxConns != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	close(release)
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("Unexpected status for the blocked request: %d", status)
+	}
+
+	// The idle connection of service1 is closed to make room for service2.
+	reg.Add("service2", "v1", strings.TrimPrefix(newBackend(t, "backend2").URL, "http://"))
+	resp, err = http.Get(proxy.URL + "/service2/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status with an idle connection holding the cap: %d", resp.StatusCode)
+	}
+}
+
+func TestProxyExpectContinue(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n, err := io.Copy(io.Discard, req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintf(w, "%s %d", req.Header.Get("Expect"), n)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	proxy := httptest.NewServer(NewProxy(reg))
+	defer proxy.Close()
+
+	// The client waits for the 100 Continue up to 10 seconds before sending the body.
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+	const size = 1 << 20
+	req, err := http.NewRequest("POST", proxy.URL+"/service1/v1/upload", io.LimitReader(zeroReader{}, size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Expect", "100-continue")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := fmt.Sprintf("100-continue %d", size); resp.StatusCode != http.StatusOK || string(body) != expect {
+		t.Fatalf("Unexpected response: %d %q, expected %q", resp.StatusCode, body, expect)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("100 Continue was not relayed, the client waited %s", d)
+	}
+}
+
+func TestProxyStreamRequestBody(t *testing.T) {
+	const chunk, size = 1 << 20, 64 << 20
+	firstChunk := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n, err := io.CopyN(io.Discard, req.Body, chunk)
+		if err != nil {
+			t.Error(err)
+		}
+		close(firstChunk)
+		m, err := io.Copy(io.Discard, req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintf(w, "%d", n+m)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	proxy := httptest.NewServer(NewProxy(reg))
+	defer proxy.Close()
+
+	// The rest of the chunked body is only sent once the upstream got the first chunk.
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := io.CopyN(pw, zeroReader{}, chunk); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		select {
+		case <-firstChunk:
+		case <-time.After(5 * time.Second):
+			pw.CloseWithError(fmt.Errorf("The first chunk was not streamed upstream"))
+			return
+		}
+		_, err := io.CopyN(pw, zeroReader{}, size-chunk)
+		pw.CloseWithError(err)
+	}()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	resp, err := http.Post(proxy.URL+"/service1/v1/upload", "application/octet-stream", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if resp.StatusCode != http.StatusOK || string(body) != strconv.Itoa(size) {
+		t.Fatalf("Unexpected response: %d %q", resp.StatusCode, body)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > size/4 {
+		t.Fatalf("The body was buffered: %d bytes allocated for a %d bytes body", alloc, size)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
+
+func BenchmarkProxyServeHTTP(b *testing.B) {
+	payload := strings.Repeat("x", 64<<10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, payload)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	p := NewProxy(reg)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		w.Body = nil
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+		if w.Code != http.StatusOK {
+			b.Fatalf("Unexpected status: %d", w.Code)
+		}
+	}
+}
+
+func TestProxyMalformedHost(t *testing.T) {
+	p := NewProxy(registry.DefaultRegistry{})
+	p.once.Do(p.init)
+	p.reverseProxy.Director = func(req *http.Request) {
+		req.URL.Scheme = "http"
+		req.URL.Host = "malformed"
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	if w.Code != http.StatusInternalServerError || w.Body.String() != "Internal routing error\n" {
+		t.Fatalf("Unexpected response: %d %q", w.Code, w.Body.String())
+	}
+	if _, err := p.Dial("tcp", "malformed:80"); !errors.Is(err, ErrInvalidService) || !strings.Contains(err.Error(), `"malformed:80"`) {
+		t.Fatalf("Unexpected dial error: %v", err)
+	}
+}
+
+func TestExtractNameVersionQuery(t *testing.T) {
+	extract := ExtractNameVersionQuery("v")
+
+	for _, tc := range []struct {
+		url, name, version, path, query string
+	}{
+		{"/service1/users?v=2&id=1", "service1", "2", "/users", "id=1"},
+		{"/service1?v=2", "service1", "2", "/", ""},
+		{"/service1/v1/users?v=2", "service1", "2", "/v1/users", ""},
+		{"/service1/v1/users?id=1", "service1", "v1", "/users", "id=1"},
+		// The other parameters are kept as is, e.g. for signed URLs.
+		{"/service1/users?z=%2F&v=2&a=b+c&sig=x%3d", "service1", "2", "/users", "z=%2F&a=b+c&sig=x%3d"},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, version, err := extract(u)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %s", tc.url, err)
+		}
+		if name != tc.name || version != tc.version || u.Path != tc.path || u.RawQuery != tc.query {
+			t.Fatalf("Unexpected result for %s: %s/%s %s?%s", tc.url, name, version, u.Path, u.RawQuery)
+		}
+	}
+
+	if _, _, err := extract(&url.URL{Path: "/", RawQuery: "v=2"}); err == nil {
+		t.Fatal("Expected an error without name")
+	}
+}
+
+func TestProxyPausedService(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	reg := registry.NewPausingRegistry(registry.DefaultRegistry{})
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	p := NewProxy(reg)
+	p.RetryAfter = time.Minute
+
+	// The connection pooled by this request is not used once paused.
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status before pause: %d", w.Code)
+	}
+	reg.Pause("service1", "v1")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Unexpected response for a paused service: %d %v", w.Code, w.Header())
+	}
+
+	reg.Resume("service1", "v1")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status after resume: %d", w.Code)
+	}
+}
+
+func TestProxyMaxResponseBodyBytes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/stream" {
+			// Flush to stream the body without Content-Length.
+			w.Write([]byte("0123456789"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("0123456789"))
+			return
+		}
+		w.Write([]byte(strings.TrimPrefix(req.URL.Path, "/")))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.MaxResponseBodyBytes = 10
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, elem := range []struct {
+		path   string
+		status int
+	}{
+		{"/service1/v1/0123456789", http.StatusOK},
+		{"/service1/v1/0123456789a", http.StatusBadGateway},
+	} {
+		resp, err := http.Get(proxy.URL + elem.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != elem.status {
+			t.Fatalf("Unexpected status for %s: %d", elem.path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(proxy.URL + "/service1/v1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err == nil || len(body) > 10 {
+		t.Fatalf("Expected a truncated response, got %q (%v)", body, err)
+	}
+}
+
+func TestServiceFromContext(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", endpoint)
+
+	if _, _, ok := ServiceFromContext(context.Background()); ok {
+		t.Fatal("Unexpected service in an empty context")
+	}
+	req := withRoute(httptest.NewRequest("GET", "/users", nil), &route{name: "service1", version: "v1"})
+	if name, version, ok := ServiceFromContext(req.Context()); !ok || name != "service1" || version != "v1" {
+		t.Fatalf("Unexpected service: %s/%s (%t)", name, version, ok)
+	}
+	if _, ok := EndpointFromContext(req.Context()); ok {
+		t.Fatal("Unexpected endpoint before dialing")
+	}
+
+	outreq := req.Clone(req.Context())
+	outreq.RequestURI = ""
+	NewDirector("service1", "v1")(outreq)
+	resp, err := (&http.Transport{Dial: NewDialer(reg)}).RoundTrip(outreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got, ok := EndpointFromContext(req.Context()); !ok || got != endpoint {
+		t.Fatalf("Unexpected endpoint: %q (%t)", got, ok)
+	}
+}
+
+func TestProxyModifyResponse(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", endpoint)
+
+	p := NewProxy(reg)
+	p.ModifyResponse = func(resp *http.Response) error {
+		if resp.Request.URL.Path == "/fail" {
+			return fmt.Errorf("Rejected response")
+		}
+		name, version, _ := ServiceFromContext(resp.Request.Context())
+		got, _ := EndpointFromContext(resp.Request.Context())
+		resp.Header.Set("X-Upstream", name+"/"+version+" "+got)
+		return nil
+	}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/service1/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if expect := "service1/v1 " + endpoint; resp.Header.Get("X-Upstream") != expect {
+		t.Fatalf("Unexpected upstream header: %q, expected %q", resp.Header.Get("X-Upstream"), expect)
+	}
+
+	resp, err = http.Get(proxy.URL + "/service1/v1/fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+}
+
+func TestProxyDefaultService(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	fallback := newBackend(t, "fallback")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("default", "v1", strings.TrimPrefix(fallback.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.DefaultService, p.DefaultVersion = "default", "v1"
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for path, expect := range map[string]string{
+		"/service1/v1/users": "backend1 /users",
+		"/service2/v1/users": "fallback /service2/v1/users",
+		"/service1/v2/users": "fallback /service1/v2/users",
+		"/favicon.ico":       "fallback /favicon.ico",
+	} {
+		resp, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != expect {
+			t.Fatalf("Unexpected response for %s: %q, expected %q", path, body, expect)
+		}
+	}
+}
+
+func TestProxyDefaultServiceLookups(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(w, "fallback %s %s", req.URL.Path, body)
+	}))
+	defer fallback.Close()
+	reg := registrytest.New()
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("default", "v1", strings.TrimPrefix(fallback.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.DefaultService, p.DefaultVersion = "default", "v1"
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, tc := range []struct {
+		path, expect string
+		lookups      []string
+	}{
+		{"/service1/v1/users", "backend1 /users", []string{"service1/v1"}},
+		{"/service2/v1/users", "fallback /service2/v1/users hello", []string{"service2/v1", "default/v1"}},
+	} {
+		reg.Reset()
+		resp, err := http.Post(proxy.URL+tc.path, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tc.expect {
+			t.Fatalf("Unexpected response for %s: %q, expected %q", tc.path, body, tc.expect)
+		}
+		var lookups []string
+		for _, call := range reg.Calls("Lookup") {
+			lookups = append(lookups, call.Name+"/"+call.Version)
+		}
+		if !reflect.DeepEqual(lookups, tc.lookups) {
+			t.Fatalf("Unexpected lookups for %s: %v, expected %v", tc.path, lookups, tc.lookups)
+		}
+	}
+}
+
+func TestProxyLookupErrors(t *testing.T) {
+	reg := registry.DefaultRegistry{"service1": {"v1": {}}}
+	p := NewProxy(reg)
+	for path, status := range map[string]int{
+		"/service1/v1/": http.StatusServiceUnavailable,
+		"/service2/v1/": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Fatalf("Unexpected status for %s: %d, expected %d", path, w.Code, status)
+		}
+	}
+}
+
+func TestProxyFilter(t *testing.T) {
+	backend := newBackend(t, "backend")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", backend.Listener.Addr().String())
+	p := NewProxy(reg)
+	p.Filter = func(w http.ResponseWriter, req *http.Request) bool {
+		if req.Header.Get("X-Allowed") == "" {
+			http.Error(w, "Maintenance", http.StatusServiceUnavailable)
+			return false
+		}
+		return true
+	}
+	for _, tc := range []struct {
+		path    string
+		allowed bool
+		status  int
+	}{
+		{"/service1/v1/", true, http.StatusOK},
+		{"/service1/v1/", false, http.StatusServiceUnavailable},
+		// The filter applies before the extraction.
+		{"/invalid", true, http.StatusInternalServerError},
+		{"/invalid", false, http.StatusServiceUnavailable},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.allowed {
+			req.Header.Set("X-Allowed", "1")
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Fatalf("Unexpected status for %s (allowed: %t): %d, expected %d", tc.path, tc.allowed, w.Code, tc.status)
+		}
+	}
+}
+
+func TestProxyDebugErrors(t *testing.T) {
+	down := httptest.NewServer(nil)
+	down.Close()
+	endpoint := strings.TrimPrefix(down.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", endpoint)
+
+	for _, debug := range []bool{false, true} {
+		p := NewProxy(reg)
+		p.DebugErrors = debug
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("Unexpected status: %d", w.Code)
+		}
+		body := w.Body.String()
+		if !debug && body != "" {
+			t.Fatalf("Unexpected error body without debug: %q", body)
+		}
+		if debug && (!strings.Contains(body, "service: service1/v1\n") || !strings.Contains(body, "No endpoint available for service1/v1")) {
+			t.Fatalf("Unexpected debug error body: %q", body)
+		}
+	}
+}
+
+// singleRegistry is a registry.SingleLookuper counting the full lookups.
+type singleRegistry struct {
+	registry.DefaultRegistry
+	one     string
+	lookups int
+}
+
+func (r *singleRegistry) LookupOne(name, version string) (string, error) {
+	return r.one, nil
+}
+
+func (r *singleRegistry) Lookup(name, version string) ([]string, error) {
+	r.lookups++
+	return r.DefaultRegistry.Lookup(name, version)
+}
+
+func TestLoadBalanceLookupOne(t *testing.T) {
+	backend := newBackend(t, "backend")
+	down := httptest.NewServer(nil)
+	down.Close()
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+
+	reg := &singleRegistry{DefaultRegistry: registry.DefaultRegistry{}, one: endpoint}
+	reg.Add("service1", "v1", endpoint)
+	conn, err := loadBalance("tcp", "service1", "v1", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if reg.lookups != 0 {
+		t.Fatalf("Unexpected full lookups: %d", reg.lookups)
+	}
+
+	// When the single endpoint fails, the full list is used.
+	reg.one = strings.TrimPrefix(down.URL, "http://")
+	conn, err = loadBalance("tcp", "service1", "v1", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if reg.lookups != 1 {
+		t.Fatalf("Unexpected full lookups: %d", reg.lookups)
+	}
+}
+
+func TestDialResolveRetries(t *testing.T) {
+	defer func(d *net.Dialer, retries int, backoff BackoffPolicy) {
+		Dialer, ResolveRetries, ResolveBackoff = d, retries, backoff
+	}(Dialer, ResolveRetries, ResolveBackoff)
+	ResolveBackoff = ConstantBackoff(time.Millisecond)
+
+	// Count the queries sent to a failing DNS server.
+	var queries atomic.Int32
+	Dialer = &net.Dialer{Resolver: &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		queries.Add(1)
+		return nil, errors.New("server failure")
+	}}}
+	attempts := func(retries int) int {
+		ResolveRetries = retries
+		queries.Store(0)
+		if _, err := dial("tcp", "service1.example:80"); !isTransientDNSError(err) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return int(queries.Load())
+	}
+	if once, thrice := attempts(0), attempts(2); once == 0 || thrice != 3*once {
+		t.Fatalf("Unexpected queries: %d without retries, %d with 2 retries", once, thrice)
+	}
+
+	for err, expect := range map[error]bool{
+		&net.DNSError{Err: "no such host", IsNotFound: true}: false,
+		&net.DNSError{Err: "timeout", IsTimeout: true}:       true,
+		&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}:  false,
+	} {
+		if isTransientDNSError(err) != expect {
+			t.Errorf("Unexpected transient status for %v, expected %t", err, expect)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package grpcweb translates the gRPC-Web requests of browsers to gRPC
+// requests to the endpoints of a goproxy registry, and their responses back.
+package grpcweb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/creack/goproxy"
+	"github.com/creack/goproxy/registry"
+)
+
+// gRPC status codes used for the errors of the Handler.
+const (
+	codeUnimplemented = 12
+	codeUnavailable   = 14
+)
+
+// Handler is an http.Handler proxying gRPC-Web requests to gRPC endpoints over
+// cleartext HTTP/2. The service name/version is resolved by goproxy.ExtractNameVersion,
+// i.e. clients use `/<name>/<version>` as base URL, and the endpoints are
+// selected by goproxy.LoadBalance.
+//
+// Both the binary (application/grpc-web) and the base64 encoded
+// (application/grpc-web-text) variants are supported. The gRPC trailers are
+// sent to the client in the trailer frame of the response body.
+type Handler struct {
+	proxy *httputil.ReverseProxy
+}
+
+// NewHandler creates a Handler routing requests to the endpoints of the given registry.
+func NewHandler(reg registry.Registry) *Handler {
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	return &Handler{proxy: &httputil.ReverseProxy{
+		Director:       director,
+		Transport:      &http.Transport{Dial: goproxy.NewDialer(reg), Protocols: protocols},
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   errorHandler,
+	}}
+}
+
+type contextKey int
+
+const callContextKey contextKey = iota
+
+// call holds the routing decisions made for a request.
+type call struct {
+	name, version string
+	text          bool // Whether the client uses application/grpc-web-text.
+}
+
+// ServeHTTP proxies the gRPC-Web request to an endpoint of the requested service.
+// Other requests get a 415 Unsupported Media Type.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	contentType := req.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/grpc-web") {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	name, version, err := goproxy.ExtractNameVersion(req.URL)
+	if err != nil {
+		writeError(w, codeUnimplemented, err.Error())
+		return
+	}
+	// gRPC servers require the TE header, which the ReverseProxy only
+	// forwards when set on the incoming request.
+	req.Header.Set("Te", "trailers")
+	c := &call{name: name, version: version, text: strings.HasPrefix(contentType, "application/grpc-web-text")}
+	h.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), callContextKey, c)))
+}
+
+// director routes the outgoing request to the resolved service and
+// turns it into a gRPC request.
+func director(req *http.Request) {
+	c := req.Context().Value(callContextKey).(*call)
+	goproxy.NewDirector(c.name, c.version)(req)
+
+	contentType := req.Header.Get("Content-Type")
+	if c.text {
+		contentType = strings.Replace(contentType, "application/grpc-web-text", "application/grpc", 1)
+		req.Body = io.NopCloser(base64.NewDecoder(base64.StdEncoding, req.Body))
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+	} else {
+		contentType = strings.Replace(contentType, "application/grpc-web", "application/grpc", 1)
+	}
+	req.Header.Set("Content-Type", contentType)
+}
+
+// modifyResponse turns the gRPC response into a gRPC-Web response.
+func modifyResponse(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/grpc") {
+		return nil
+	}
+	c := resp.Request.Context().Value(callContextKey).(*call)
+	if c.text {
+		resp.Header.Set("Content-Type", strings.Replace(contentType, "application/grpc", "application/grpc-web-text", 1))
+	} else {
+		resp.Header.Set("Content-Type", strings.Replace(contentType, "application/grpc", "application/grpc-web", 1))
+	}
+	// The body is rewritten and the trailers moved into it.
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Trailer")
+	resp.ContentLength = -1
+	resp.Trailer = nil
+	resp.Body = &responseBody{ReadCloser: resp.Body, resp: resp, text: c.text, buf: make([]byte, 32*1024)}
+	return nil
+}
+
+// errorHandler replies with a gRPC UNAVAILABLE status to the requests
+// which could not be proxied.
+func errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	log.Printf("grpcweb: proxy error: %v", err)
+	writeError(w, codeUnavailable, err.Error())
+}
+
+// writeError replies with the given gRPC status as a trailers-only response.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc-web+proto")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// responseBody is a gRPC response body followed by the gRPC-Web trailer frame,
+// base64 encoded for the text variant.
+type responseBody struct {
+	io.ReadCloser
+	resp    *http.Response
+	text    bool
+	buf     []byte
+	pending []byte // Translated bytes not read yet.
+	done    bool
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		n, err := b.ReadCloser.Read(b.buf)
+		chunk := b.buf[:n]
+		if err == io.EOF {
+			// The trailers are set by the Transport once the body is consumed.
+			chunk = append(chunk[:n:n], trailerFrame(b.resp)...)
+			b.resp.Trailer = nil
+			b.done = true
+		} else if err != nil {
+			return 0, err
+		}
+		if b.text && len(chunk) > 0 {
+			// Each chunk is encoded with its own padding, so it can be flushed.
+			chunk = []byte(base64.StdEncoding.EncodeToString(chunk))
+		}
+		b.pending = chunk
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// trailerFrame encodes the gRPC trailers of resp as a gRPC-Web trailer frame.
+// For trailers-only responses, the status is read from the headers.
+func trailerFrame(resp *http.Response) []byte {
+	trailer := resp.Trailer
+	if trailer.Get("Grpc-Status") == "" {
+		trailer = http.Header{}
+		for key, values := range resp.Header {
+			if strings.HasPrefix(key, "Grpc-") && key != "Grpc-Encoding" && key != "Grpc-Accept-Encoding" {
+				trailer[key] = values
+			}
+		}
+	}
+	keys := make([]string, 0, len(trailer))
+	for key := range trailer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	frame := make([]byte, 5)
+	frame[0] = 0x80
+	for _, key := range keys {
+		for _, value := range trailer[key] {
+			frame = append(frame, strings.ToLower(key)+": "+value+"\r\n"...)
+		}
+	}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(frame)-5))
+	return frame
+}
@@ -0,0 +1,104 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// frame encodes a gRPC message frame.
+func frame(message string) []byte {
+	b := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(message)))
+	return append(b, message...)
+}
+
+// newGRPCBackend starts a cleartext HTTP/2 server echoing the gRPC messages
+// prefixed with the method path.
+func newGRPCBackend(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 || req.Header.Get("Content-Type") != "application/grpc+proto" || req.Header.Get("Te") != "trailers" {
+			t.Errorf("Unexpected gRPC request: %s %q %q", req.Proto, req.Header.Get("Content-Type"), req.Header.Get("Te"))
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil || len(body) < 5 {
+			t.Errorf("Unexpected request body: %q (%v)", body, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Write(frame(req.URL.Path + " " + string(body[5:])))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "OK")
+	}))
+	srv.Config.Protocols = &http.Protocols{}
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHandler(t *testing.T) {
+	backend := newGRPCBackend(t)
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	proxy := httptest.NewServer(NewHandler(reg))
+	defer proxy.Close()
+
+	expect := string(frame("/pkg.Echo/Say hello")) + "\x80\x00\x00\x00\x22grpc-message: OK\r\ngrpc-status: 0\r\n"
+	for _, text := range []bool{false, true} {
+		contentType, body := "application/grpc-web+proto", string(frame("hello"))
+		if text {
+			contentType, body = "application/grpc-web-text+proto", base64.StdEncoding.EncodeToString([]byte(body))
+		}
+		resp, err := http.Post(proxy.URL+"/service1/v1/pkg.Echo/Say", contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentType || len(resp.Trailer) != 0 {
+			t.Fatalf("Unexpected response: %d %q %v", resp.StatusCode, resp.Header.Get("Content-Type"), resp.Trailer)
+		}
+		if text {
+			// Each flushed chunk is padded on its own.
+			var decoded []byte
+			for _, chunk := range strings.SplitAfter(string(got), "=") {
+				b, err := base64.StdEncoding.DecodeString(chunk)
+				if err != nil && chunk != "" {
+					t.Fatalf("Unexpected base64 chunk %q: %s", chunk, err)
+				}
+				decoded = append(decoded, b...)
+			}
+			got = decoded
+		}
+		if string(got) != expect {
+			t.Fatalf("Unexpected response body: %q, expected %q", got, expect)
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	reg := registry.DefaultRegistry{}
+	h := NewHandler(reg)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/service1/v1/pkg.Echo/Say", nil))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Unexpected status for a non gRPC-Web request: %d", w.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/service1/v1/pkg.Echo/Say", strings.NewReader(string(frame("hello"))))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Grpc-Status") != "14" {
+		t.Fatalf("Unexpected response for an unknown service: %d %v", w.Code, w.Header())
+	}
+}
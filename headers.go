@@ -0,0 +1,38 @@
+package goproxy
+
+import "net/http"
+
+// injectHeaders sets the configured response headers of the service name/version on h.
+func (p *Proxy) injectHeaders(h http.Header, name, version string) {
+	service := p.serviceResponseHeaders(name, version)
+	for key, values := range p.ResponseHeaders {
+		if _, ok := service[key]; !ok {
+			setHeader(h, key, values)
+		}
+	}
+	for key, values := range service {
+		setHeader(h, key, values)
+	}
+}
+
+// headerSize returns the size of the header, each field counted as `Key: value\r\n`.
+func headerSize(h http.Header) int {
+	size := 0
+	for key, values := range h {
+		for _, value := range values {
+			size += len(key) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
+
+// setHeader replaces the values of the given header, unless it is a framing header.
+func setHeader(h http.Header, key string, values []string) {
+	switch key = http.CanonicalHeaderKey(key); key {
+	case "Content-Length", "Transfer-Encoding", "Connection", "Upgrade", "Trailer":
+		return
+	}
+	if len(values) > 0 {
+		h[key] = append([]string(nil), values...)
+	}
+}
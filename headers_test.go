@@ -0,0 +1,103 @@
+package goproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Write([]byte("hello"))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("service2", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("service3", "v1", "")
+
+	p := NewProxy(reg)
+	p.ResponseHeaders = http.Header{
+		"X-Served-By":     {"goproxy"},
+		"X-Frame-Options": {"DENY"},
+		"Content-Length":  {"1000"},
+	}
+	p.ServiceResponseHeaders = func(name, version string) http.Header {
+		if name == "service2" {
+			return http.Header{"X-Served-By": {"goproxy-2"}, "X-Frame-Options": nil}
+		}
+		return nil
+	}
+
+	for _, tc := range []struct {
+		path                 string
+		servedBy, frame, len string
+	}{
+		{"/service1/v1/", "goproxy", "DENY", "5"},
+		{"/service2/v1/", "goproxy-2", "SAMEORIGIN", "5"},
+		{"/service3/v1/", "goproxy", "DENY", ""}, // Error responses get them too.
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		h := w.Result().Header
+		if h.Get("X-Served-By") != tc.servedBy || h.Get("X-Frame-Options") != tc.frame || h.Get("Content-Length") != tc.len {
+			t.Fatalf("Unexpected headers for %s: %v", tc.path, h)
+		}
+	}
+}
+
+func TestProxyMaxRequestHeaderBytes(t *testing.T) {
+	backend := newBackend(t, "backend")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	p := NewProxy(reg)
+	p.MaxRequestHeaderBytes = 64
+
+	for size, status := range map[int]int{
+		10:  http.StatusOK,
+		100: http.StatusRequestHeaderFieldsTooLarge,
+	} {
+		req := httptest.NewRequest("GET", "/service1/v1/", nil)
+		req.Header.Set("X-Data", strings.Repeat("a", size))
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("Unexpected status for a %d bytes header: %d, expected %d", size, w.Code, status)
+		}
+	}
+	if n := headerSize(http.Header{"A": {"b", "cd"}}); n != 13 {
+		t.Fatalf("Unexpected header size: %d", n)
+	}
+}
+
+func TestProxyServerTiming(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Server-Timing", "db;dur=12")
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.ServerTiming = true
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	timing := w.Header().Values("Server-Timing")
+	if len(timing) != 2 || timing[0] != "db;dur=12" {
+		t.Fatalf("Unexpected Server-Timing: %q", timing)
+	}
+	var dial, upstream float64
+	if _, err := fmt.Sscanf(timing[1], "upstream-dial;dur=%g, upstream;dur=%g", &dial, &upstream); err != nil {
+		t.Fatalf("Unexpected Server-Timing %q: %s", timing[1], err)
+	}
+	if dial < 0 || upstream < 20 || dial > upstream {
+		t.Fatalf("Unexpected durations: dial %g, upstream %g", dial, upstream)
+	}
+}
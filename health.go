@@ -0,0 +1,382 @@
+package goproxy
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// HealthChecker wraps a Registry to actively probe its endpoints and
+// exclude the unhealthy ones from lookups. The wrapped registry must be
+// registry.Enumerable for its endpoints to be probed.
+//
+// Each endpoint is probed once per Interval at an offset derived from
+// its hash, so the probes are spread across the interval instead of
+// hitting all the endpoints at once.
+type HealthChecker struct {
+	registry.Registry
+
+	// Probe checks the given endpoint, which is unhealthy when it fails.
+	// When nil, a TCP connection is opened with Dialer.
+	Probe func(ctx context.Context, name, version, endpoint string) error
+
+	// Interval between two probes of an endpoint. When zero, 10 seconds is used.
+	Interval time.Duration
+
+	// Jitter, when positive, delays each probe by a random duration up to Jitter.
+	Jitter time.Duration
+
+	// Timeout bounds each probe. When zero, Interval is used.
+	Timeout time.Duration
+
+	// ProbeTimings, when set, returns the Interval and Timeout of the probes
+	// of the given service name/version, zero for the HealthChecker ones.
+	ProbeTimings func(name, version string) (interval, timeout time.Duration)
+
+	// MaxConcurrentProbes, when positive, caps the number of probes in flight.
+	MaxConcurrentProbes int
+
+	// Backoff, when set, schedules the next probe of a failing endpoint
+	// instead of Interval, e.g. to detect its recovery sooner.
+	Backoff BackoffPolicy
+
+	// EjectThreshold, when positive, ejects the endpoints whose failures
+	// reported to Failure, e.g. by the balancers, add up to EjectThreshold
+	// penalty points, until their next successful probe. Each failure costs
+	// the penalty of its ClassifyFailure kind in FailurePenalties.
+	EjectThreshold int
+
+	// FailurePenalties are the penalty points of each FailureKind.
+	// When nil, DefaultFailurePenalties is used. Missing kinds cost 1 point.
+	FailurePenalties map[FailureKind]int
+
+	lock   sync.RWMutex
+	states map[healthKey]*healthState
+}
+
+type healthKey struct {
+	name, version, endpoint string
+}
+
+type healthState struct {
+	unhealthy bool
+	failures  int
+	penalty   int // Points of the failures reported since the last successful probe.
+	probing   bool
+	next      time.Time
+}
+
+// NewHealthChecker creates a HealthChecker wrapping the given registry.
+// Probing starts with Start.
+func NewHealthChecker(reg registry.Registry) *HealthChecker {
+	return &HealthChecker{Registry: reg, states: map[healthKey]*healthState{}}
+}
+
+// Healthy reports whether the given endpoint for the service name/version
+// passed its last probe. Endpoints not probed yet are healthy.
+func (h *HealthChecker) Healthy(name, version, endpoint string) bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	state, ok := h.states[healthKey{name, version, endpoint}]
+	return !ok || !state.unhealthy
+}
+
+// Lookup return the endpoint list for the given service name/version,
+// excluding the unhealthy endpoints.
+func (h *HealthChecker) Lookup(name, version string) ([]string, error) {
+	endpoints, err := h.Registry.Lookup(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return h.filter(name, version, endpoints), nil
+}
+
+// LookupTiers returns the endpoint tiers for the given service name/version,
+// excluding the unhealthy endpoints.
+func (h *HealthChecker) LookupTiers(name, version string) ([][]string, error) {
+	tiers, err := registry.LookupTiers(h.Registry, name, version)
+	if err != nil {
+		return nil, err
+	}
+	for i, tier := range tiers {
+		tiers[i] = h.filter(name, version, tier)
+	}
+	return tiers, nil
+}
+
+// Failure reports the failure to the wrapped registry and, with EjectThreshold,
+// ejects the endpoint once its penalty points reach the threshold.
+func (h *HealthChecker) Failure(name, version, endpoint string, err error) {
+	h.Registry.Failure(name, version, endpoint, err)
+	if h.EjectThreshold <= 0 {
+		return
+	}
+	penalties := h.FailurePenalties
+	if penalties == nil {
+		penalties = DefaultFailurePenalties
+	}
+	penalty, ok := penalties[ClassifyFailure(err)]
+	if !ok {
+		penalty = 1
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := healthKey{name, version, endpoint}
+	state, ok := h.states[key]
+	if !ok {
+		state = &healthState{next: time.Now().Add(h.offset(key))}
+		h.states[key] = state
+	}
+	if state.penalty += penalty; state.penalty >= h.EjectThreshold {
+		state.unhealthy = true
+	}
+}
+
+// RecoveryEstimator is implemented by the registries able to estimate when
+// an endpoint of a service may be available again. The Proxy uses it as
+// Retry-After of the 503 Service Unavailable responses of the services
+// without available endpoint. It must be implemented by the Proxy registry
+// itself: the registry wrappers don't forward it.
+type RecoveryEstimator interface {
+	RecoveryEstimate(name, version string) (time.Duration, bool) // Return the delay before an endpoint of the service may recover, if known
+}
+
+// RecoveryEstimate returns the delay before the next probe of the unhealthy
+// endpoints of the given service name/version, zero when one is in flight.
+// It returns false when none of its endpoints is known to be unhealthy.
+func (h *HealthChecker) RecoveryEstimate(name, version string) (time.Duration, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var estimate time.Duration
+	known := false
+	for key, state := range h.states {
+		if key.name != name || key.version != version || !state.unhealthy {
+			continue
+		}
+		d := time.Until(state.next)
+		if state.probing || d < 0 {
+			d = 0
+		}
+		if !known || d < estimate {
+			estimate, known = d, true
+		}
+	}
+	return estimate, known
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (h *HealthChecker) Enumerate() map[string][]string {
+	return registry.Enumerate(h.Registry)
+}
+
+// filter returns a copy of endpoints without the unhealthy ones.
+func (h *HealthChecker) filter(name, version string, endpoints []string) []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	ret := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if state, ok := h.states[healthKey{name, version, endpoint}]; !ok || !state.unhealthy {
+			ret = append(ret, endpoint)
+		}
+	}
+	return ret
+}
+
+// Start probes the endpoints in a new goroutine.
+// The returned function stops it and waits for the probes in flight.
+func (h *HealthChecker) Start() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.run(ctx, &wg)
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// run schedules the probes until ctx is done.
+func (h *HealthChecker) run(ctx context.Context, wg *sync.WaitGroup) {
+	var sem chan struct{}
+	if h.MaxConcurrentProbes > 0 {
+		sem = make(chan struct{}, h.MaxConcurrentProbes)
+	}
+	for {
+		wait := h.interval()
+		for _, key := range h.schedule(time.Now()) {
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			wg.Add(1)
+			go func(key healthKey) {
+				defer wg.Done()
+				h.probe(ctx, key)
+				if sem != nil {
+					<-sem
+				}
+			}(key)
+		}
+		h.lock.RLock()
+		for _, state := range h.states {
+			if d := time.Until(state.next); !state.probing && d < wait {
+				wait = d
+			}
+		}
+		h.lock.RUnlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// schedule syncs the states with the registry endpoints and returns
+// the endpoints due for a probe, marking them as probing.
+func (h *HealthChecker) schedule(now time.Time) []healthKey {
+	seen := map[healthKey]bool{}
+	for name, versions := range registry.Enumerate(h.Registry) {
+		for _, version := range versions {
+			endpoints, _ := h.Registry.Lookup(name, version)
+			for _, endpoint := range endpoints {
+				seen[healthKey{name, version, endpoint}] = true
+			}
+		}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var due []healthKey
+	for key := range h.states {
+		if !seen[key] {
+			delete(h.states, key)
+		}
+	}
+	for key := range seen {
+		state, ok := h.states[key]
+		if !ok {
+			state = &healthState{next: now.Add(h.offset(key))}
+			h.states[key] = state
+		}
+		if !state.probing && !now.Before(state.next) {
+			state.probing = true
+			due = append(due, key)
+		}
+	}
+	return due
+}
+
+// probe checks the given endpoint and schedules its next probe.
+func (h *HealthChecker) probe(ctx context.Context, key healthKey) {
+	if h.Jitter > 0 {
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(h.Jitter))))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, h.timeoutOf(key))
+	probe := h.Probe
+	if probe == nil {
+		probe = dialProbe
+	}
+	err := probe(probeCtx, key.name, key.version, key.endpoint)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		h.Registry.Failure(key.name, key.version, key.endpoint, err)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	state, ok := h.states[key]
+	if !ok {
+		return
+	}
+	state.probing = false
+	state.next = time.Now().Add(h.intervalOf(key))
+	if err == nil {
+		state.unhealthy, state.failures, state.penalty = false, 0, 0
+		return
+	}
+	state.unhealthy = true
+	state.failures++
+	if h.Backoff != nil {
+		state.next = time.Now().Add(h.Backoff.Next(state.failures - 1))
+	}
+}
+
+// interval returns the default probe interval.
+func (h *HealthChecker) interval() time.Duration {
+	if h.Interval == 0 {
+		return 10 * time.Second
+	}
+	return h.Interval
+}
+
+// intervalOf returns the probe interval of the given endpoint.
+func (h *HealthChecker) intervalOf(key healthKey) time.Duration {
+	if h.ProbeTimings != nil {
+		if interval, _ := h.ProbeTimings(key.name, key.version); interval > 0 {
+			return interval
+		}
+	}
+	return h.interval()
+}
+
+// timeoutOf returns the probe timeout of the given endpoint.
+func (h *HealthChecker) timeoutOf(key healthKey) time.Duration {
+	if h.ProbeTimings != nil {
+		if _, timeout := h.ProbeTimings(key.name, key.version); timeout > 0 {
+			return timeout
+		}
+	}
+	if h.Timeout == 0 {
+		return h.intervalOf(key)
+	}
+	return h.Timeout
+}
+
+// offset returns the delay of the first probe of the given endpoint,
+// derived from its hash to spread the probes across the interval.
+func (h *HealthChecker) offset(key healthKey) time.Duration {
+	hash := fnv.New64a()
+	hash.Write([]byte(key.name))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key.version))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key.endpoint))
+	return time.Duration(hash.Sum64() % uint64(h.intervalOf(key)))
+}
+
+// dialProbe opens and closes a TCP connection to the endpoint with Dialer.
+func dialProbe(ctx context.Context, name, version, endpoint string) error {
+	conn, err := Dialer.DialContext(ctx, "tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
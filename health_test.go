@@ -0,0 +1,190 @@
+package goproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestHealthChecker(t *testing.T) {
+	up := newBackend(t, "up")
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(up.URL, "http://"))
+	reg.Add("service1", "v1", strings.TrimPrefix(down.URL, "http://"))
+	h := NewHealthChecker(reg)
+	h.Interval = 10 * time.Millisecond
+	stop := h.Start()
+	defer stop()
+
+	for i := 0; ; i++ {
+		endpoints, err := h.Lookup("service1", "v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(endpoints) == 1 {
+			if endpoints[0] != strings.TrimPrefix(up.URL, "http://") {
+				t.Fatalf("Unexpected healthy endpoints: %v", endpoints)
+			}
+			break
+		}
+		if i == 1000 {
+			t.Fatal("The down endpoint was not excluded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if h.Healthy("service1", "v1", strings.TrimPrefix(down.URL, "http://")) {
+		t.Fatal("Down endpoint reported healthy")
+	}
+}
+
+func TestHealthCheckerOffset(t *testing.T) {
+	h := NewHealthChecker(registry.DefaultRegistry{})
+	h.Interval = time.Second
+
+	offsets := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		key := healthKey{"service1", "v1", fmt.Sprintf("host%d:80", i)}
+		offset := h.offset(key)
+		if offset < 0 || offset >= h.Interval || offset != h.offset(key) {
+			t.Fatalf("Unexpected offset for %v: %s", key, offset)
+		}
+		offsets[offset] = true
+	}
+	if len(offsets) < 90 {
+		t.Fatalf("Offsets are not spread: %d distinct values", len(offsets))
+	}
+}
+
+func TestHealthCheckerMaxConcurrentProbes(t *testing.T) {
+	reg := registry.DefaultRegistry{}
+	for i := 0; i < 10; i++ {
+		reg.Add("service1", "v1", fmt.Sprintf("host%d:80", i))
+	}
+
+	var (
+		mu               sync.Mutex
+		inFlight, max, n int
+	)
+	h := NewHealthChecker(reg)
+	h.Interval = time.Millisecond
+	h.MaxConcurrentProbes = 2
+	h.Probe = func(ctx context.Context, name, version, endpoint string) error {
+		mu.Lock()
+		inFlight++
+		if inFlight > max {
+			max = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		n++
+		mu.Unlock()
+		return nil
+	}
+	stop := h.Start()
+	for i := 0; ; i++ {
+		mu.Lock()
+		done := n >= 10
+		mu.Unlock()
+		if done {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("The endpoints were not probed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	if max != 2 {
+		t.Fatalf("Unexpected probe concurrency: %d", max)
+	}
+}
+
+func TestProxyRetryAfterRecoveryEstimate(t *testing.T) {
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", "host1:80")
+	reg.Add("service1", "v1", "host2:80")
+	h := NewHealthChecker(reg)
+	now := time.Now()
+	h.states[healthKey{"service1", "v1", "host1:80"}] = &healthState{unhealthy: true, next: now.Add(5 * time.Second)}
+	h.states[healthKey{"service1", "v1", "host2:80"}] = &healthState{unhealthy: true, next: now.Add(time.Minute)}
+
+	retryAfter := func(reg registry.Registry) string {
+		w := httptest.NewRecorder()
+		NewProxy(reg).ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Unexpected status: %d", w.Code)
+		}
+		return w.Header().Get("Retry-After")
+	}
+	if got := retryAfter(h); got != "5" {
+		t.Fatalf("Unexpected Retry-After for the next probe: %q", got)
+	}
+	h.states[healthKey{"service1", "v1", "host1:80"}].probing = true
+	if got := retryAfter(h); got != "1" {
+		t.Fatalf("Unexpected Retry-After for a probe in flight: %q", got)
+	}
+
+	// Without estimate, the default applies.
+	if got := retryAfter(registry.DefaultRegistry{"service1": {"v1": nil}}); got != "30" {
+		t.Fatalf("Unexpected default Retry-After: %q", got)
+	}
+}
+
+func TestHealthCheckerFailurePenalties(t *testing.T) {
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", "host1:80")
+	reg.Add("service1", "v1", "host2:80")
+	h := NewHealthChecker(reg)
+	h.EjectThreshold = 4
+
+	// Timeouts cost 1 point, a refused connection 4.
+	timeout := &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}
+	for i := 0; i < 3; i++ {
+		h.Failure("service1", "v1", "host1:80", timeout)
+	}
+	if !h.Healthy("service1", "v1", "host1:80") {
+		t.Fatal("Endpoint ejected before reaching the threshold")
+	}
+	h.Failure("service1", "v1", "host1:80", timeout)
+	h.Failure("service1", "v1", "host2:80", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED})
+	if endpoints, err := h.Lookup("service1", "v1"); err != nil || len(endpoints) != 0 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	// A successful probe restores the endpoint.
+	h.Probe = func(ctx context.Context, name, version, endpoint string) error { return nil }
+	h.states[healthKey{"service1", "v1", "host1:80"}].probing = true
+	h.probe(context.Background(), healthKey{"service1", "v1", "host1:80"})
+	if !h.Healthy("service1", "v1", "host1:80") {
+		t.Fatal("Endpoint not restored by a successful probe")
+	}
+}
+
+func TestDefaultClassifyFailure(t *testing.T) {
+	for err, expect := range map[error]FailureKind{
+		&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}:   FailureRefused,
+		&net.OpError{Op: "read", Err: syscall.ECONNRESET}:     FailureReset,
+		&net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}: FailureTimeout,
+		context.DeadlineExceeded:                              FailureTimeout,
+		fmt.Errorf("Unexpected status 502"):                   FailureOther,
+	} {
+		if kind := DefaultClassifyFailure(err); kind != expect {
+			t.Errorf("Unexpected kind for %v: %d, expected %d", err, kind, expect)
+		}
+	}
+}
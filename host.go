@@ -0,0 +1,109 @@
+package goproxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// The Director routes requests by setting the request host to the service
+// name/version, which the Transport decodes when dialing. Name and version
+// are escaped so any identifier can be used.
+
+// hostSeparator returns the separator between name and version in the routing host.
+func (p *Proxy) hostSeparator() byte {
+	if p.HostSeparator == 0 {
+		return '/'
+	}
+	return p.HostSeparator
+}
+
+// encodeServiceHost encodes the service name/version as a routing host.
+func encodeServiceHost(name, version string, sep byte) string {
+	return escapeHost(name, sep) + string(sep) + escapeHost(version, sep)
+}
+
+// encodePinnedHost encodes the service name/version and the endpoint
+// the request is pinned to as a routing host.
+func encodePinnedHost(name, version, endpoint string, sep byte) string {
+	return encodeServiceHost(name, version, sep) + string(sep) + escapeHost(endpoint, sep)
+}
+
+// parseServiceHost extracts the service name/version from
+// the `<name><sep><version>:<port>` address dialed by the Transport.
+func parseServiceHost(addr string, sep byte) (name, version string, err error) {
+	name, version, endpoint, err := parseRoutingHost(addr, sep)
+	if err != nil || endpoint != "" {
+		return "", "", ErrInvalidService
+	}
+	return name, version, nil
+}
+
+// parseRoutingHost extracts the service name/version and the pinned endpoint,
+// if any, from the `<name><sep><version>[<sep><endpoint>]:<port>` address
+// dialed by the Transport.
+func parseRoutingHost(addr string, sep byte) (name, version, endpoint string, err error) {
+	if i := strings.LastIndexByte(addr, ':'); i != -1 {
+		addr = addr[:i]
+	}
+	i := strings.IndexByte(addr, sep)
+	if i == -1 {
+		return "", "", "", ErrInvalidService
+	}
+	if name, err = unescapeHost(addr[:i]); err != nil {
+		return "", "", "", ErrInvalidService
+	}
+	addr = addr[i+1:]
+	if j := strings.IndexByte(addr, sep); j != -1 {
+		if endpoint, err = unescapeHost(addr[j+1:]); err != nil || endpoint == "" || strings.IndexByte(addr[j+1:], sep) != -1 {
+			return "", "", "", ErrInvalidService
+		}
+		addr = addr[:j]
+	}
+	if version, err = unescapeHost(addr); err != nil {
+		return "", "", "", ErrInvalidService
+	}
+	return name, version, endpoint, nil
+}
+
+// shouldEscape reports whether c needs to be escaped in a routing host.
+func shouldEscape(c, sep byte) bool {
+	switch {
+	case c == sep:
+		return true
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		return false
+	}
+	return true
+}
+
+// escapeHost percent-encodes s to be usable in a routing host.
+// It does not allocate when nothing needs to be escaped.
+func escapeHost(s string, sep byte) string {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if shouldEscape(s[i], sep) {
+			n++
+		}
+	}
+	if n == 0 {
+		return s
+	}
+	const hex = "0123456789ABCDEF"
+	buf := make([]byte, 0, len(s)+2*n)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; shouldEscape(c, sep) {
+			buf = append(buf, '%', hex[c>>4], hex[c&15])
+		} else {
+			buf = append(buf, c)
+		}
+	}
+	return string(buf)
+}
+
+// unescapeHost decodes a string escaped with escapeHost.
+func unescapeHost(s string) (string, error) {
+	if strings.IndexByte(s, '%') == -1 {
+		return s, nil
+	}
+	return url.PathUnescape(s)
+}
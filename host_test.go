@@ -0,0 +1,57 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestServiceHostEncoding(t *testing.T) {
+	for _, sep := range []byte{'/', '.', '_'} {
+		for _, tc := range [][2]string{
+			{"service1", "v1"},
+			{"team/service", "v1.2"},
+			{"my.service", "v1/beta"},
+			{"sérvice_ü", "v1:80"},
+			{"a%2Fb", "v1"},
+		} {
+			host := encodeServiceHost(tc[0], tc[1], sep)
+			name, version, err := parseServiceHost(host+":80", sep)
+			if err != nil {
+				t.Fatalf("Unexpected error for %q (%c): %s", host, sep, err)
+			}
+			if name != tc[0] || version != tc[1] {
+				t.Fatalf("Unexpected decoding of %q (%c): %s %s, expected %s %s", host, sep, name, version, tc[0], tc[1])
+			}
+		}
+	}
+}
+
+func TestProxyServiceNames(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	names := []string{"team/service", "my.service", "sérvice"}
+	reg := registry.DefaultRegistry{}
+	for _, name := range names {
+		reg.Add(name, "v1.2", strings.TrimPrefix(backend.URL, "http://"))
+	}
+
+	defer func(extract func(*url.URL) (string, string, error)) { ExtractNameVersion = extract }(ExtractNameVersion)
+	ExtractNameVersion = ExtractNameVersionRegexp(regexp.MustCompile(`^/(?P<name>.+)/(?P<version>v[^/]+)(?P<rest>/.*)?$`), "${rest}")
+
+	for _, sep := range []byte{0, '.'} {
+		p := NewProxy(reg)
+		p.HostSeparator = sep
+		for _, name := range names {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/"+url.PathEscape(name)+"/v1.2/users", nil))
+			if w.Code != http.StatusOK || w.Body.String() != "backend1 /users" {
+				t.Fatalf("Unexpected response for %q (%q): %d %q", name, sep, w.Code, w.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package goproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+// HTTP2Transport is an http.RoundTripper sending the requests of a Proxy to
+// cleartext HTTP/2 (h2c) upstreams, e.g. gRPC servers, to be returned by
+// Proxy.Transport for their services. An HTTP/2 connection multiplexes the
+// requests: HTTP2Transport caps the streams in flight per connection with
+// MaxConcurrentStreams, and opens more connections, each through the Proxy
+// balancer, beyond that.
+//
+// The streams in flight are counted per endpoint in Connections, so that
+// LoadBalanceP2C sends the new connections to the endpoints with the fewest
+// requests in flight rather than with the fewest connections, which are few
+// and long-lived with HTTP/2. The balancing happens per connection: the
+// requests multiplexed on a connection all go to its endpoint.
+//
+// The Proxy UpstreamReadTimeout and UpstreamWriteTimeout don't apply to the
+// connections shared by the streams. TLS upstreams are not supported: the
+// Proxy DialTLS connections don't negotiate HTTP/2.
+type HTTP2Transport struct {
+	// MaxConcurrentStreams caps the streams in flight per connection, below
+	// the limit advertised by the upstream. When zero, 100 is used.
+	MaxConcurrentStreams int
+
+	proxy  *Proxy
+	lock   sync.Mutex
+	shards map[string][]*h2Shard // By routing host.
+}
+
+// h2Shard is a Transport holding an HTTP/2 connection to the service.
+type h2Shard struct {
+	transport *http.Transport
+	inFlight  int
+}
+
+// NewHTTP2Transport creates an HTTP2Transport connecting with the Dial of the given Proxy.
+func NewHTTP2Transport(p *Proxy, maxConcurrentStreams int) *HTTP2Transport {
+	return &HTTP2Transport{MaxConcurrentStreams: maxConcurrentStreams, proxy: p, shards: map[string][]*h2Shard{}}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HTTP2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	shard := t.acquire(host)
+	// The stream is counted for its endpoint and connection once it gets one.
+	s := &h2Stream{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			// Retried on another connection.
+			s.end()
+			s.endpoint = connEndpoint(info.Conn)
+			Connections.addStream(s.endpoint, 1)
+			if conn, ok := info.Conn.(interface{ upstream() *proxyConn }); ok {
+				s.conn = conn.upstream()
+				s.conn.streams.Add(1)
+			}
+		},
+	}))
+	release := func() {
+		s.end()
+		t.release(host, shard)
+	}
+	resp, err := shard.transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// The stream ends with the response body.
+	resp.Body = &streamBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// h2Stream is a stream counted for its endpoint and connection.
+type h2Stream struct {
+	endpoint string
+	conn     *proxyConn
+}
+
+// end stops counting the stream, if counted.
+func (s *h2Stream) end() {
+	if s.endpoint != "" {
+		Connections.addStream(s.endpoint, -1)
+	}
+	if s.conn != nil {
+		s.conn.streams.Add(-1)
+	}
+	s.endpoint, s.conn = "", nil
+}
+
+// acquire returns the least loaded shard of the routing host with a stream
+// available, creating a new one when they are all at MaxConcurrentStreams.
+func (t *HTTP2Transport) acquire(host string) *h2Shard {
+	max := t.MaxConcurrentStreams
+	if max <= 0 {
+		max = 100
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var selected *h2Shard
+	for _, shard := range t.shards[host] {
+		if shard.inFlight < max && (selected == nil || shard.inFlight < selected.inFlight) {
+			selected = shard
+		}
+	}
+	if selected == nil {
+		protocols := &http.Protocols{}
+		protocols.SetUnencryptedHTTP2(true)
+		selected = &h2Shard{transport: &http.Transport{
+			Dial:            t.dial,
+			Protocols:       protocols,
+			IdleConnTimeout: t.proxy.transport.IdleConnTimeout,
+		}}
+		t.shards[host] = append(t.shards[host], selected)
+	}
+	selected.inFlight++
+	return selected
+}
+
+// dial connects with the Proxy Dial, marking the connection as multiplexed.
+func (t *HTTP2Transport) dial(network, addr string) (net.Conn, error) {
+	conn, err := t.proxy.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if pc, ok := conn.(interface{ upstream() *proxyConn }); ok {
+		pc.upstream().multiplexed.Store(true)
+	}
+	return conn, nil
+}
+
+// release ends a stream of the shard of the given routing host. The shard is
+// dropped once idle, unless it is the last one of the host.
+func (t *HTTP2Transport) release(host string, shard *h2Shard) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if shard.inFlight--; shard.inFlight > 0 || len(t.shards[host]) == 1 {
+		return
+	}
+	shards := t.shards[host]
+	for i, s := range shards {
+		if s == shard {
+			t.shards[host] = append(shards[:i:i], shards[i+1:]...)
+			break
+		}
+	}
+	shard.transport.CloseIdleConnections()
+}
+
+// CloseIdleConnections closes the idle connections of all the shards.
+func (t *HTTP2Transport) CloseIdleConnections() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, shards := range t.shards {
+		for _, shard := range shards {
+			shard.transport.CloseIdleConnections()
+		}
+	}
+}
+
+// streamBody is a response body calling release once, when closed
+// or fully read.
+type streamBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+// Close closes the body and releases the stream.
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,95 @@
+package goproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestHTTP2Transport(t *testing.T) {
+	started, release := make(chan struct{}, 3), make(chan struct{})
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, req.Proto)
+	}))
+	backend.Config.Protocols = &http.Protocols{}
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	var dials atomic.Int32
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			dials.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", endpoint)
+	p := NewProxy(reg)
+	p.UpstreamReadTimeout = 50 * time.Millisecond
+	transport := NewHTTP2Transport(p, 1)
+	defer transport.CloseIdleConnections()
+	p.Transport = func(name, version string) http.RoundTripper { return transport }
+
+	// With a single stream per connection, the two requests in flight
+	// open two connections.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+			if w.Body.String() != "HTTP/2.0" {
+				t.Errorf("Unexpected response: %d %q", w.Code, w.Body.String())
+			}
+		}()
+	}
+	<-started
+	<-started
+	if n := Connections.Streams(endpoint); n != 2 {
+		t.Errorf("Unexpected streams in flight: %d, expected 2", n)
+	}
+	transport.lock.Lock()
+	shards := 0
+	for _, s := range transport.shards {
+		shards += len(s)
+	}
+	transport.lock.Unlock()
+	if shards != 2 {
+		t.Errorf("Unexpected connections: %d, expected 2", shards)
+	}
+	close(release)
+	wg.Wait()
+	if n := Connections.Streams(endpoint); n != 0 {
+		t.Fatalf("Unexpected streams in flight after the responses: %d", n)
+	}
+
+	// The extra connection is dropped once idle, the other one is kept
+	// regardless of UpstreamReadTimeout, and reported idle.
+	transport.lock.Lock()
+	shards = 0
+	for _, s := range transport.shards {
+		shards += len(s)
+	}
+	transport.lock.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	if details := p.Inspect(); len(details) != 1 || details[0].Active != 0 {
+		t.Fatalf("Unexpected endpoint details: %+v", details)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	if w.Code != http.StatusOK || shards != 1 || dials.Load() != 2 {
+		t.Fatalf("Unexpected reuse: %d, %d shards, %d dials", w.Code, shards, dials.Load())
+	}
+}
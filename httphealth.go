@@ -0,0 +1,104 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// HTTPHealthCheck configures the HTTP probes of the endpoints of a service.
+type HTTPHealthCheck struct {
+	// Path requested on the endpoints. When empty, "/healthz" is used.
+	Path string
+
+	// Scheme is "http" or "https". When empty, "http" is used.
+	Scheme string
+
+	// TLSConfig configures the https probes. When the config has no
+	// ServerName, the host of the endpoint is verified.
+	TLSConfig *tls.Config
+
+	// ExpectedStatus, when set, is the only healthy response status.
+	// When zero, any 2xx status is healthy.
+	ExpectedStatus int
+
+	// Interval and Timeout of the probes, zero for the HealthChecker ones.
+	Interval, Timeout time.Duration
+}
+
+// HTTPHealthChecks creates a NewHTTPHealthChecker config selecting the
+// health checks by `<name>/<version>` first, then by `<name>`.
+func HTTPHealthChecks(checks map[string]HTTPHealthCheck) func(name, version string) *HTTPHealthCheck {
+	return func(name, version string) *HTTPHealthCheck {
+		if check, ok := checks[name+"/"+version]; ok {
+			return &check
+		}
+		if check, ok := checks[name]; ok {
+			return &check
+		}
+		return nil
+	}
+}
+
+// NewHTTPHealthChecker creates a HealthChecker wrapping the given registry
+// which probes the endpoints with an HTTP GET request, per the HTTPHealthCheck
+// returned by checks for their service. The endpoints are healthy when they
+// respond with a 2xx status, or ExpectedStatus: redirects are not followed
+// and, like connection errors, make the endpoint unhealthy.
+// The services without health check are probed with a TCP connection.
+func NewHTTPHealthChecker(reg registry.Registry, checks func(name, version string) *HTTPHealthCheck) *HealthChecker {
+	h := NewHealthChecker(reg)
+	h.Probe = func(ctx context.Context, name, version, endpoint string) error {
+		if check := checks(name, version); check != nil {
+			return check.probe(ctx, endpoint)
+		}
+		return dialProbe(ctx, name, version, endpoint)
+	}
+	h.ProbeTimings = func(name, version string) (interval, timeout time.Duration) {
+		if check := checks(name, version); check != nil {
+			return check.Interval, check.Timeout
+		}
+		return 0, 0
+	}
+	return h
+}
+
+// probe requests the health path of the endpoint.
+func (c *HTTPHealthCheck) probe(ctx context.Context, endpoint string) error {
+	scheme, path := c.Scheme, c.Path
+	if scheme == "" {
+		scheme = "http"
+	}
+	if path == "" {
+		path = "/healthz"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	// A connection per probe: the probes are sparse and must test the dial.
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       Dialer.DialContext,
+			TLSClientConfig:   c.TLSConfig,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if c.ExpectedStatus != 0 && resp.StatusCode != c.ExpectedStatus ||
+		c.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("Unhealthy status %s", resp.Status)
+	}
+	return nil
+}
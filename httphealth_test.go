@@ -0,0 +1,88 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestHTTPHealthCheckProbe(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/healthz":
+		case "/redirect":
+			http.Redirect(w, req, "/healthz", http.StatusFound)
+		case "/ready":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	backend := httptest.NewServer(handler)
+	defer backend.Close()
+	tlsBackend := httptest.NewTLSServer(handler)
+	defer tlsBackend.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	endpoint := strings.TrimPrefix(backend.URL, "http://")
+	for _, tc := range []struct {
+		check    HTTPHealthCheck
+		endpoint string
+		healthy  bool
+	}{
+		{HTTPHealthCheck{}, endpoint, true},
+		{HTTPHealthCheck{Path: "/unhealthy"}, endpoint, false},
+		{HTTPHealthCheck{Path: "/redirect"}, endpoint, false},
+		{HTTPHealthCheck{Path: "/ready", ExpectedStatus: http.StatusNoContent}, endpoint, true},
+		{HTTPHealthCheck{ExpectedStatus: http.StatusNoContent}, endpoint, false},
+		{HTTPHealthCheck{}, strings.TrimPrefix(down.URL, "http://"), false},
+		{HTTPHealthCheck{Scheme: "https", TLSConfig: tlsBackend.Client().Transport.(*http.Transport).TLSClientConfig}, strings.TrimPrefix(tlsBackend.URL, "https://"), true},
+	} {
+		if err := tc.check.probe(context.Background(), tc.endpoint); (err == nil) != tc.healthy {
+			t.Fatalf("Unexpected probe result for %+v: %v", tc.check, err)
+		}
+	}
+}
+
+func TestHTTPHealthChecker(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer up.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(up.URL, "http://"))
+	reg.Add("service1", "v1", strings.TrimPrefix(unhealthy.URL, "http://"))
+	h := NewHTTPHealthChecker(reg, HTTPHealthChecks(map[string]HTTPHealthCheck{
+		"service1": {Interval: 10 * time.Millisecond},
+	}))
+	// The service interval applies instead of the default one.
+	h.Interval = time.Hour
+	stop := h.Start()
+	defer stop()
+
+	for i := 0; ; i++ {
+		endpoints, err := h.Lookup("service1", "v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(endpoints) == 1 {
+			if endpoints[0] != strings.TrimPrefix(up.URL, "http://") {
+				t.Fatalf("Unexpected healthy endpoints: %v", endpoints)
+			}
+			break
+		}
+		if i == 1000 {
+			t.Fatal("The unhealthy endpoint was not excluded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
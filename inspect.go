@@ -0,0 +1,128 @@
+package goproxy
+
+import (
+	"sort"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// EndpointDetail describes the state of an endpoint of a service, see Inspect.
+type EndpointDetail struct {
+	Name, Version, Endpoint string
+
+	Tier      int  // Priority tier of the endpoint, 0 being the preferred one.
+	Available bool // Whether the endpoint is returned by the lookups of the Proxy registry.
+	Weight    int  // Weight from the registry.Weighter, 1 without.
+	Healthy   bool // Whether the endpoint passed its last HealthChecker probe, true without.
+	Draining  bool // Whether the endpoint is draining in a registry.DrainingRegistry.
+	Active    int  // Number of upstream connections of the Proxy serving a request to the endpoint.
+}
+
+// Inspect returns the state of the endpoints of all the services of the
+// Proxy registry, sorted by service name, version and endpoint, e.g. for
+// an admin dashboard.
+//
+// The endpoints are listed from the innermost registry, following
+// registry.Unwrap: the ones excluded by the wrappers, e.g. unhealthy or
+// draining, are listed as not Available. The registries must be
+// registry.Enumerable for their services to be listed.
+//
+// The result is not an atomic snapshot: the registries, the health states
+// and the connections are read one after the other, each under its own lock.
+// When they are updated concurrently, the details of an endpoint may mix
+// states from before and after an update, e.g. an Active connection to an
+// endpoint which is no longer Available.
+func (p *Proxy) Inspect() []EndpointDetail {
+	var (
+		base     = p.registry
+		weighter registry.Weighter
+		health   *HealthChecker
+		draining *registry.DrainingRegistry
+	)
+	for reg := p.registry; reg != nil; reg = registry.Unwrap(reg) {
+		base = reg
+		if w, ok := reg.(registry.Weighter); ok && weighter == nil {
+			weighter = w
+		}
+		if h, ok := reg.(*HealthChecker); ok && health == nil {
+			health = h
+		}
+		if d, ok := reg.(*registry.DrainingRegistry); ok && draining == nil {
+			draining = d
+		}
+	}
+	active := p.activeConns()
+
+	var details []EndpointDetail
+	for name, versions := range registry.Enumerate(p.registry) {
+		for _, version := range versions {
+			tiers, err := registry.LookupTiers(base, name, version)
+			if err != nil {
+				continue
+			}
+			available := map[string]bool{}
+			if tiers, err := registry.LookupTiers(p.registry, name, version); err == nil {
+				for _, endpoints := range tiers {
+					for _, endpoint := range endpoints {
+						available[endpoint] = true
+					}
+				}
+			}
+			for tier, endpoints := range tiers {
+				for _, endpoint := range endpoints {
+					detail := EndpointDetail{
+						Name:      name,
+						Version:   version,
+						Endpoint:  endpoint,
+						Tier:      tier,
+						Available: available[endpoint],
+						Weight:    1,
+						Healthy:   true,
+						Active:    active[healthKey{name, version, endpoint}],
+					}
+					if weighter != nil {
+						detail.Weight = weighter.Weight(name, version, endpoint)
+					}
+					if health != nil {
+						detail.Healthy = health.Healthy(name, version, endpoint)
+					}
+					if draining != nil {
+						detail.Draining = draining.IsDraining(name, version, endpoint)
+					}
+					details = append(details, detail)
+				}
+			}
+		}
+	}
+	sort.Slice(details, func(i, j int) bool {
+		a, b := details[i], details[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Endpoint < b.Endpoint
+	})
+	return details
+}
+
+// activeConns counts the busy upstream connections by endpoint.
+func (p *Proxy) activeConns() map[healthKey]int {
+	p.upstreamLock.Lock()
+	defer p.upstreamLock.Unlock()
+
+	active := map[healthKey]int{}
+	for conn := range p.upstreams {
+		if !conn.idle() {
+			active[healthKey{conn.name, conn.version, conn.Endpoint()}]++
+		}
+	}
+	return active
+}
+
+// Unwrap returns the wrapped registry.
+func (h *HealthChecker) Unwrap() registry.Registry { return h.Registry }
+
+// Unwrap returns the wrapped registry.
+func (r *WarmingRegistry) Unwrap() registry.Registry { return r.Registry }
@@ -0,0 +1,29 @@
+package goproxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyInspect(t *testing.T) {
+	weighted := registry.NewWeightedRegistry(registry.DefaultRegistry{})
+	weighted.AddWithWeight("service1", "v1", "host1:80", 3)
+	weighted.Add("service1", "v1", "host2:80")
+	weighted.Add("service2", "v1", "host3:80")
+	draining := registry.NewDrainingRegistry(weighted)
+	draining.Drain("service1", "v1", "host2:80")
+	health := NewHealthChecker(draining)
+
+	p := NewProxy(health)
+	details := p.Inspect()
+	expect := "[" +
+		"{service1 v1 host1:80 0 true 3 true false 0} " +
+		"{service1 v1 host2:80 0 false 1 true true 0} " +
+		"{service2 v1 host3:80 0 true 1 true false 0}" +
+		"]"
+	if fmt.Sprint(details) != expect {
+		t.Fatalf("Unexpected details: %v", details)
+	}
+}
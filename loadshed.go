@@ -0,0 +1,93 @@
+package goproxy
+
+import (
+	"math/rand"
+	"net/http"
+	"runtime"
+)
+
+// DefaultLoadShedder, when set, is the LoadShedder of the Proxies created
+// by NewProxy and NewMultipleHostReverseProxy. It is nil by default.
+var DefaultLoadShedder *LoadShedder
+
+// LoadShedder rejects incoming requests when the proxy is under pressure,
+// before it collapses: past Threshold, the requests are rejected with
+// a probability growing linearly up to 1 at Limit.
+//
+// The requests are prioritized by their criticality: CriticalityThresholds
+// sets the threshold of each criticality, so that e.g. the batch requests
+// are shed first and the critical ones last.
+type LoadShedder struct {
+	// Pressure returns the current pressure signal, e.g. a queue depth or the
+	// CPU usage. It is called for each request: it must be cheap and safe
+	// for concurrent use. When nil, GoroutinePressure is used.
+	Pressure func() float64
+
+	// Threshold is the pressure past which the requests start being shed.
+	Threshold float64
+
+	// Limit is the pressure past which all the requests are shed.
+	// When not above the threshold, twice the threshold is used.
+	Limit float64
+
+	// Criticality returns the criticality of the request, e.g. from its
+	// authenticated client. When nil, the value of the CriticalityHeader
+	// is used.
+	Criticality func(req *http.Request) string
+
+	// CriticalityHeader names the request header holding the criticality of
+	// the requests. When empty, "X-Criticality" is used. It is set by the
+	// clients: it must be set or stripped by a trusted edge, otherwise any
+	// client can exempt itself from the shedding.
+	CriticalityHeader string
+
+	// CriticalityThresholds overrides Threshold by criticality, e.g.
+	// {"critical": 2000, "sheddable": 500}. The requests without criticality
+	// or with an unknown one use Threshold.
+	CriticalityThresholds map[string]float64
+}
+
+// GoroutinePressure returns the number of goroutines, which grows with
+// the number of requests and connections in flight.
+func GoroutinePressure() float64 {
+	return float64(runtime.NumGoroutine())
+}
+
+// Shed reports whether the request should be rejected.
+func (s *LoadShedder) Shed(req *http.Request) bool {
+	pressure := s.Pressure
+	if pressure == nil {
+		pressure = GoroutinePressure
+	}
+	current := pressure()
+
+	threshold := s.Threshold
+	if s.CriticalityThresholds != nil {
+		if t, ok := s.CriticalityThresholds[s.criticality(req)]; ok {
+			threshold = t
+		}
+	}
+	if current <= threshold {
+		return false
+	}
+	limit := s.Limit
+	if limit <= s.Threshold {
+		limit = 2 * s.Threshold
+	}
+	if current >= limit || threshold >= limit {
+		return true
+	}
+	return rand.Float64() < (current-threshold)/(limit-threshold)
+}
+
+// criticality returns the criticality of the request.
+func (s *LoadShedder) criticality(req *http.Request) string {
+	if s.Criticality != nil {
+		return s.Criticality(req)
+	}
+	header := s.CriticalityHeader
+	if header == "" {
+		header = "X-Criticality"
+	}
+	return req.Header.Get(header)
+}
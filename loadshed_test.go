@@ -0,0 +1,72 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestLoadShedder(t *testing.T) {
+	var pressure float64
+	s := &LoadShedder{
+		Pressure:              func() float64 { return pressure },
+		Threshold:             100,
+		Limit:                 200,
+		CriticalityThresholds: map[string]float64{"critical": 150, "sheddable": 50},
+	}
+	shed := func(criticality string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Criticality", criticality)
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if s.Shed(req) {
+				n++
+			}
+		}
+		return n
+	}
+
+	pressure = 40
+	if n := shed("sheddable"); n != 0 {
+		t.Fatalf("Unexpected requests shed under the thresholds: %d", n)
+	}
+	pressure = 125
+	if n := shed(""); n < 150 || n > 350 {
+		t.Fatalf("Unexpected requests shed at a quarter of the range: %d", n)
+	}
+	if n := shed("critical"); n != 0 {
+		t.Fatalf("Unexpected critical requests shed: %d", n)
+	}
+	if n := shed("sheddable"); n < 400 || n > 600 {
+		t.Fatalf("Unexpected sheddable requests shed at half of their range: %d", n)
+	}
+	pressure = 200
+	if n := shed("critical"); n != 1000 {
+		t.Fatalf("Unexpected critical requests kept at the limit: %d", 1000-n)
+	}
+
+	// Criticality takes precedence over the client header.
+	pressure = 125
+	s.Criticality = func(req *http.Request) string { return "sheddable" }
+	if n := shed("critical"); n < 400 || n > 600 {
+		t.Fatalf("Unexpected requests shed with Criticality: %d", n)
+	}
+}
+
+func TestProxyLoadShedder(t *testing.T) {
+	backend := newBackend(t, "backend")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", backend.Listener.Addr().String())
+
+	DefaultLoadShedder = &LoadShedder{Pressure: func() float64 { return 1 }, Threshold: 0.5}
+	defer func() { DefaultLoadShedder = nil }()
+	handler := NewMultipleHostReverseProxy(reg)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("Unexpected response under pressure: %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,45 @@
+package goproxy
+
+import "time"
+
+// Metrics receives the measurements of the Proxies and of the default
+// balancers, e.g. to feed StatsD, OpenTelemetry or Prometheus, see the
+// prommetrics package. The methods are called concurrently, on the hot path:
+// they must be safe for concurrent use and should not block.
+//
+// The name/version are the ones of the services found in the registry, and
+// the endpoints the ones of their dials: the requests ExtractNameVersion
+// fails to route and the ones for unknown services are recorded with an
+// empty name/version. The clients can't grow the label values, so the
+// implementations may keep a series per service without bound.
+type Metrics interface {
+	// IncRequest counts a request completed with the given status code,
+	// 101 for the upgraded connections once closed, 200 for the CONNECT tunnels.
+	IncRequest(name, version string, status int)
+	// ObserveLatency records the duration of a request, from its reception
+	// to the end of the response or the close of the upgraded connection.
+	ObserveLatency(name, version string, d time.Duration)
+	// IncDial counts a connection established to the given endpoint.
+	IncDial(name, version, endpoint string)
+	// IncDialFailure counts a failed connection attempt to the given endpoint.
+	IncDialFailure(name, version, endpoint string, err error)
+}
+
+// DefaultMetrics receives the metrics of the Proxies and of the default
+// balancers. It discards them by default.
+var DefaultMetrics Metrics = NopMetrics{}
+
+// NopMetrics is a Metrics discarding the measurements.
+type NopMetrics struct{}
+
+// IncRequest implements Metrics.
+func (NopMetrics) IncRequest(name, version string, status int) {}
+
+// ObserveLatency implements Metrics.
+func (NopMetrics) ObserveLatency(name, version string, d time.Duration) {}
+
+// IncDial implements Metrics.
+func (NopMetrics) IncDial(name, version, endpoint string) {}
+
+// IncDialFailure implements Metrics.
+func (NopMetrics) IncDialFailure(name, version, endpoint string, err error) {}
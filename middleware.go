@@ -0,0 +1,110 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Middleware wraps an http.Handler, typically the Proxy, with extra behavior.
+type Middleware func(http.Handler) http.Handler
+
+// IsWebsocket reports whether the request is a websocket upgrade.
+func IsWebsocket(req *http.Request) bool {
+	return isUpgrade(req) && strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// isUpgrade reports whether the request asks for a protocol upgrade.
+// Upgraded connections are hijacked and long-lived.
+// The Connection tokens are scanned in place, without allocating.
+func isUpgrade(req *http.Request) bool {
+	for _, value := range req.Header["Connection"] {
+		for value != "" {
+			var token string
+			token, value, _ = strings.Cut(value, ",")
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TimeoutMiddleware bounds the time allowed to handle a request by setting
+// a deadline on its context. When the deadline is exceeded before the upstream
+// responds, the Proxy replies with 504 Gateway Timeout.
+// Upgrade requests, such as websockets, are hijacked and long-lived: they are
+// explicitly excluded and never get a deadline.
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if isUpgrade(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+// filterWebsocketExtensions removes from the Sec-WebSocket-Extensions header
+// the offered extensions which are not in the allowed list.
+func filterWebsocketExtensions(header http.Header, allowed []string) {
+	var kept []string
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, extension := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(extension, ";")
+			name = strings.TrimSpace(name)
+			for _, a := range allowed {
+				if strings.EqualFold(name, a) {
+					kept = append(kept, strings.TrimSpace(extension))
+					break
+				}
+			}
+		}
+	}
+	header.Del("Sec-WebSocket-Extensions")
+	if len(kept) != 0 {
+		header.Set("Sec-WebSocket-Extensions", strings.Join(kept, ", "))
+	}
+}
+
+// headerWriter calls before on the response headers right before they are
+// written, so it can override the headers set by the upstream.
+type headerWriter struct {
+	http.ResponseWriter
+	before      func(http.Header)
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	// Informational responses can be followed by another status, except 101.
+	if !w.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.wroteHeader = true
+		w.before(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// finish applies before when the handler returned without writing anything.
+func (w *headerWriter) finish() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.before(w.Header())
+	}
+}
+
+// Unwrap gives http.ResponseController access to the Flusher and Hijacker.
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
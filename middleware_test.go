@@ -0,0 +1,249 @@
+package goproxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestIsWebsocket(t *testing.T) {
+	for _, tc := range []struct {
+		connection, upgrade string
+		expect              bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "WebSocket", true},
+		{"upgrade", "h2c", false},
+		{"keep-alive", "websocket", false},
+		{"", "", false},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", tc.connection)
+		req.Header.Set("Upgrade", tc.upgrade)
+		if IsWebsocket(req) != tc.expect {
+			t.Errorf("Unexpected result for %q/%q, expected %t", tc.connection, tc.upgrade, tc.expect)
+		}
+	}
+}
+
+func BenchmarkIsWebsocket(b *testing.B) {
+	for _, connection := range []string{"keep-alive", "keep-alive, Upgrade"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", connection)
+		req.Header.Set("Upgrade", "websocket")
+		b.Run(connection, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				IsWebsocket(req)
+			}
+		})
+	}
+}
+
+func TestIsWebsocketAllocs(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if n := testing.AllocsPerRun(100, func() { IsWebsocket(req) }); n != 0 {
+		t.Fatalf("Unexpected allocations: %v", n)
+	}
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	handler := TimeoutMiddleware(50 * time.Millisecond)(NewProxy(reg))
+	for path, status := range map[string]int{
+		"/service1/v1/fast": http.StatusOK,
+		"/service1/v1/slow": http.StatusGatewayTimeout,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("Unexpected status for %s: %d, expected %d", path, w.Code, status)
+		}
+	}
+
+	// Upgrade requests are not bound by the timeout.
+	var deadline bool
+	handler = TimeoutMiddleware(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, deadline = req.Context().Deadline()
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if deadline {
+		t.Fatal("Upgrade requests should not have a deadline")
+	}
+}
+
+func TestProxyWebsocketExtensions(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.Header.Get("Sec-WebSocket-Extensions"))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	const offered = "permessage-deflate; client_max_window_bits, x-webkit-deflate-frame"
+	for _, tc := range []struct {
+		allowed []string
+		expect  string
+	}{
+		{nil, offered},
+		{[]string{"permessage-deflate"}, "permessage-deflate; client_max_window_bits"},
+		{[]string{}, ""},
+	} {
+		p := NewProxy(reg)
+		p.WebsocketExtensions = tc.allowed
+		req := httptest.NewRequest("GET", "/service1/v1/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Extensions", offered)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		if w.Body.String() != tc.expect {
+			t.Errorf("Unexpected forwarded extensions with %v: %q, expected %q", tc.allowed, w.Body.String(), tc.expect)
+		}
+	}
+}
+
+func TestProxyWebsocketUpgradeRequest(t *testing.T) {
+	// The upstream records the upgrade request it receives and accepts it.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan *http.Request, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			received <- req
+			io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			conn.Close()
+		}
+	}()
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", l.Addr().String())
+	proxy := httptest.NewServer(NewProxy(reg))
+	defer proxy.Close()
+
+	for _, proto := range []string{"HTTP/1.1", "HTTP/1.0"} {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "GET /service1/v1/ws?a=b "+proto+"\r\n"+
+			"Host: example.com\r\n"+
+			"Connection: keep-alive, Upgrade\r\n"+
+			"Keep-Alive: timeout=5\r\n"+
+			"Proxy-Connection: keep-alive\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+			"Sec-WebSocket-Version: 13\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("Unexpected status for %s: %d", proto, resp.StatusCode)
+		}
+
+		req := <-received
+		// The request line is rebuilt for the upstream, along with the hop-by-hop
+		// headers. The original host is forwarded in X-Forwarded-Host.
+		if req.Proto != "HTTP/1.1" || req.RequestURI != "/ws?a=b" {
+			t.Fatalf("Unexpected request line for %s: %s %s", proto, req.RequestURI, req.Proto)
+		}
+		for header, expect := range map[string]string{
+			"Connection":        "Upgrade",
+			"Upgrade":           "websocket",
+			"Keep-Alive":        "",
+			"Proxy-Connection":  "",
+			"Sec-Websocket-Key": "dGhlIHNhbXBsZSBub25jZQ==",
+			"X-Forwarded-Host":  "example.com",
+		} {
+			if got := req.Header.Get(header); got != expect {
+				t.Fatalf("Unexpected %s header for %s: %q, expected %q", header, proto, got, expect)
+			}
+		}
+	}
+}
+
+func TestProxyWebsocketHandshakeFailure(t *testing.T) {
+	// The upstream closes the connection during the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", l.Addr().String())
+	proxy := httptest.NewServer(NewProxy(reg))
+	defer proxy.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// The upgrade request is written upstream before the client connection is
+	// hijacked: the client gets a regular error response.
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /service1/v1/ws HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+	if expect := "service1/v1 " + l.Addr().String() + ":"; !strings.Contains(logs.String(), expect) {
+		t.Fatalf("Unexpected error log, expected %q: %s", expect, logs.String())
+	}
+}
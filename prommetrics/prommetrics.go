@@ -0,0 +1,210 @@
+// Package prommetrics implements goproxy.Metrics in the Prometheus text
+// exposition format, without depending on the Prometheus client library.
+//
+//	m := prommetrics.New()
+//	goproxy.DefaultMetrics = m
+//	http.Handle("/metrics", m)
+package prommetrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/goproxy"
+)
+
+// DefBuckets are the default upper bounds, in seconds, of the latency histogram.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type serviceKey struct {
+	name, version string
+}
+
+type requestKey struct {
+	name, version string
+	status        int
+}
+
+type endpointKey struct {
+	name, version, endpoint string
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative, the last one is +Inf.
+	sum    float64
+}
+
+// Metrics is a goproxy.Metrics exposing its counters and latency histograms
+// to Prometheus as an http.Handler:
+//   - goproxy_requests_total{service,version,code}
+//   - goproxy_request_duration_seconds{service,version}
+//   - goproxy_dials_total{service,version,endpoint}
+//   - goproxy_dial_failures_total{service,version,endpoint}
+type Metrics struct {
+	buckets []float64
+
+	lock         sync.Mutex
+	requests     map[requestKey]uint64
+	latencies    map[serviceKey]*histogram
+	dials        map[endpointKey]uint64
+	dialFailures map[endpointKey]uint64
+}
+
+var _ goproxy.Metrics = (*Metrics)(nil)
+
+// New creates a Metrics with the DefBuckets latency histogram.
+func New() *Metrics {
+	return NewWithBuckets(DefBuckets)
+}
+
+// NewWithBuckets creates a Metrics with the given sorted upper bounds,
+// in seconds, for the latency histogram.
+func NewWithBuckets(buckets []float64) *Metrics {
+	return &Metrics{
+		buckets:      buckets,
+		requests:     map[requestKey]uint64{},
+		latencies:    map[serviceKey]*histogram{},
+		dials:        map[endpointKey]uint64{},
+		dialFailures: map[endpointKey]uint64{},
+	}
+}
+
+// IncRequest implements goproxy.Metrics.
+func (m *Metrics) IncRequest(name, version string, status int) {
+	m.lock.Lock()
+	m.requests[requestKey{name, version, status}]++
+	m.lock.Unlock()
+}
+
+// ObserveLatency implements goproxy.Metrics.
+func (m *Metrics) ObserveLatency(name, version string, d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(m.buckets, seconds)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.latencies[serviceKey{name, version}]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets)+1)}
+		m.latencies[serviceKey{name, version}] = h
+	}
+	h.counts[i]++
+	h.sum += seconds
+}
+
+// IncDial implements goproxy.Metrics.
+func (m *Metrics) IncDial(name, version, endpoint string) {
+	m.lock.Lock()
+	m.dials[endpointKey{name, version, endpoint}]++
+	m.lock.Unlock()
+}
+
+// IncDialFailure implements goproxy.Metrics.
+func (m *Metrics) IncDialFailure(name, version, endpoint string, err error) {
+	m.lock.Lock()
+	m.dialFailures[endpointKey{name, version, endpoint}]++
+	m.lock.Unlock()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+// write formats the metrics, sorted by labels for stable output.
+func (m *Metrics) write(w *bufio.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	requests := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.version != b.version {
+			return a.version < b.version
+		}
+		return a.status < b.status
+	})
+	fmt.Fprintln(w, "# HELP goproxy_requests_total Requests proxied by service and status code.")
+	fmt.Fprintln(w, "# TYPE goproxy_requests_total counter")
+	for _, key := range requests {
+		fmt.Fprintf(w, "goproxy_requests_total{service=%s,version=%s,code=\"%d\"} %d\n", quote(key.name), quote(key.version), key.status, m.requests[key])
+	}
+
+	services := make([]serviceKey, 0, len(m.latencies))
+	for key := range m.latencies {
+		services = append(services, key)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		a, b := services[i], services[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return a.version < b.version
+	})
+	fmt.Fprintln(w, "# HELP goproxy_request_duration_seconds Duration of the proxied requests by service.")
+	fmt.Fprintln(w, "# TYPE goproxy_request_duration_seconds histogram")
+	for _, key := range services {
+		h := m.latencies[key]
+		labels := "service=" + quote(key.name) + ",version=" + quote(key.version)
+		var cumulative uint64
+		for i, count := range h.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(m.buckets) {
+				le = strconv.FormatFloat(m.buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "goproxy_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, le, cumulative)
+		}
+		fmt.Fprintf(w, "goproxy_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "goproxy_request_duration_seconds_count{%s} %d\n", labels, cumulative)
+	}
+
+	writeEndpointCounter(w, "goproxy_dials_total", "Connections established by endpoint.", m.dials)
+	writeEndpointCounter(w, "goproxy_dial_failures_total", "Failed connection attempts by endpoint.", m.dialFailures)
+}
+
+// writeEndpointCounter formats a counter by service and endpoint.
+func writeEndpointCounter(w *bufio.Writer, metric, help string, counts map[endpointKey]uint64) {
+	keys := make([]endpointKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.version != b.version {
+			return a.version < b.version
+		}
+		return a.endpoint < b.endpoint
+	})
+	fmt.Fprintf(w, "# HELP %s %s\n", metric, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", metric)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{service=%s,version=%s,endpoint=%s} %d\n", metric, quote(key.name), quote(key.version), quote(key.endpoint), counts[key])
+	}
+}
+
+// labelEscaper escapes the label values of the text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns the quoted label value.
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
@@ -0,0 +1,74 @@
+package prommetrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy"
+	"github.com/creack/goproxy/registry"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewWithBuckets([]float64{0.1, 1})
+	m.IncRequest("service1", "v1", 200)
+	m.IncRequest("service1", "v1", 200)
+	m.IncRequest("service1", "v1", 502)
+	m.ObserveLatency("service1", "v1", 50*time.Millisecond)
+	m.ObserveLatency("service1", "v1", 2*time.Second)
+	m.IncDial("service1", "v1", "host1:80")
+	m.IncDialFailure("service1", "v1", `host"2:80`, errors.New("refused"))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`goproxy_requests_total{service="service1",version="v1",code="200"} 2`,
+		`goproxy_requests_total{service="service1",version="v1",code="502"} 1`,
+		`goproxy_request_duration_seconds_bucket{service="service1",version="v1",le="0.1"} 1`,
+		`goproxy_request_duration_seconds_bucket{service="service1",version="v1",le="1"} 1`,
+		`goproxy_request_duration_seconds_bucket{service="service1",version="v1",le="+Inf"} 2`,
+		`goproxy_request_duration_seconds_sum{service="service1",version="v1"} 2.05`,
+		`goproxy_request_duration_seconds_count{service="service1",version="v1"} 2`,
+		`goproxy_dials_total{service="service1",version="v1",endpoint="host1:80"} 1`,
+		`goproxy_dial_failures_total{service="service1",version="v1",endpoint="host\"2:80"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Missing line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsProxy(t *testing.T) {
+	m := New()
+	defer func(metrics goproxy.Metrics) { goproxy.DefaultMetrics = metrics }(goproxy.DefaultMetrics)
+	goproxy.DefaultMetrics = m
+
+	backend := httptest.NewServer(nil)
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	p := goproxy.NewProxy(reg)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/service1/v1/", nil))
+	// The unknown services don't get their own series.
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/random1/v1/", nil))
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/random2/v1/", nil))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`goproxy_requests_total{service="service1",version="v1",code="404"} 1`,
+		`goproxy_request_duration_seconds_count{service="service1",version="v1"} 1`,
+		`goproxy_requests_total{service="",version="",code="404"} 2`,
+		`goproxy_dials_total{service="service1",version="v1",endpoint="` + strings.TrimPrefix(backend.URL, "http://") + `"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("Missing line %q in:\n%s", line, w.Body.String())
+		}
+	}
+	if strings.Contains(w.Body.String(), "random") {
+		t.Errorf("Unexpected unknown service series in:\n%s", w.Body.String())
+	}
+}
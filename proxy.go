@@ -0,0 +1,940 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// Proxy is a load balancing reverse proxy implementing http.Handler.
+// It routes each request to one of the endpoints registered for the
+// service name/version found by ExtractNameVersion.
+type Proxy struct {
+	// FailureStatusCodes lists the upstream response status codes
+	// reported to the registry as endpoint failures, e.g. 502, 503, 504.
+	FailureStatusCodes []int
+
+	// RequestLogger, when set, logs the name/version, endpoint, status and latency
+	// of each proxied request. Websocket bridges log a start and a stop line instead.
+	RequestLogger *log.Logger
+
+	// MaxConns, when positive, caps the number of open upstream connections,
+	// active or idle. When the cap is reached, the idle connections are closed
+	// to make room: the requests needing a new connection past the cap of
+	// active ones get a 503 Service Unavailable.
+	MaxConns int
+
+	// HostSeparator separates the escaped service name and version in the
+	// host used to route requests from the Director to the Transport.
+	// When zero, '/' is used.
+	HostSeparator byte
+
+	// WebsocketExtensions, when not nil, lists the websocket extensions,
+	// e.g. "permessage-deflate", forwarded to the upstream in upgrade requests.
+	// The other offered extensions are stripped: an empty list disables
+	// websocket compression for backends which don't support it.
+	WebsocketExtensions []string
+
+	// Balancer, when set, selects the balancer for each service name/version.
+	// LoadBalance is used when it is nil or returns nil.
+	Balancer func(name, version string) LoadBalancer
+
+	// BufferPool is used to copy the response bodies.
+	// NewProxy sets it to a pool of 32KB buffers shared across requests.
+	BufferPool httputil.BufferPool
+
+	// RetryAfter is sent as Retry-After header with the 503 Service Unavailable
+	// responses for paused services, and for services without available
+	// endpoint when the registry has no RecoveryEstimator estimate.
+	// When zero, 30 seconds is used.
+	RetryAfter time.Duration
+
+	// MaxRetries, when positive, retries the requests which failed before
+	// being sent upstream. See RetryTransport.
+	// The request bodies are streamed upstream, never buffered: requests with
+	// a body can't be rewound, hence are not retried.
+	MaxRetries int
+
+	// RetryStatusCodes, RetryMethods and RetryBackoff complete MaxRetries,
+	// see the RetryTransport StatusCodes, Methods and Backoff.
+	// They are the defaults of the services without ServiceConfig Retry
+	// policy: a service policy replaces them as a whole.
+	RetryStatusCodes []int
+	RetryMethods     []string
+	RetryBackoff     BackoffPolicy
+
+	// UpstreamTLSConfig, when set, returns the TLS config used to connect to the
+	// endpoints of the given service name/version. Services with a nil config
+	// are proxied over plain HTTP.
+	// When the config has no ServerName, the host of the registered endpoint is
+	// verified: set it to the backend hostname for endpoints registered by IP.
+	UpstreamTLSConfig func(name, version string) *tls.Config
+
+	// MaxResponseBodyBytes, when positive, caps the size of the upstream response bodies.
+	// Responses announcing a larger Content-Length get a 502 Bad Gateway, streamed
+	// responses are truncated and their client connection closed past the limit.
+	MaxResponseBodyBytes int64
+
+	// ConnectRoute, when set, enables the tunneling of CONNECT requests.
+	// It maps the requested authority to a service name/version, e.g. AuthorityRoute,
+	// and the connection is bridged to one of its endpoints. CONNECT requests
+	// get a 405 Method Not Allowed when it is nil.
+	ConnectRoute func(authority string) (name, version string, err error)
+
+	// ModifyResponse, when set, is called with the upstream responses after
+	// the Proxy own processing, like httputil.ReverseProxy.ModifyResponse.
+	// The resolved service and endpoint are available with ServiceFromContext
+	// and EndpointFromContext on resp.Request.Context().
+	// When it returns an error, the client gets a 502 Bad Gateway.
+	ModifyResponse func(*http.Response) error
+
+	// TrustedProxies lists the networks of the proxies in front of the Proxy.
+	// The inbound X-Forwarded-For is extended with the peer address only when
+	// the peer is in one of them, otherwise it is replaced by the peer address.
+	// When empty, the inbound X-Forwarded-For is never trusted.
+	TrustedProxies []netip.Prefix
+
+	// Forwarding selects the headers describing the proxy hop to the upstreams:
+	// X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto by default,
+	// the RFC 7239 Forwarded header, or both.
+	Forwarding ForwardingHeaders
+
+	// ForwardedBy, when set, is sent as `by` parameter of the Forwarded header,
+	// e.g. an obfuscated identifier of the proxy like "_proxy1".
+	ForwardedBy string
+
+	// VersionRules, when set, remap the version extracted by ExtractNameVersion
+	// before the lookup. They are tried in order and the first match wins.
+	// They take precedence over the version found by ExtractNameVersion, be it
+	// from the path, a header or a query parameter as with ExtractNameVersionQuery.
+	// DefaultService only applies to the remapped services.
+	VersionRules []VersionRule
+
+	// TrafficSplit, when set, shifts the requests to weighted versions,
+	// e.g. for a canary, after the VersionRules.
+	TrafficSplit *TrafficSplit
+
+	// ReadyTimeout, when positive, holds the requests for services without
+	// endpoints until the registry has one, up to ReadyTimeout, e.g. at startup
+	// while a discovery backend populates it. When the registry is, or wraps,
+	// a registry.ReadyNotifier, the requests are only held until its initial
+	// sync completes and get a 503 Service Unavailable when it doesn't in time.
+	// Otherwise, the registry is polled during the first ReadyTimeout after
+	// the Proxy starts serving: the requests for services without endpoints
+	// are held until then at most, and fail as usual afterwards.
+	ReadyTimeout time.Duration
+
+	// DefaultService and DefaultVersion, when DefaultService is set, name the
+	// catch-all service receiving, with their original path, the requests
+	// for services not found in the registry as well as the requests
+	// ExtractNameVersion fails to route, e.g. with less than two path segments.
+	DefaultService, DefaultVersion string
+
+	// PathPrefix, when set, returns the base path of the endpoints of the
+	// given service name/version, e.g. with PathPrefixes. It is prepended to
+	// the path left by ExtractNameVersion: with "/api", `/service1/v1/users`
+	// is forwarded as `/api/users`.
+	PathPrefix func(name, version string) string
+
+	// StickyKey, when set, returns the affinity key of the request, e.g. with
+	// StickyCookie. The requests with the same key are pinned to the same endpoint,
+	// selected by rendezvous hashing among the endpoints of the first non-empty
+	// tier of the service, so adding or removing an endpoint only moves the keys
+	// pinned to it. The requests without key are balanced as usual.
+	//
+	// When the pinned endpoint can't be dialed, the request falls back to the
+	// service balancer, and the next request tries the pinned endpoint again:
+	// the affinity is lenient, a session may hit another endpoint while its own
+	// is down. Endpoints excluded by the registry, e.g. unhealthy or draining,
+	// move their keys until they are back.
+	StickyKey func(req *http.Request) string
+
+	// SelectionSeed, when set, returns the selection seed of the request, e.g.
+	// a user ID with SelectionSeedHeader, for reproducible A/B experiments:
+	// the requests with the same seed land on the same version of the
+	// TrafficSplit, and on the same endpoint, selected by rendezvous hashing
+	// like with StickyKey. The requests without seed are balanced as usual.
+	//
+	// The StickyKey, when the request has one, takes precedence for the
+	// endpoint selection, not for the version. Like the sticky sessions,
+	// the seeds move when their endpoint is down or excluded, unless
+	// StrictAffinity is set, and when the endpoints change.
+	SelectionSeed func(req *http.Request) string
+
+	// StrictAffinity, when set, fails the requests whose pinned endpoint
+	// can't be dialed with a 502 Bad Gateway instead of falling back.
+	StrictAffinity bool
+
+	// MaxClientUpgrades, when positive, caps the number of concurrent upgraded
+	// connections, e.g. websockets, and CONNECT tunnels per client. The requests
+	// past the cap get a 429 Too Many Requests.
+	MaxClientUpgrades int
+
+	// ClientKey identifies the client of a request for MaxClientUpgrades.
+	// When nil, the IP address of the peer is used.
+	ClientKey func(req *http.Request) string
+
+	// MaxRequestHeaderBytes, when positive, caps the total size of the request
+	// headers, each counted as `Key: value\r\n`. Larger requests get a
+	// 431 Request Header Fields Too Large instead of being proxied. The check
+	// runs before the Director: the headers added by the Proxy, such as
+	// X-Forwarded-For, are not counted.
+	MaxRequestHeaderBytes int
+
+	// ResponseHeaders are set on the responses of the proxied requests, replacing
+	// the upstream values, e.g. X-Served-By or security headers.
+	// The message framing headers (Content-Length, Transfer-Encoding, Connection,
+	// Upgrade and Trailer) are never injected: use ModifyResponse when intended.
+	ResponseHeaders http.Header
+
+	// UpstreamReadTimeout and UpstreamWriteTimeout, when positive, bound each
+	// read from and write to the upstream connection of a request, e.g. so that
+	// a backend stalling in the middle of a response body doesn't hang the
+	// request forever. They are idle timeouts, reset by every read or write:
+	// streaming responses run as long as the backend keeps sending.
+	// The requests timing out before the response headers get a 504 Gateway
+	// Timeout, the others have their client connection aborted.
+	// The upgraded connections and CONNECT tunnels are not bounded.
+	UpstreamReadTimeout, UpstreamWriteTimeout time.Duration
+
+	// ServiceResponseHeaders, when set, returns the response headers of the given
+	// service name/version, overriding ResponseHeaders key by key.
+	// A key without values disables the injection of the header for the service.
+	ServiceResponseHeaders func(name, version string) http.Header
+
+	// ServiceConfig, when set, returns the settings of the given service
+	// name/version, e.g. with ServiceConfigs, consolidating the per-service
+	// callbacks: its zero fields fall back to them. It returns nil for the
+	// services without explicit config, which get the Proxy defaults.
+	ServiceConfig func(name, version string) *ServiceConfig
+
+	// ExtractErrorHandler, when set, writes the response of the requests
+	// ExtractNameVersion fails to route, when there is no DefaultService to
+	// send them to, instead of a 500 Internal Server Error with the error
+	// message. A panic of ExtractNameVersion is recovered as an error.
+	//
+	// The failed requests go through the same steps as the proxied ones:
+	// after Filter, the LoadShedder and the other admission checks, and
+	// before DefaultMetrics and RequestLogger, which record them with an
+	// empty service name/version. The middlewares wrapping the Proxy see
+	// them as any other request.
+	ExtractErrorHandler func(w http.ResponseWriter, req *http.Request, err error)
+
+	// Filter, when set, is called first with each request, before any routing,
+	// e.g. for IP allow-listing or a maintenance mode. The requests it rejects
+	// by returning false are not proxied: it must write their response.
+	// It applies uniformly to all the requests, including CONNECT requests and
+	// the requests ExtractNameVersion can't route.
+	Filter func(w http.ResponseWriter, req *http.Request) (proceed bool)
+
+	// LoadShedder, when set, rejects the requests with a 503 Service Unavailable
+	// when the proxy is under pressure, before they are routed.
+	// NewProxy sets it to DefaultLoadShedder.
+	LoadShedder *LoadShedder
+
+	// ServerTiming, when set, adds a Server-Timing response header reporting
+	// the time spent getting the upstream connection, `upstream-dial`, zero
+	// for a pooled one, and the time until the upstream response headers,
+	// dial and retries included, `upstream`: `upstream-dial;dur=0.4, upstream;dur=42.1`.
+	// The Server-Timing values of the upstream are kept.
+	ServerTiming bool
+
+	// DebugErrors, when set, details the resolved service name/version, endpoint
+	// and error in the body of the error responses, e.g. for local debugging.
+	// It exposes the internals of the routing: keep it off in production.
+	DebugErrors bool
+
+	// DebugEndpointParam, when set, e.g. to "__endpoint", names the query
+	// parameter forcing the endpoint of a request, for end-to-end testing:
+	// `?__endpoint=host:port`. The endpoint must be available for the resolved
+	// service, otherwise the request gets a 400 Bad Request. The parameter is
+	// stripped before forwarding and the request fails when the endpoint
+	// can't be dialed. It lets clients pick the backends: keep it off in production.
+	DebugEndpointParam string
+
+	// Transport, when set, returns the transport of the given service
+	// name/version, e.g. with Transports, for the upstreams needing their own
+	// tuning: HTTP/2, timeouts, client certificates. The Proxy transport is
+	// used when it is nil or returns nil.
+	// The request host is the service encoded by the Director: the custom
+	// transports must connect with the Proxy Dial, or DialTLS for the services
+	// with an UpstreamTLSConfig.
+	Transport func(name, version string) http.RoundTripper
+
+	registry     registry.Registry
+	transport    *http.Transport
+	conns        atomic.Int64
+	once         sync.Once
+	reverseProxy *httputil.ReverseProxy
+	readyEnd     time.Time // End of the polling window of waitReady.
+
+	mu      sync.Mutex
+	closing bool          // Set by Shutdown and Close.
+	active  int           // Requests in flight, excluding upgrades.
+	drained chan struct{} // Closed when active drops to zero during Shutdown.
+
+	clientLock     sync.Mutex
+	clientUpgrades map[string]int
+
+	upstreamLock sync.Mutex
+	upstreams    map[*proxyConn]struct{} // Open upstream connections, see CloseServiceConns.
+}
+
+// NewProxy creates a Proxy routing requests to the endpoints of the given registry.
+func NewProxy(reg registry.Registry) *Proxy {
+	p := &Proxy{registry: reg, BufferPool: newBufferPool(), LoadShedder: DefaultLoadShedder}
+	p.transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		Dial:                p.Dial,
+		DialTLS:             p.DialTLS,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		// Relay `Expect: 100-continue` to the upstream: the body is only
+		// read from the client once the upstream is dialed and accepts it.
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return p
+}
+
+type contextKey int
+
+const (
+	routeContextKey contextKey = iota
+	routeSlotContextKey
+)
+
+// route holds the routing decisions made for a request.
+type route struct {
+	name, version string
+	pinned        string            // Endpoint selected for the StickyKey, SelectionSeed or DebugEndpointParam, if any.
+	forced        bool              // Set when pinned comes from DebugEndpointParam: there is no fallback.
+	endpoint      string            // Set once the upstream connection is obtained.
+	tags          map[string]string // Endpoint tags, set along with endpoint from reg.
+	reg           registry.Registry // Proxy registry, to look up the tags.
+	status        int               // Set once the upstream response is received.
+	unknown       bool              // Set when the service is not found in the registry.
+	conn          *proxyConn        // Set once the upstream connection is obtained, when dialed by the Proxy.
+	use           uint64            // Use of conn by the request, see proxyConn.acquire.
+	readTimeout   time.Duration     // Upstream idle timeouts, see proxyConn.arm.
+	writeTimeout  time.Duration     // Write counterpart of readTimeout.
+	timing        bool              // Set to measure getConn and dial for ServerTiming.
+	getConn       time.Time         // First connection request of the Transport.
+	dial          time.Duration     // Time spent getting the last connection.
+	catchAll      *url.URL          // Original path, to retry on the DefaultService when the service is not found.
+}
+
+// withRoute returns a copy of the request carrying the given route.
+// The route endpoint is updated when the Transport gets its connection,
+// which is armed with the route upstream timeouts.
+func withRoute(req *http.Request, r *route) *http.Request {
+	ctx := context.WithValue(req.Context(), routeContextKey, r)
+	var getConn time.Time
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			if r.timing {
+				getConn = time.Now()
+				if r.getConn.IsZero() {
+					r.getConn = getConn
+				}
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if r.timing {
+				r.dial = time.Since(getConn)
+			}
+			r.endpoint = connEndpoint(info.Conn)
+			if r.reg != nil {
+				r.tags = registry.Tags(r.reg, r.name, r.version, r.endpoint)
+			}
+			if conn, ok := info.Conn.(interface{ upstream() *proxyConn }); ok {
+				r.conn = conn.upstream()
+				// A multiplexed connection is shared by the streams, tracked
+				// by the HTTP2Transport: it is never put idle.
+				if !r.conn.multiplexed.Load() {
+					r.use = r.conn.acquire(r.readTimeout, r.writeTimeout)
+				}
+			}
+		},
+		PutIdleConn: func(err error) {
+			// The connection may already be pooled and handed to the next
+			// request: only release it if this request still owns it.
+			if err == nil && r.conn != nil {
+				r.conn.release(r.use)
+			}
+		},
+	})
+	return req.WithContext(ctx)
+}
+
+// publishRoute stores r in the route slot of the request context, if any,
+// so that the middlewares wrapping the Proxy, like AccessLog, can read it
+// once the request is served.
+func publishRoute(req *http.Request, r *route) {
+	if slot, ok := req.Context().Value(routeSlotContextKey).(**route); ok {
+		*slot = r
+	}
+}
+
+// routeFromContext returns the route stored in ctx, if any.
+func routeFromContext(ctx context.Context) (*route, bool) {
+	r, ok := ctx.Value(routeContextKey).(*route)
+	return r, ok
+}
+
+// ServiceFromContext returns the service name/version resolved by the Proxy
+// for the request carrying ctx. It is available to the Transport and to the
+// response and error hooks of the upstream requests.
+func ServiceFromContext(ctx context.Context) (name, version string, ok bool) {
+	r, ok := routeFromContext(ctx)
+	if !ok {
+		return "", "", false
+	}
+	return r.name, r.version, true
+}
+
+// EndpointFromContext returns the endpoint selected by the balancer for the
+// request carrying ctx, once its upstream connection is obtained.
+func EndpointFromContext(ctx context.Context) (string, bool) {
+	r, ok := routeFromContext(ctx)
+	if !ok || r.endpoint == "" {
+		return "", false
+	}
+	return r.endpoint, true
+}
+
+// EndpointTagsFromContext returns the tags of the endpoint selected by the
+// balancer for the request carrying ctx, as found in the Proxy registry once
+// its upstream connection is obtained, e.g. to label metrics by region or zone.
+// The returned map must not be modified.
+func EndpointTagsFromContext(ctx context.Context) (map[string]string, bool) {
+	r, ok := routeFromContext(ctx)
+	if !ok || r.tags == nil {
+		return nil, false
+	}
+	return r.tags, true
+}
+
+// Dial decodes the service name/version set as host by the Director
+// and uses its balancer to connect to one of its endpoints, enforcing MaxConns.
+// It is the Dial function of the custom transports set with Transport.
+func (p *Proxy) Dial(network, addr string) (net.Conn, error) {
+	name, version, endpoint, err := parseRoutingHost(addr, p.hostSeparator())
+	if err != nil {
+		// The Director and the Transport disagree on the host encoding.
+		log.Printf("goproxy: malformed routing host %q", addr)
+		return nil, fmt.Errorf("%w: malformed routing host %q", err, addr)
+	}
+	if endpoint != "" {
+		return p.dialService(network, name, version, p.pinnedBalancer(endpoint))
+	}
+	return p.dialService(network, name, version, p.balancer(name, version))
+}
+
+// dialService uses lb to connect to one of the endpoints of the service,
+// enforcing MaxConns.
+func (p *Proxy) dialService(network, name, version string, lb LoadBalancer) (net.Conn, error) {
+	if n := p.conns.Add(1); p.MaxConns > 0 && n > int64(p.MaxConns) {
+		p.conns.Add(-1)
+		// The idle connections of other services may hold the cap.
+		if p.closeIdleConns() == 0 {
+			return nil, ErrTooManyConns
+		}
+		if n := p.conns.Add(1); n > int64(p.MaxConns) {
+			p.conns.Add(-1)
+			return nil, ErrTooManyConns
+		}
+	}
+	conn, err := lb(network, name, version, p.registry)
+	if err != nil {
+		p.conns.Add(-1)
+		return nil, err
+	}
+	pc := &proxyConn{Conn: conn, name: name, version: version, uses: 1}
+	// The connection is dialed for a request: it is busy until it is put idle.
+	pc.owner.Store(pc.uses)
+	pc.onClose = func() {
+		p.conns.Add(-1)
+		p.untrackUpstream(pc)
+	}
+	p.trackUpstream(pc)
+	return pc, nil
+}
+
+// balancer returns the balancer to use for the given service name/version.
+func (p *Proxy) balancer(name, version string) LoadBalancer {
+	if lb := p.serviceConfig(name, version).Balancer; lb != nil {
+		return lb
+	}
+	if p.Balancer != nil {
+		if lb := p.Balancer(name, version); lb != nil {
+			return lb
+		}
+	}
+	return LoadBalance
+}
+
+// bufferPool is a httputil.BufferPool backed by a sync.Pool.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool() *bufferPool {
+	return &bufferPool{pool: sync.Pool{New: func() any { return make([]byte, 32*1024) }}}
+}
+
+func (b *bufferPool) Get() []byte  { return b.pool.Get().([]byte) }
+func (b *bufferPool) Put(v []byte) { b.pool.Put(v) }
+
+// proxyConn is an upstream connection counted by the Proxy.
+type proxyConn struct {
+	net.Conn
+	name, version string
+	owner         atomic.Uint64 // Use of the current request, 0 while idle in the Transport pool, see acquire.
+	uses          uint64        // Last use handed out by acquire, guarded by lock.
+	lock          sync.Mutex    // Serializes acquire and release.
+	readTimeout   atomic.Int64  // Armed idle timeouts of the current request, see arm.
+	writeTimeout  atomic.Int64
+	multiplexed   atomic.Bool  // Set for the connections of an HTTP2Transport, shared by the requests.
+	streams       atomic.Int64 // Streams in flight, when multiplexed.
+	once          sync.Once
+	onClose       func()
+}
+
+// connEndpoint returns the endpoint of the given upstream connection.
+func connEndpoint(conn net.Conn) string {
+	if conn, ok := conn.(interface{ Endpoint() string }); ok {
+		return conn.Endpoint()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// idle reports whether the connection is unused: idle in the Transport pool,
+// or without stream in flight when multiplexed.
+func (c *proxyConn) idle() bool {
+	if c.multiplexed.Load() {
+		return c.streams.Load() == 0
+	}
+	return c.owner.Load() == 0
+}
+
+// upstream returns the connection itself.
+func (c *proxyConn) upstream() *proxyConn { return c }
+
+// Endpoint returns the registry endpoint the connection was dialed to,
+// when known by the balancer.
+func (c *proxyConn) Endpoint() string {
+	if conn, ok := c.Conn.(interface{ Endpoint() string }); ok {
+		return conn.Endpoint()
+	}
+	return c.Conn.RemoteAddr().String()
+}
+
+// Close closes the connection and stops counting it.
+func (c *proxyConn) Close() error {
+	c.once.Do(c.onClose)
+	return c.Conn.Close()
+}
+
+// modifyResponse reports the configured failure status codes to the registry,
+// enforces MaxResponseBodyBytes, injects the response headers and calls
+// the user ModifyResponse.
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	r, ok := routeFromContext(resp.Request.Context())
+	if !ok {
+		return nil
+	}
+	r.status = resp.StatusCode
+	if r.status == http.StatusSwitchingProtocols && r.conn != nil {
+		r.conn.disarm()
+	}
+	if r.status == http.StatusSwitchingProtocols && p.RequestLogger != nil {
+		p.RequestLogger.Printf("%s/%s %s: bridge started", r.name, r.version, r.endpoint)
+	}
+	for _, code := range p.FailureStatusCodes {
+		if r.status != code {
+			continue
+		}
+		if r.endpoint != "" {
+			p.registry.Failure(r.name, r.version, r.endpoint, fmt.Errorf("Unexpected status %s", resp.Status))
+		}
+		break
+	}
+	if p.MaxResponseBodyBytes > 0 && r.status != http.StatusSwitchingProtocols {
+		if err := p.limitBody(resp, r); err != nil {
+			return err
+		}
+	}
+	p.injectHeaders(resp.Header, r.name, r.version)
+	if r.timing {
+		// Keep the metrics of the upstream, if any.
+		resp.Header.Add("Server-Timing", fmt.Sprintf("upstream-dial;dur=%s, upstream;dur=%s", serverTimingMillis(r.dial), serverTimingMillis(time.Since(r.getConn))))
+	}
+	if p.ModifyResponse != nil {
+		return p.ModifyResponse(resp)
+	}
+	return nil
+}
+
+// serverTimingMillis formats d in milliseconds as Server-Timing duration.
+func serverTimingMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+}
+
+// limitBody enforces MaxResponseBodyBytes on the response body.
+func (p *Proxy) limitBody(resp *http.Response, r *route) error {
+	if resp.ContentLength > p.MaxResponseBodyBytes {
+		log.Printf("goproxy: %s/%s %s: response body of %d bytes exceeds %d bytes", r.name, r.version, r.endpoint, resp.ContentLength, p.MaxResponseBodyBytes)
+		return ErrBodyTooLarge
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: p.MaxResponseBodyBytes, onExceed: func() {
+		log.Printf("goproxy: %s/%s %s: response body exceeds %d bytes", r.name, r.version, r.endpoint, p.MaxResponseBodyBytes)
+	}}
+	return nil
+}
+
+// limitedBody is a response body failing with ErrBodyTooLarge once more
+// than remaining bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	onExceed  func()
+}
+
+func (b *limitedBody) Read(buf []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// Read one byte past the limit to detect the overflow.
+	if int64(len(buf)) > b.remaining+1 {
+		buf = buf[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(buf)
+	if int64(n) > b.remaining {
+		n, b.remaining = int(b.remaining), -1
+		b.onExceed()
+		return n, ErrBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// errorHandler replies to the requests which could not be proxied.
+func (p *Proxy) errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	r, ok := routeFromContext(req.Context())
+	if ok {
+		// The endpoint is empty when the error happened before getting a connection.
+		log.Printf("http: proxy error: %s/%s %s: %v", r.name, r.version, r.endpoint, err)
+		p.injectHeaders(w.Header(), r.name, r.version)
+	} else {
+		log.Printf("http: proxy error: %v", err)
+	}
+	status, message := http.StatusBadGateway, ""
+	defer func() {
+		if ok {
+			r.status = status
+		}
+	}()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	case errors.Is(err, registry.ErrServicePaused):
+		w.Header().Set("Retry-After", retryAfterSeconds(p.retryAfter()))
+		status, message = http.StatusServiceUnavailable, "Service paused"
+	case errors.Is(err, ErrTooManyConns):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, registry.ErrServiceNotFound):
+		if ok {
+			r.unknown = true
+		}
+		status, message = http.StatusNotFound, "Service not found"
+	case errors.Is(err, registry.ErrNoEndpoints):
+		retryAfter := p.retryAfter()
+		if estimator, isEstimator := p.registry.(RecoveryEstimator); isEstimator && ok {
+			if estimate, known := estimator.RecoveryEstimate(r.name, r.version); known {
+				retryAfter = estimate
+			}
+		}
+		w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		status, message = http.StatusServiceUnavailable, "No endpoint available"
+	case errors.Is(err, ErrInvalidService):
+		status, message = http.StatusInternalServerError, "Internal routing error"
+	}
+	if p.DebugErrors && ok {
+		message = fmt.Sprintf("%s\nservice: %s/%s\nendpoint: %s\nerror: %v", http.StatusText(status), r.name, r.version, r.endpoint, err)
+	}
+	if message == "" {
+		w.WriteHeader(status)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// retryAfter returns the default Retry-After delay.
+func (p *Proxy) retryAfter() time.Duration {
+	if p.RetryAfter == 0 {
+		return 30 * time.Second
+	}
+	return p.RetryAfter
+}
+
+// retryAfterSeconds formats d as Retry-After value, rounded up to the second.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// logRequest records the metrics and logs the completion of the request
+// routed through r.
+func (p *Proxy) logRequest(req *http.Request, r *route, start time.Time) {
+	// The unknown services come from the client: they are recorded with
+	// an empty name/version, as the requests failing extraction.
+	name, version := r.name, r.version
+	if r.unknown {
+		name, version = "", ""
+	}
+	DefaultMetrics.IncRequest(name, version, r.status)
+	DefaultMetrics.ObserveLatency(name, version, time.Since(start))
+	if p.RequestLogger == nil {
+		return
+	}
+	if r.status == http.StatusSwitchingProtocols {
+		p.RequestLogger.Printf("%s/%s %s: bridge closed after %s", r.name, r.version, r.endpoint, time.Since(start))
+		return
+	}
+	p.RequestLogger.Printf("%s/%s %s: %s %s %d %s", r.name, r.version, r.endpoint, req.Method, req.URL.Path, r.status, time.Since(start))
+}
+
+// extract calls ExtractNameVersion, recovering its panics as errors.
+func extract(target *url.URL) (name, version string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			name, version, err = "", "", fmt.Errorf("Failed to extract name/version: %v", recovered)
+		}
+	}()
+	return ExtractNameVersion(target)
+}
+
+// extractError responds to a request ExtractNameVersion failed to route,
+// with the ExtractErrorHandler if any, and records it.
+func (p *Proxy) extractError(w http.ResponseWriter, req *http.Request, err error, start time.Time) {
+	r := &route{}
+	publishRoute(req, r)
+	if p.ExtractErrorHandler == nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		r.status = http.StatusInternalServerError
+	} else {
+		sw := &statusWriter{ResponseWriter: w}
+		p.ExtractErrorHandler(sw, req, err)
+		if r.status = sw.status; r.status == 0 {
+			r.status = http.StatusOK
+		}
+	}
+	p.logRequest(req, r, start)
+}
+
+// director routes the outgoing request to the service name/version
+// resolved by ServeHTTP.
+func (p *Proxy) director(req *http.Request) {
+	r, _ := routeFromContext(req.Context())
+	routeRequest(req, r.name, r.version, p.hostSeparator(), p.TrustedProxies)
+	p.forwarded(req)
+	if r.pinned != "" {
+		req.URL.Host = encodePinnedHost(r.name, r.version, r.pinned, p.hostSeparator())
+	}
+	if prefix := p.pathPrefix(r.name, r.version); prefix != "" {
+		prependPath(req.URL, prefix)
+	}
+	if p.upstreamTLSConfig(r.name, r.version) != nil {
+		req.URL.Scheme = "https"
+	}
+	if p.WebsocketExtensions != nil && IsWebsocket(req) {
+		filterWebsocketExtensions(req.Header, p.WebsocketExtensions)
+	}
+}
+
+// init creates the ReverseProxy shared by all the requests.
+func (p *Proxy) init() {
+	p.readyEnd = time.Now().Add(p.ReadyTimeout)
+	var transport http.RoundTripper = p.transport
+	if p.Transport != nil || p.ServiceConfig != nil {
+		transport = serviceTransport{proxy: p}
+	}
+	if (p.StickyKey != nil || p.SelectionSeed != nil) && !p.StrictAffinity {
+		transport = &fallbackTransport{Transport: transport, proxy: p}
+	}
+	if p.MaxRetries > 0 || p.ServiceConfig != nil {
+		transport = serviceRetryTransport{Transport: transport, proxy: p}
+	}
+	if p.DefaultService != "" {
+		transport = defaultServiceTransport{Transport: transport, proxy: p}
+	}
+	p.reverseProxy = &httputil.ReverseProxy{
+		Director:       p.director,
+		Transport:      transport,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+		BufferPool:     p.BufferPool,
+	}
+}
+
+// defaultServiceTransport is an http.RoundTripper sending the requests for
+// services not found in the registry to the DefaultService, with their
+// original path. The service is only known to be missing once the Transport
+// dials it: this saves a registry lookup per request.
+type defaultServiceTransport struct {
+	Transport http.RoundTripper
+	proxy     *Proxy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t defaultServiceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := routeFromContext(req.Context())
+	if !ok || r.catchAll == nil {
+		return t.Transport.RoundTrip(req)
+	}
+	// The body is not read when the dial fails: keep it open for the default service.
+	body := req.Body
+	attempt := req
+	if body != nil {
+		attempt = req.Clone(req.Context())
+		attempt.Body = io.NopCloser(body)
+	}
+	resp, err := t.Transport.RoundTrip(attempt)
+	if !errors.Is(err, registry.ErrServiceNotFound) {
+		return resp, err
+	}
+	p := t.proxy
+	r.name, r.version, r.pinned, r.forced = p.DefaultService, p.DefaultVersion, "", false
+	fallback := req.Clone(req.Context())
+	fallback.Body = body
+	fallback.URL.Path, fallback.URL.RawPath = r.catchAll.Path, r.catchAll.RawPath
+	r.catchAll = nil
+	if prefix := p.pathPrefix(r.name, r.version); prefix != "" {
+		prependPath(fallback.URL, prefix)
+	}
+	fallback.URL.Scheme = "http"
+	if p.upstreamTLSConfig(r.name, r.version) != nil {
+		fallback.URL.Scheme = "https"
+	}
+	fallback.URL.Host = encodeServiceHost(r.name, r.version, p.hostSeparator())
+	return t.Transport.RoundTrip(fallback)
+}
+
+// ServeHTTP proxies the request to an endpoint of the requested service.
+// The Proxy fields must not be modified once it started serving.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.once.Do(p.init)
+
+	if p.Filter != nil && !p.Filter(w, req) {
+		return
+	}
+	if p.LoadShedder != nil && p.LoadShedder.Shed(req) {
+		w.Header().Set("Retry-After", retryAfterSeconds(p.retryAfter()))
+		http.Error(w, "Proxy overloaded", http.StatusServiceUnavailable)
+		return
+	}
+	tracked, ok := p.enter(req)
+	if !ok {
+		http.Error(w, "Proxy shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if tracked {
+		defer p.leave()
+	} else if p.MaxClientUpgrades > 0 {
+		key := p.clientKey(req)
+		if !p.acquireUpgrade(key) {
+			http.Error(w, "Too many connections", http.StatusTooManyRequests)
+			return
+		}
+		// The bridges are closed when ServeHTTP returns, even on error.
+		defer p.releaseUpgrade(key)
+	}
+
+	if p.MaxRequestHeaderBytes > 0 && headerSize(req.Header) > p.MaxRequestHeaderBytes {
+		http.Error(w, "Request header fields too large", http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+	start := time.Now()
+	if req.Method == http.MethodConnect {
+		p.serveConnect(w, req, start)
+		return
+	}
+	path, rawPath := req.URL.Path, req.URL.RawPath
+	name, version, err := extract(req.URL)
+	if err == nil && p.VersionRules != nil {
+		version = applyVersionRules(p.VersionRules, name, version, req.URL.Path)
+	}
+	var seed string
+	if p.SelectionSeed != nil {
+		seed = p.SelectionSeed(req)
+	}
+	if err == nil && p.TrafficSplit != nil {
+		version = p.TrafficSplit.pick(name, version, seed)
+	}
+	if err == nil && p.ReadyTimeout > 0 && !p.waitReady(req.Context(), name, version) {
+		w.Header().Set("Retry-After", retryAfterSeconds(p.retryAfter()))
+		http.Error(w, "Registry not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if p.DefaultService != "" && err != nil {
+		// Route to the catch-all service with the original path.
+		req.URL.Path, req.URL.RawPath = path, rawPath
+		name, version, err = p.DefaultService, p.DefaultVersion, nil
+	}
+	if err != nil {
+		p.extractError(w, req, err, start)
+		return
+	}
+	// The registry only blocks new connections: the pooled ones would
+	// still reach the paused service.
+	if registry.Paused(p.registry, name, version) {
+		w.Header().Set("Retry-After", retryAfterSeconds(p.retryAfter()))
+		http.Error(w, "Service paused", http.StatusServiceUnavailable)
+		return
+	}
+	r := &route{name: name, version: version, reg: p.registry, readTimeout: p.UpstreamReadTimeout, writeTimeout: p.UpstreamWriteTimeout, timing: p.ServerTiming}
+	if p.DefaultService != "" && (name != p.DefaultService || version != p.DefaultVersion) {
+		r.catchAll = &url.URL{Path: path, RawPath: rawPath}
+	}
+	key := seed
+	if p.StickyKey != nil {
+		if sticky := p.StickyKey(req); sticky != "" {
+			key = sticky
+		}
+	}
+	if key != "" {
+		r.pinned = p.pin(key, name, version)
+	}
+	if p.DebugEndpointParam != "" {
+		endpoint, err := p.debugEndpoint(req, name, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if endpoint != "" {
+			r.pinned, r.forced = endpoint, true
+		}
+	}
+	publishRoute(req, r)
+	p.reverseProxy.ServeHTTP(w, withRoute(req, r))
+	p.logRequest(req, r, start)
+}
@@ -0,0 +1,58 @@
+package goproxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// waitReady holds the request until the registry has an endpoint for the
+// given service name/version, up to ReadyTimeout. When the registry is a
+// registry.ReadyNotifier, or wraps one, the request is only held until its
+// initial sync completes. It returns false when the registry is still not
+// synced once the timeout expires. Otherwise, the registry is polled until
+// the end of the startup window, so that the requests for unknown services
+// are not held once the registry is populated.
+func (p *Proxy) waitReady(ctx context.Context, name, version string) bool {
+	if registry.Count(p.registry, name, version) > 0 {
+		return true
+	}
+	if ready := registry.Ready(p.registry); ready != nil {
+		timer := time.NewTimer(p.ReadyTimeout)
+		defer timer.Stop()
+		select {
+		case <-ready:
+			return true
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return true
+		}
+	}
+	// Without sync signal, poll the registry during the startup window.
+	remaining := time.Until(p.readyEnd)
+	if remaining <= 0 {
+		return true
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if registry.Count(p.registry, name, version) > 0 {
+				return true
+			}
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+// readyPollInterval is the lookup interval of waitReady for the registries
+// which are not registry.ReadyNotifier.
+const readyPollInterval = 20 * time.Millisecond
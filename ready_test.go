@@ -0,0 +1,70 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// syncedRegistry is a registry signaling its initial sync.
+type syncedRegistry struct {
+	registry.DefaultRegistry
+	registry.Readiness
+}
+
+func TestProxyReadyTimeout(t *testing.T) {
+	backend := newBackend(t, "backend")
+	addr := backend.Listener.Addr().String()
+	serve := func(reg registry.Registry, timeout time.Duration) int {
+		p := NewProxy(reg)
+		p.ReadyTimeout = timeout
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+		return w.Code
+	}
+
+	// The request is held until the initial sync.
+	synced := &syncedRegistry{DefaultRegistry: registry.DefaultRegistry{}}
+	time.AfterFunc(20*time.Millisecond, func() {
+		synced.Add("service1", "v1", addr)
+		synced.MarkReady()
+	})
+	if status := serve(registry.NewDrainingRegistry(synced), time.Second); status != http.StatusOK {
+		t.Fatalf("Unexpected status once synced: %d", status)
+	}
+	if status := serve(&syncedRegistry{DefaultRegistry: registry.DefaultRegistry{}}, 20*time.Millisecond); status != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status before the sync: %d", status)
+	}
+	// Once synced, the unknown services fail right away.
+	synced.Delete("service1", "v1", addr)
+	start := time.Now()
+	if status := serve(synced, time.Second); status != http.StatusNotFound || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("Unexpected status after the sync: %d after %s", status, time.Since(start))
+	}
+
+	// Without sync signal, the registry is polled.
+	reg := registry.DefaultRegistry{}
+	time.AfterFunc(20*time.Millisecond, func() { reg.Add("service2", "v1", addr) })
+	p := NewProxy(reg)
+	p.ReadyTimeout = time.Second
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/service2/v1/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status once populated: %d", w.Code)
+	}
+
+	// Past the startup window, the unknown services fail right away.
+	p = NewProxy(reg)
+	p.ReadyTimeout = 50 * time.Millisecond
+	for _, held := range []bool{true, false} {
+		start := time.Now()
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/service3/v1/", nil))
+		if elapsed := time.Since(start); w.Code != http.StatusNotFound || (elapsed >= 40*time.Millisecond) != held {
+			t.Fatalf("Unexpected response: %d after %s, expected held %t", w.Code, elapsed, held)
+		}
+	}
+}
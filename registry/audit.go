@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+)
+
+// ErrReplaceUnsupported is recorded by AuditRegistry for a Replace the
+// wrapped registry can't apply, not being a Replacer.
+var ErrReplaceUnsupported = errors.New("registry does not support replace")
+
+// AuditEntry is the record of a registry mutation.
+type AuditEntry struct {
+	Time     time.Time
+	Op       string // "add", "delete", "failure" or "replace".
+	Name     string
+	Version  string
+	Endpoint string
+	Err      error  // Failure error, or ErrReplaceUnsupported for a replace not applied.
+	Caller   string // file:line of the caller of the mutation.
+}
+
+// String formats the entry as a log line.
+func (e AuditEntry) String() string {
+	s := fmt.Sprintf("registry audit: %s %s %s/%s", e.Time.Format(time.RFC3339Nano), e.Op, e.Name, e.Version)
+	if e.Endpoint != "" {
+		s += " (" + e.Endpoint + ")"
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s + " from " + e.Caller
+}
+
+// AuditRegistry wraps a Registry to record all its mutations, e.g. for
+// compliance: each Add, Delete, Failure and Replace call is passed to Log
+// before being delegated to the wrapped registry.
+type AuditRegistry struct {
+	Registry
+
+	// Log records the entries, e.g. to a durable sink. It is called
+	// synchronously by the mutations. When nil, the entries are logged
+	// with the standard logger.
+	Log func(AuditEntry)
+}
+
+// NewAuditRegistry creates an AuditRegistry wrapping the given registry
+// and recording its mutations with the given function.
+func NewAuditRegistry(reg Registry, log func(AuditEntry)) *AuditRegistry {
+	return &AuditRegistry{Registry: reg, Log: log}
+}
+
+// audit records a mutation called by the caller of the AuditRegistry method.
+func (r *AuditRegistry) audit(op, name, version, endpoint string, err error) {
+	entry := AuditEntry{Time: time.Now(), Op: op, Name: name, Version: version, Endpoint: endpoint, Err: err, Caller: "unknown"}
+	if _, file, line, ok := runtime.Caller(2); ok {
+		entry.Caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	if r.Log == nil {
+		log.Print(entry)
+		return
+	}
+	r.Log(entry)
+}
+
+// Add records and adds the given endpoint to the service name/version.
+func (r *AuditRegistry) Add(name, version, endpoint string) {
+	r.audit("add", name, version, endpoint, nil)
+	r.Registry.Add(name, version, endpoint)
+}
+
+// Delete records and removes the given endpoint from the service name/version.
+func (r *AuditRegistry) Delete(name, version, endpoint string) {
+	r.audit("delete", name, version, endpoint, nil)
+	r.Registry.Delete(name, version, endpoint)
+}
+
+// Failure records and reports the failure of the given endpoint.
+func (r *AuditRegistry) Failure(name, version, endpoint string, err error) {
+	r.audit("failure", name, version, endpoint, err)
+	r.Registry.Failure(name, version, endpoint, err)
+}
+
+// Replace records the replacement and replaces the content of the wrapped
+// registry when it is a Replacer. Otherwise, the content is left as is and
+// the attempt is recorded with ErrReplaceUnsupported.
+func (r *AuditRegistry) Replace(table DefaultRegistry) {
+	replacer, ok := r.Registry.(Replacer)
+	if !ok {
+		r.audit("replace", "", "", "", ErrReplaceUnsupported)
+		return
+	}
+	r.audit("replace", "", "", "", nil)
+	replacer.Replace(table)
+}
+
+// LookupTiers returns the endpoint tiers of the wrapped registry.
+func (r *AuditRegistry) LookupTiers(name, version string) ([][]string, error) {
+	return LookupTiers(r.Registry, name, version)
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *AuditRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// Unwrap returns the wrapped registry.
+func (r *AuditRegistry) Unwrap() Registry { return r.Registry }
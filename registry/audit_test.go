@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAuditRegistry(t *testing.T) {
+	var entries []AuditEntry
+	r := NewAuditRegistry(DefaultRegistry{}, func(e AuditEntry) { entries = append(entries, e) })
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service1", "v1", "host2:80")
+	r.Failure("service1", "v1", "host1:80", errors.New("boom"))
+	r.Delete("service1", "v1", "host1:80")
+	r.Replace(DefaultRegistry{"service2": {"v1": {"host3:80"}}})
+
+	if endpoints, err := r.Lookup("service2", "v1"); err != nil || fmt.Sprint(endpoints) != "[host3:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	var ops []string
+	for _, e := range entries {
+		ops = append(ops, e.Op+" "+e.Endpoint)
+		if !strings.Contains(e.Caller, "audit_test.go:") || e.Time.IsZero() {
+			t.Fatalf("Unexpected entry: %v", e)
+		}
+	}
+	if expect := "[add host1:80 add host2:80 failure host1:80 delete host1:80 replace ]"; fmt.Sprint(ops) != expect {
+		t.Fatalf("Unexpected entries: %v, expected %s", ops, expect)
+	}
+	if entries[2].Err == nil || !strings.HasSuffix(entries[2].String(), "(host1:80): boom from "+entries[2].Caller) {
+		t.Fatalf("Unexpected failure entry: %s", entries[2])
+	}
+}
+
+func TestAuditRegistryReplaceUnsupported(t *testing.T) {
+	var entries []AuditEntry
+	r := NewAuditRegistry(NewPriorityRegistry(), func(e AuditEntry) { entries = append(entries, e) })
+	r.Replace(DefaultRegistry{"service1": {"v1": {"host1:80"}}})
+	if len(entries) != 1 || entries[0].Op != "replace" || !errors.Is(entries[0].Err, ErrReplaceUnsupported) {
+		t.Fatalf("Unexpected entries: %v", entries)
+	}
+}
@@ -0,0 +1,40 @@
+package registry
+
+// Counter is implemented by registries able to count the endpoints of
+// a service without copying them, e.g. for capacity reporting on services
+// with thousands of endpoints, where a Lookup per report would allocate.
+//
+// The count is the number of registered endpoints: a wrapper filtering them,
+// like TTLRegistry or DrainingRegistry, can't count without looking them up,
+// so Count uses the Lookup of the registries which are not Counter.
+type Counter interface {
+	Count(name, version string) int // Return the number of endpoints for the given service name/version
+}
+
+// Count returns the number of endpoints of reg for the given service
+// name/version, 0 when it is unknown. Registries which are not Counter
+// are counted with Lookup.
+func Count(reg Registry, name, version string) int {
+	if counter, ok := reg.(Counter); ok {
+		return counter.Count(name, version)
+	}
+	endpoints, err := reg.Lookup(name, version)
+	if err != nil {
+		return 0
+	}
+	return len(endpoints)
+}
+
+// Count returns the number of endpoints for the given service name/version.
+func (r DefaultRegistry) Count(name, version string) int {
+	lock.RLock()
+	defer lock.RUnlock()
+	return len(r[name][version])
+}
+
+// Count returns the number of endpoints for the given service name/version.
+func (r *PriorityRegistry) Count(name, version string) int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.services[name][version])
+}
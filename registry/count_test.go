@@ -0,0 +1,30 @@
+package registry
+
+import "testing"
+
+func TestCount(t *testing.T) {
+	r := DefaultRegistry{}
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service1", "v1", "host2:80")
+	p := NewPriorityRegistry()
+	p.AddWithPriority("service1", "v1", "primary:80", 0)
+	p.AddWithPriority("service1", "v1", "backup:80", 1)
+	d := NewDrainingRegistry(r)
+	d.Drain("service1", "v1", "host1:80")
+
+	for _, elem := range []struct {
+		reg    Registry
+		expect int
+	}{
+		{r, 2},
+		{p, 2},
+		{d, 1}, // Counted through Lookup, without the draining endpoint.
+	} {
+		if n := Count(elem.reg, "service1", "v1"); n != elem.expect {
+			t.Errorf("Unexpected count for %T: %d, expected %d", elem.reg, n, elem.expect)
+		}
+		if n := Count(elem.reg, "service2", "v1"); n != 0 {
+			t.Errorf("Unexpected count of an unknown service for %T: %d", elem.reg, n)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// DelayedRegistry wraps a Registry to allow registering endpoints which
+// are not ready yet, e.g. booting instances: they are excluded from the
+// lookups until their readiness delay is elapsed.
+type DelayedRegistry struct {
+	Registry
+
+	lock    sync.Mutex
+	readyAt map[endpointKey]time.Time
+}
+
+// NewDelayedRegistry creates a DelayedRegistry wrapping the given registry.
+func NewDelayedRegistry(reg Registry) *DelayedRegistry {
+	return &DelayedRegistry{Registry: reg, readyAt: map[endpointKey]time.Time{}}
+}
+
+// AddDelayed adds the given endpoint for the service name/version,
+// excluded from the lookups for readyAfter.
+func (r *DelayedRegistry) AddDelayed(name, version, endpoint string, readyAfter time.Duration) {
+	r.lock.Lock()
+	r.readyAt[endpointKey{name, version, endpoint}] = time.Now().Add(readyAfter)
+	r.lock.Unlock()
+	r.Registry.Add(name, version, endpoint)
+}
+
+// Add adds the given endpoint for the service name/version, ready right away.
+func (r *DelayedRegistry) Add(name, version, endpoint string) {
+	r.lock.Lock()
+	delete(r.readyAt, endpointKey{name, version, endpoint})
+	r.lock.Unlock()
+	r.Registry.Add(name, version, endpoint)
+}
+
+// Delete removes the given endpoint for the service name/version.
+func (r *DelayedRegistry) Delete(name, version, endpoint string) {
+	r.Registry.Delete(name, version, endpoint)
+	r.lock.Lock()
+	delete(r.readyAt, endpointKey{name, version, endpoint})
+	r.lock.Unlock()
+}
+
+// IsReady reports whether the readiness delay of the given endpoint
+// for the service name/version is elapsed.
+func (r *DelayedRegistry) IsReady(name, version, endpoint string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.isReady(endpointKey{name, version, endpoint}, time.Now())
+}
+
+// isReady reports whether the endpoint is ready at now, forgetting its delay once elapsed.
+// The lock must be held.
+func (r *DelayedRegistry) isReady(key endpointKey, now time.Time) bool {
+	readyAt, ok := r.readyAt[key]
+	if !ok {
+		return true
+	}
+	if now.Before(readyAt) {
+		return false
+	}
+	delete(r.readyAt, key)
+	return true
+}
+
+// Lookup return the endpoint list for the given service name/version,
+// excluding the endpoints not ready yet.
+func (r *DelayedRegistry) Lookup(name, version string) ([]string, error) {
+	endpoints, err := r.Registry.Lookup(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return r.filter(name, version, endpoints), nil
+}
+
+// LookupTiers returns the endpoint tiers for the given service name/version,
+// excluding the endpoints not ready yet.
+func (r *DelayedRegistry) LookupTiers(name, version string) ([][]string, error) {
+	tiers, err := LookupTiers(r.Registry, name, version)
+	if err != nil {
+		return nil, err
+	}
+	for i, tier := range tiers {
+		tiers[i] = r.filter(name, version, tier)
+	}
+	return tiers, nil
+}
+
+// filter returns a copy of endpoints without the ones not ready yet.
+func (r *DelayedRegistry) filter(name, version string, endpoints []string) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	ret := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if r.isReady(endpointKey{name, version, endpoint}, now) {
+			ret = append(ret, endpoint)
+		}
+	}
+	return ret
+}
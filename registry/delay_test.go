@@ -0,0 +1,32 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDelayedRegistry(t *testing.T) {
+	r := NewDelayedRegistry(DefaultRegistry{})
+	r.Add("service1", "v1", "host1:80")
+	r.AddDelayed("service1", "v1", "host2:80", 50*time.Millisecond)
+	r.AddDelayed("service1", "v1", "host3:80", time.Hour)
+
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host1:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if r.IsReady("service1", "v1", "host2:80") || !r.IsReady("service1", "v1", "host1:80") {
+		t.Fatal("Unexpected readiness")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host1:80 host2:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	// Add makes the endpoint ready right away.
+	r.Add("service1", "v1", "host3:80")
+	if !r.IsReady("service1", "v1", "host3:80") {
+		t.Fatal("Add should end the readiness delay")
+	}
+}
@@ -0,0 +1,198 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// endpointKey identifies an endpoint of a service name/version.
+type endpointKey struct {
+	name, version, endpoint string
+}
+
+// DrainingRegistry wraps a Registry to allow draining endpoints: a draining
+// endpoint is excluded from new lookups while the existing connections to it
+// keep being served, which allows zero-downtime deploys.
+type DrainingRegistry struct {
+	Registry
+
+	// Active, when set, returns the number of active connections to the
+	// given endpoint, e.g. goproxy.Connections.Active. The endpoints removed
+	// by Replace are deleted by Sweep once it returns zero. When nil, they
+	// are deleted by the next Sweep.
+	Active func(endpoint string) int
+
+	lock     sync.RWMutex
+	draining map[endpointKey]bool
+	removed  map[endpointKey]bool // Draining endpoints removed by Replace.
+}
+
+// NewDrainingRegistry creates a DrainingRegistry wrapping the given registry.
+func NewDrainingRegistry(reg Registry) *DrainingRegistry {
+	return &DrainingRegistry{Registry: reg, draining: map[endpointKey]bool{}, removed: map[endpointKey]bool{}}
+}
+
+// Drain excludes the given endpoint for the service name/version from new lookups.
+// The endpoint is restored by Add and forgotten by Delete.
+func (r *DrainingRegistry) Drain(name, version, endpoint string) {
+	r.lock.Lock()
+	r.draining[endpointKey{name, version, endpoint}] = true
+	r.lock.Unlock()
+}
+
+// IsDraining reports whether the given endpoint for the service name/version is draining.
+func (r *DrainingRegistry) IsDraining(name, version, endpoint string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.draining[endpointKey{name, version, endpoint}]
+}
+
+// Add adds the given endpoint for the service name/version, ending its draining if any.
+func (r *DrainingRegistry) Add(name, version, endpoint string) {
+	r.lock.Lock()
+	delete(r.draining, endpointKey{name, version, endpoint})
+	delete(r.removed, endpointKey{name, version, endpoint})
+	r.lock.Unlock()
+	r.Registry.Add(name, version, endpoint)
+}
+
+// Delete removes the given endpoint for the service name/version.
+func (r *DrainingRegistry) Delete(name, version, endpoint string) {
+	r.Registry.Delete(name, version, endpoint)
+	r.lock.Lock()
+	delete(r.draining, endpointKey{name, version, endpoint})
+	delete(r.removed, endpointKey{name, version, endpoint})
+	r.lock.Unlock()
+}
+
+// Replace replaces the content of the wrapped registry with table, atomically
+// when the wrapped registry is a Replacer. The endpoints missing from table are
+// not severed: they are kept draining until Sweep deletes them once they have
+// no active connection left, so the in-flight requests complete.
+// The wrapped registry must be Enumerable for the removed endpoints to be found.
+//
+// The upgraded connections, e.g. websocket bridges, to a removed endpoint are
+// active connections as well: they keep the endpoint draining until they are
+// closed by either side.
+func (r *DrainingRegistry) Replace(table DefaultRegistry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Keep the removed endpoints along with the new table, including
+	// the ones still draining from a previous Replace.
+	merged := make(DefaultRegistry, len(table))
+	for name, versions := range table {
+		merged[name] = make(map[string][]string, len(versions))
+		for version, endpoints := range versions {
+			merged[name][version] = append([]string(nil), endpoints...)
+		}
+	}
+	var added, removed []endpointKey
+	for name, versions := range Enumerate(r.Registry) {
+		for _, version := range versions {
+			endpoints, _ := r.Registry.Lookup(name, version)
+			for _, endpoint := range endpoints {
+				key := endpointKey{name, version, endpoint}
+				if !contains(table[name][version], endpoint) {
+					removed = append(removed, key)
+					merged.add(name, version, endpoint)
+				}
+			}
+		}
+	}
+	for name, versions := range table {
+		for version, endpoints := range versions {
+			for _, endpoint := range endpoints {
+				added = append(added, endpointKey{name, version, endpoint})
+			}
+		}
+	}
+
+	if replacer, ok := r.Registry.(Replacer); ok {
+		replacer.Replace(merged)
+	} else {
+		// Not atomic: add the new endpoints before the removed ones are excluded.
+		for _, key := range added {
+			if current, _ := r.Registry.Lookup(key.name, key.version); !contains(current, key.endpoint) {
+				r.Registry.Add(key.name, key.version, key.endpoint)
+			}
+		}
+	}
+	for _, key := range added {
+		delete(r.draining, key)
+		delete(r.removed, key)
+	}
+	for _, key := range removed {
+		r.draining[key] = true
+		r.removed[key] = true
+	}
+}
+
+// contains reports whether endpoints has the given endpoint.
+func contains(endpoints []string, endpoint string) bool {
+	for _, e := range endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// Sweep deletes from the wrapped registry the endpoints removed by Replace
+// which have no active connection left.
+func (r *DrainingRegistry) Sweep() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for key := range r.removed {
+		if r.Active != nil && r.Active(key.endpoint) > 0 {
+			continue
+		}
+		delete(r.removed, key)
+		delete(r.draining, key)
+		r.Registry.Delete(key.name, key.version, key.endpoint)
+	}
+}
+
+// StartSweeper calls Sweep every interval in a new goroutine.
+// The returned function stops it and waits for its termination.
+func (r *DrainingRegistry) StartSweeper(interval time.Duration) (stop func()) {
+	return startSweeper(interval, r.Sweep)
+}
+
+// Lookup return the endpoint list for the given service name/version,
+// excluding the draining endpoints.
+func (r *DrainingRegistry) Lookup(name, version string) ([]string, error) {
+	endpoints, err := r.Registry.Lookup(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return r.filter(name, version, endpoints), nil
+}
+
+// LookupTiers returns the endpoint tiers for the given service name/version,
+// excluding the draining endpoints.
+func (r *DrainingRegistry) LookupTiers(name, version string) ([][]string, error) {
+	tiers, err := LookupTiers(r.Registry, name, version)
+	if err != nil {
+		return nil, err
+	}
+	for i, tier := range tiers {
+		tiers[i] = r.filter(name, version, tier)
+	}
+	return tiers, nil
+}
+
+// filter returns a copy of endpoints without the draining ones.
+func (r *DrainingRegistry) filter(name, version string, endpoints []string) []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	ret := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if !r.draining[endpointKey{name, version, endpoint}] {
+			ret = append(ret, endpoint)
+		}
+	}
+	return ret
+}
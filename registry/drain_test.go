@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDrainingRegistry(t *testing.T) {
+	r := NewDrainingRegistry(DefaultRegistry{})
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service1", "v1", "host2:80")
+
+	r.Drain("service1", "v1", "host1:80")
+	if !r.IsDraining("service1", "v1", "host1:80") || r.IsDraining("service1", "v1", "host2:80") {
+		t.Fatal("Unexpected draining state")
+	}
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host2:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	// Draining is scoped to the service name/version.
+	r.Add("service1", "v2", "host1:80")
+	if endpoints, err := r.Lookup("service1", "v2"); err != nil || fmt.Sprint(endpoints) != "[host1:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	r.Delete("service1", "v1", "host1:80")
+	if r.IsDraining("service1", "v1", "host1:80") {
+		t.Fatal("Deleted endpoint should not be draining")
+	}
+
+	r.Drain("service1", "v1", "host2:80")
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 0 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	r.Add("service1", "v1", "host2:80")
+	if r.IsDraining("service1", "v1", "host2:80") {
+		t.Fatal("Add should end draining")
+	}
+}
+
+func TestDrainingRegistryTiers(t *testing.T) {
+	p := NewPriorityRegistry()
+	p.AddWithPriority("service1", "v1", "primary:80", 0)
+	p.AddWithPriority("service1", "v1", "backup:80", 1)
+	r := NewDrainingRegistry(p)
+
+	r.Drain("service1", "v1", "primary:80")
+	if tiers, err := r.LookupTiers("service1", "v1"); err != nil || fmt.Sprint(tiers) != "[[] [backup:80]]" {
+		t.Fatalf("Unexpected tiers: %v, %v", tiers, err)
+	}
+}
+
+func TestDrainingRegistryReplace(t *testing.T) {
+	active := map[string]int{"host1:80": 1}
+	base := DefaultRegistry{}
+	r := NewDrainingRegistry(base)
+	r.Active = func(endpoint string) int { return active[endpoint] }
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service1", "v1", "host2:80")
+
+	r.Replace(DefaultRegistry{"service1": {"v1": {"host2:80", "host3:80"}}})
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host2:80 host3:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if !r.IsDraining("service1", "v1", "host1:80") {
+		t.Fatal("Removed endpoint should be draining")
+	}
+
+	// The removed endpoint is kept while it has active connections.
+	r.Sweep()
+	if endpoints, _ := base.Lookup("service1", "v1"); len(endpoints) != 3 {
+		t.Fatalf("Unexpected endpoints after sweep: %v", endpoints)
+	}
+	active["host1:80"] = 0
+	r.Sweep()
+	if endpoints, _ := base.Lookup("service1", "v1"); fmt.Sprint(endpoints) != "[host2:80 host3:80]" {
+		t.Fatalf("Unexpected endpoints after sweep: %v", endpoints)
+	}
+	if r.IsDraining("service1", "v1", "host1:80") {
+		t.Fatal("Swept endpoint should not be draining")
+	}
+
+	// An endpoint back in the table ends its draining.
+	active["host2:80"] = 1
+	r.Replace(DefaultRegistry{"service1": {"v1": {"host3:80"}}})
+	r.Replace(DefaultRegistry{"service1": {"v1": {"host2:80", "host3:80"}}})
+	r.Sweep()
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host2:80 host3:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+}
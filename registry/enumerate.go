@@ -0,0 +1,94 @@
+package registry
+
+import "sort"
+
+// Enumerable is implemented by registries able to list their content.
+// It is separate from Registry so custom registries don't have to implement it.
+type Enumerable interface {
+	Enumerate() map[string][]string // Return the sorted registered versions by service name
+}
+
+// Enumerate lists the registered versions by service name of reg.
+// It returns nil when reg is not Enumerable.
+func Enumerate(reg Registry) map[string][]string {
+	if e, ok := reg.(Enumerable); ok {
+		return e.Enumerate()
+	}
+	return nil
+}
+
+// Enumerate returns the sorted registered versions by service name.
+func (r DefaultRegistry) Enumerate() map[string][]string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	services := make(map[string][]string, len(r))
+	for name, versions := range r {
+		for version := range versions {
+			services[name] = append(services[name], version)
+		}
+		sort.Strings(services[name])
+	}
+	return services
+}
+
+// Enumerate returns the sorted registered versions by service name.
+func (r *PriorityRegistry) Enumerate() map[string][]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	services := make(map[string][]string, len(r.services))
+	for name, versions := range r.services {
+		for version := range versions {
+			services[name] = append(services[name], version)
+		}
+		sort.Strings(services[name])
+	}
+	return services
+}
+
+// Enumerate returns the sorted versions by service name of all the Enumerable registries.
+func (r *MultiRegistry) Enumerate() map[string][]string {
+	services := map[string][]string{}
+	for _, reg := range r.Registries {
+		for name, versions := range Enumerate(reg) {
+			services[name] = append(services[name], versions...)
+		}
+	}
+	for name, versions := range services {
+		sort.Strings(versions)
+		services[name] = dedup(versions)
+	}
+	return services
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *DrainingRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *WeightedRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *TTLRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *DelayedRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// dedup removes the consecutive duplicates of the sorted list.
+func dedup(list []string) []string {
+	ret := list[:0]
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
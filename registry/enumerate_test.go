@@ -0,0 +1,32 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEnumerate(t *testing.T) {
+	r := DefaultRegistry{}
+	r.Add("service1", "v2", "host1:80")
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service2", "v1", "host2:80")
+	if services := Enumerate(r); fmt.Sprint(services) != "map[service1:[v1 v2] service2:[v1]]" {
+		t.Fatalf("Unexpected services: %v", services)
+	}
+
+	p := NewPriorityRegistry()
+	p.Add("service1", "v3", "host3:80")
+	p.Add("service3", "v1", "host3:80")
+	if services := Enumerate(p); fmt.Sprint(services) != "map[service1:[v3] service3:[v1]]" {
+		t.Fatalf("Unexpected services: %v", services)
+	}
+
+	m := &MultiRegistry{Registries: []Registry{r, NewDrainingRegistry(p)}}
+	if services := Enumerate(m); fmt.Sprint(services) != "map[service1:[v1 v2 v3] service2:[v1] service3:[v1]]" {
+		t.Fatalf("Unexpected services: %v", services)
+	}
+
+	if services := Enumerate(NewWeightedRegistry(&MultiRegistry{})); len(services) != 0 {
+		t.Fatalf("Unexpected services: %v", services)
+	}
+}
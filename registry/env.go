@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultEnvPrefix is the usual prefix of the environment variables read by EnvRegistry.
+const DefaultEnvPrefix = "GOPROXY_"
+
+// EnvRegistry creates a DefaultRegistry from the environment variables
+// starting with the given prefix, using the following naming convention:
+//
+//	<prefix><NAME>__<VERSION>=<endpoint1>,<endpoint2>
+//
+// The name and the version are separated by a double underscore and lower cased.
+// As environment variable names can't hold dashes or dots, a single underscore
+// stands for a dash in the name and for a dot in the version:
+// `GOPROXY_MY_SERVICE__V1_2=host1:80,host2:80` registers host1:80 and host2:80
+// for the service `my-service`, version `v1.2`.
+//
+// The names with an underscore, a dot or upper case letters and the versions
+// with an underscore, a dash or upper case letters can't be written that way.
+// They are given in the value instead, as is, the rest of the variable name
+// being then free:
+//
+//	<prefix><ID>=<name>@<version>=<endpoint1>,<endpoint2>
+//
+// `GOPROXY_LEGACY=my_service@v1-rc=host1:80` registers host1:80 for the
+// service `my_service`, version `v1-rc`.
+func EnvRegistry(prefix string) (DefaultRegistry, error) {
+	return ParseEnv(prefix, os.Environ())
+}
+
+// ParseEnv is like EnvRegistry but reads the given `key=value` environment.
+func ParseEnv(prefix string, environ []string) (DefaultRegistry, error) {
+	r := NewDefaultRegistry()
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		key, value, _ := strings.Cut(kv[len(prefix):], "=")
+		var name, version string
+		if service, endpoints, ok := strings.Cut(value, "="); ok {
+			if name, version, ok = strings.Cut(service, "@"); !ok || name == "" || version == "" {
+				return nil, fmt.Errorf("invalid registry environment variable %s%s: expected <name>@<version>=<endpoints>", prefix, key)
+			}
+			value = endpoints
+		} else {
+			if name, version, ok = strings.Cut(key, "__"); !ok || name == "" || version == "" {
+				return nil, fmt.Errorf("invalid registry environment variable %s%s: expected %s<NAME>__<VERSION>", prefix, key, prefix)
+			}
+			name = strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+			version = strings.ToLower(strings.ReplaceAll(version, "_", "."))
+		}
+		for _, endpoint := range strings.Split(value, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				r.Add(name, version, endpoint)
+			}
+		}
+	}
+	return r, nil
+}
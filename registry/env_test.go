@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	r, err := ParseEnv(DefaultEnvPrefix, []string{
+		"PATH=/usr/bin",
+		"GOPROXY_SERVICE1__V1=host1:80, host2:80",
+		"GOPROXY_MY_SERVICE__V1_2=host3:80",
+		"GOPROXY_EMPTY__V1=",
+		"GOPROXY_LEGACY=my_service.old@v1-RC=host4:80",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host1:80 host2:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if endpoints, err := r.Lookup("my-service", "v1.2"); err != nil || fmt.Sprint(endpoints) != "[host3:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	// The names and versions the convention can't write are given as is in the value.
+	if endpoints, err := r.Lookup("my_service.old", "v1-RC"); err != nil || fmt.Sprint(endpoints) != "[host4:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if len(r) != 3 {
+		t.Fatalf("Unexpected registry content: %v", r)
+	}
+
+	if _, err := ParseEnv(DefaultEnvPrefix, []string{"GOPROXY_SERVICE1_V1=host1:80"}); err == nil {
+		t.Fatal("Expected an error for a variable without version")
+	}
+	if _, err := ParseEnv(DefaultEnvPrefix, []string{"GOPROXY_LEGACY=my_service=host1:80"}); err == nil {
+		t.Fatal("Expected an error for a value without version")
+	}
+}
@@ -0,0 +1,13 @@
+package registry
+
+// SingleLookuper is implemented by registries able to pick a suitable endpoint
+// on their side, e.g. a discovery backend over a huge external system
+// answering with one healthy instance, without materializing the whole
+// endpoint set. The default balancer tries that endpoint first and only
+// looks up the full list when it fails.
+//
+// The selection is the registry's own: the weights, health or tiers of the
+// wrappers would not apply to it, so the wrappers of this package hide it.
+type SingleLookuper interface {
+	LookupOne(name, version string) (string, error) // Return one endpoint for the given service name/version
+}
@@ -0,0 +1,80 @@
+package registry
+
+// MultiRegistry is a Registry combining an ordered list of registries.
+//
+// By default, Lookup returns the endpoints of the first registry knowing
+// the service name/version. When Merge is set, the endpoints of all the
+// registries knowing it are merged, without duplicates, in order.
+//
+// Add and Delete only apply to the Writable registry, when set.
+// Failure is routed to the first registry which provides the failed
+// endpoint for the service name/version.
+type MultiRegistry struct {
+	Registries []Registry
+	Writable   Registry
+	Merge      bool
+}
+
+// Lookup return the endpoint list for the given service name/version.
+func (r *MultiRegistry) Lookup(name, version string) ([]string, error) {
+	var (
+		targets []string
+		found   bool
+		seen    = map[string]bool{}
+	)
+	for _, reg := range r.Registries {
+		endpoints, err := reg.Lookup(name, version)
+		if err != nil {
+			continue
+		}
+		if !r.Merge {
+			return endpoints, nil
+		}
+		found = true
+		for _, endpoint := range endpoints {
+			if !seen[endpoint] {
+				seen[endpoint] = true
+				targets = append(targets, endpoint)
+			}
+		}
+	}
+	if !found {
+		return nil, ErrServiceNotFound
+	}
+	return targets, nil
+}
+
+// Failure marks the given endpoint for service name/version as failed
+// in the registry providing it.
+func (r *MultiRegistry) Failure(name, version, endpoint string, err error) {
+	for _, reg := range r.Registries {
+		endpoints, lookupErr := reg.Lookup(name, version)
+		if lookupErr != nil {
+			continue
+		}
+		for _, e := range endpoints {
+			if e == endpoint {
+				reg.Failure(name, version, endpoint, err)
+				return
+			}
+		}
+		if !r.Merge {
+			// Only the first registry is used for the lookup.
+			break
+		}
+	}
+}
+
+// Add adds the given endpoint for the service name/version to the Writable registry.
+func (r *MultiRegistry) Add(name, version, endpoint string) {
+	if r.Writable != nil {
+		r.Writable.Add(name, version, endpoint)
+	}
+}
+
+// Delete removes the given endpoint for the service name/version from the Writable registry.
+func (r *MultiRegistry) Delete(name, version, endpoint string) {
+	if r.Writable != nil {
+		r.Writable.Delete(name, version, endpoint)
+	}
+}
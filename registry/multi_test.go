@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// failureRecorder is a DefaultRegistry recording the failed endpoints.
+type failureRecorder struct {
+	DefaultRegistry
+	failures *[]string
+}
+
+func (r failureRecorder) Failure(name, version, endpoint string, err error) {
+	*r.failures = append(*r.failures, endpoint)
+}
+
+func TestMultiRegistry(t *testing.T) {
+	var staticFailures, dynamicFailures []string
+	static := failureRecorder{DefaultRegistry: DefaultRegistry{}, failures: &staticFailures}
+	dynamic := failureRecorder{DefaultRegistry: DefaultRegistry{}, failures: &dynamicFailures}
+	static.Add("service1", "v1", "static:80")
+	static.Add("service1", "v1", "shared:80")
+	dynamic.Add("service1", "v1", "shared:80")
+	dynamic.Add("service1", "v1", "dynamic:80")
+	dynamic.Add("service2", "v1", "dynamic:80")
+
+	r := &MultiRegistry{Registries: []Registry{static, dynamic}, Writable: dynamic}
+
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[static:80 shared:80]" {
+		t.Fatalf("Unexpected first hit lookup: %v, %v", endpoints, err)
+	}
+	if endpoints, err := r.Lookup("service2", "v1"); err != nil || fmt.Sprint(endpoints) != "[dynamic:80]" {
+		t.Fatalf("Unexpected fallback lookup: %v, %v", endpoints, err)
+	}
+	if _, err := r.Lookup("service3", "v1"); err != ErrServiceNotFound {
+		t.Fatalf("Expected %v, got %v", ErrServiceNotFound, err)
+	}
+
+	r.Merge = true
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[static:80 shared:80 dynamic:80]" {
+		t.Fatalf("Unexpected merged lookup: %v, %v", endpoints, err)
+	}
+
+	failure := errors.New("failure")
+	r.Failure("service1", "v1", "shared:80", failure)
+	r.Failure("service1", "v1", "dynamic:80", failure)
+	if fmt.Sprint(staticFailures) != "[shared:80]" || fmt.Sprint(dynamicFailures) != "[dynamic:80]" {
+		t.Fatalf("Unexpected failure routing: %v %v", staticFailures, dynamicFailures)
+	}
+
+	r.Add("service3", "v1", "new:80")
+	if _, err := static.Lookup("service3", "v1"); err != ErrServiceNotFound {
+		t.Fatal("Add should only apply to the writable registry")
+	}
+	if endpoints, err := r.Lookup("service3", "v1"); err != nil || fmt.Sprint(endpoints) != "[new:80]" {
+		t.Fatalf("Unexpected lookup after add: %v, %v", endpoints, err)
+	}
+	r.Delete("service3", "v1", "new:80")
+	if _, err := r.Lookup("service3", "v1"); err != ErrServiceNotFound {
+		t.Fatalf("Expected %v after delete, got %v", ErrServiceNotFound, err)
+	}
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrServicePaused is returned by PausingRegistry.Lookup for paused services.
+var ErrServicePaused = errors.New("service name/version paused")
+
+type serviceKey struct {
+	name, version string
+}
+
+// PausingRegistry wraps a Registry to allow pausing the traffic to a service
+// name/version, e.g. during maintenance, without removing its endpoints.
+type PausingRegistry struct {
+	Registry
+
+	lock   sync.RWMutex
+	paused map[serviceKey]bool
+}
+
+// NewPausingRegistry creates a PausingRegistry wrapping the given registry.
+func NewPausingRegistry(reg Registry) *PausingRegistry {
+	return &PausingRegistry{Registry: reg, paused: map[serviceKey]bool{}}
+}
+
+// Pause makes lookups for the given service name/version fail with ErrServicePaused.
+func (r *PausingRegistry) Pause(name, version string) {
+	r.lock.Lock()
+	r.paused[serviceKey{name, version}] = true
+	r.lock.Unlock()
+}
+
+// Resume restores the traffic to the given service name/version.
+func (r *PausingRegistry) Resume(name, version string) {
+	r.lock.Lock()
+	delete(r.paused, serviceKey{name, version})
+	r.lock.Unlock()
+}
+
+// IsPaused reports whether the given service name/version is paused.
+func (r *PausingRegistry) IsPaused(name, version string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.paused[serviceKey{name, version}]
+}
+
+// Paused reports whether the given service name/version is paused by a
+// PausingRegistry among reg and the registries it wraps, following Unwrap.
+func Paused(reg Registry, name, version string) bool {
+	for ; reg != nil; reg = Unwrap(reg) {
+		if pausing, ok := reg.(*PausingRegistry); ok && pausing.IsPaused(name, version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup return the endpoint list for the given service name/version,
+// or ErrServicePaused when paused.
+func (r *PausingRegistry) Lookup(name, version string) ([]string, error) {
+	if r.IsPaused(name, version) {
+		return nil, ErrServicePaused
+	}
+	return r.Registry.Lookup(name, version)
+}
+
+// LookupTiers returns the endpoint tiers for the given service name/version,
+// or ErrServicePaused when paused.
+func (r *PausingRegistry) LookupTiers(name, version string) ([][]string, error) {
+	if r.IsPaused(name, version) {
+		return nil, ErrServicePaused
+	}
+	return LookupTiers(r.Registry, name, version)
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *PausingRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
@@ -0,0 +1,28 @@
+package registry
+
+import "testing"
+
+func TestPausingRegistry(t *testing.T) {
+	r := NewPausingRegistry(DefaultRegistry{})
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service1", "v2", "host1:80")
+
+	r.Pause("service1", "v1")
+	if !r.IsPaused("service1", "v1") || r.IsPaused("service1", "v2") {
+		t.Fatal("Unexpected paused state")
+	}
+	if _, err := r.Lookup("service1", "v1"); err != ErrServicePaused {
+		t.Fatalf("Expected %v, got %v", ErrServicePaused, err)
+	}
+	if _, err := LookupTiers(r, "service1", "v1"); err != ErrServicePaused {
+		t.Fatalf("Expected %v, got %v", ErrServicePaused, err)
+	}
+	if endpoints, err := r.Lookup("service1", "v2"); err != nil || len(endpoints) != 1 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	r.Resume("service1", "v1")
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 1 {
+		t.Fatalf("Unexpected lookup result after resume: %v, %v", endpoints, err)
+	}
+}
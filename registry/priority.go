@@ -0,0 +1,142 @@
+package registry
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// TieredRegistry is implemented by registries grouping endpoints
+// in priority tiers. Balancers should only use a tier once all the
+// endpoints of the previous ones are unavailable.
+type TieredRegistry interface {
+	Registry
+	LookupTiers(name, version string) ([][]string, error) // Return the endpoint tiers for the given service name/version, highest precedence first
+}
+
+// LookupTiers returns the endpoint tiers of reg for the given service name/version.
+// Registries which are not tiered yield a single tier.
+func LookupTiers(reg Registry, name, version string) ([][]string, error) {
+	if tiered, ok := reg.(TieredRegistry); ok {
+		return tiered.LookupTiers(name, version)
+	}
+	endpoints, err := reg.Lookup(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{endpoints}, nil
+}
+
+type prioritizedEndpoint struct {
+	endpoint string
+	priority int
+}
+
+// PriorityRegistry is a registry where each endpoint has a priority.
+// The lower the priority, the higher the precedence: endpoints with
+// priority 1 are only used when all endpoints with priority 0 are down.
+type PriorityRegistry struct {
+	lock     sync.RWMutex
+	services map[string]map[string][]prioritizedEndpoint
+}
+
+// NewPriorityRegistry creates an empty PriorityRegistry.
+func NewPriorityRegistry() *PriorityRegistry {
+	return &PriorityRegistry{services: map[string]map[string][]prioritizedEndpoint{}}
+}
+
+// LookupTiers returns the endpoints for the given service name/version
+// grouped by priority, highest precedence first.
+func (r *PriorityRegistry) LookupTiers(name, version string) ([][]string, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	endpoints, ok := r.services[name][version]
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	var tiers [][]string
+	for i, e := range endpoints {
+		if i == 0 || e.priority != endpoints[i-1].priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], e.endpoint)
+	}
+	return tiers, nil
+}
+
+// Lookup returns all the endpoints for the given service name/version,
+// ordered by priority.
+func (r *PriorityRegistry) Lookup(name, version string) ([]string, error) {
+	tiers, err := r.LookupTiers(name, version)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, tier := range tiers {
+		targets = append(targets, tier...)
+	}
+	return targets, nil
+}
+
+// Failure marks the given endpoint for service name/version as failed.
+func (r *PriorityRegistry) Failure(name, version, endpoint string, err error) {
+	log.Printf("Error accessing %s/%s (%s): %s", name, version, endpoint, err)
+}
+
+// Add adds the given endpoint for the service name/version with priority 0.
+func (r *PriorityRegistry) Add(name, version, endpoint string) {
+	r.AddWithPriority(name, version, endpoint, 0)
+}
+
+// AddWithPriority adds the given endpoint for the service name/version with the given priority.
+// If the endpoint is already registered, its priority is updated.
+func (r *PriorityRegistry) AddWithPriority(name, version, endpoint string, priority int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	service, ok := r.services[name]
+	if !ok {
+		service = map[string][]prioritizedEndpoint{}
+		r.services[name] = service
+	}
+	endpoints := removeEndpoint(service[version], endpoint)
+	endpoints = append(endpoints, prioritizedEndpoint{endpoint: endpoint, priority: priority})
+	sort.SliceStable(endpoints, func(i, j int) bool { return endpoints[i].priority < endpoints[j].priority })
+	service[version] = endpoints
+}
+
+// Delete removes the given endpoint for the service name/version.
+// Versions left without endpoints are removed, as well as services left
+// without versions.
+func (r *PriorityRegistry) Delete(name, version, endpoint string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	service, ok := r.services[name]
+	if !ok {
+		return
+	}
+	if endpoints := removeEndpoint(service[version], endpoint); len(endpoints) != 0 {
+		service[version] = endpoints
+	} else {
+		delete(service, version)
+	}
+	if len(service) == 0 {
+		delete(r.services, name)
+	}
+}
+
+// removeEndpoint returns a copy of endpoints without the given one.
+func removeEndpoint(endpoints []prioritizedEndpoint, endpoint string) []prioritizedEndpoint {
+	ret := make([]prioritizedEndpoint, 0, len(endpoints)+1)
+	for _, e := range endpoints {
+		if e.endpoint != endpoint {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,42 @@
+package registry
+
+import "sync"
+
+// ReadyNotifier is implemented by the registries fed by a discovery backend,
+// e.g. Consul, DNS or etcd, to signal the completion of their initial sync:
+// until then, a service without endpoints may just not be synced yet.
+type ReadyNotifier interface {
+	Ready() <-chan struct{} // Return a channel closed once the initial sync is complete
+}
+
+// Ready returns the channel of the first ReadyNotifier among reg and the
+// registries it wraps, following Unwrap. It returns nil when there is none.
+func Ready(reg Registry) <-chan struct{} {
+	for ; reg != nil; reg = Unwrap(reg) {
+		if notifier, ok := reg.(ReadyNotifier); ok {
+			return notifier.Ready()
+		}
+	}
+	return nil
+}
+
+// Readiness implements ReadyNotifier, to be embedded by the discovery
+// registries, which call MarkReady once synced. The zero value is not ready.
+type Readiness struct {
+	once  sync.Once
+	ready chan struct{}
+	mark  sync.Once
+}
+
+// Ready returns a channel closed by MarkReady.
+func (r *Readiness) Ready() <-chan struct{} {
+	r.once.Do(func() { r.ready = make(chan struct{}) })
+	return r.ready
+}
+
+// MarkReady signals the completion of the initial sync.
+// Subsequent calls have no effect.
+func (r *Readiness) MarkReady() {
+	r.Ready()
+	r.mark.Do(func() { close(r.ready) })
+}
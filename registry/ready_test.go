@@ -0,0 +1,24 @@
+package registry
+
+import "testing"
+
+type syncedRegistry struct {
+	DefaultRegistry
+	Readiness
+}
+
+func TestReady(t *testing.T) {
+	if ready := Ready(DefaultRegistry{}); ready != nil {
+		t.Fatal("Unexpected ready channel without ReadyNotifier")
+	}
+	reg := &syncedRegistry{DefaultRegistry: DefaultRegistry{}}
+	ready := Ready(NewDrainingRegistry(reg))
+	select {
+	case <-ready:
+		t.Fatal("Unexpected ready registry before MarkReady")
+	default:
+	}
+	reg.MarkReady()
+	reg.MarkReady()
+	<-ready
+}
@@ -13,6 +13,7 @@ var lock sync.RWMutex
 // Common errors.
 var (
 	ErrServiceNotFound = errors.New("service name/version not found")
+	ErrNoEndpoints     = errors.New("service name/version has no endpoint")
 )
 
 // Registry is an interface used to lookup the target host
@@ -35,17 +36,56 @@ type Registry interface {
 // }
 type DefaultRegistry map[string]map[string][]string
 
+// NewDefaultRegistry creates an empty, ready to use, DefaultRegistry.
+// Lookup and Failure are safe to use on a nil DefaultRegistry, but Add is not.
+func NewDefaultRegistry() DefaultRegistry {
+	return DefaultRegistry{}
+}
+
 // Lookup return the endpoint list for the given service name/version.
+// The returned list is a copy which is not affected by later registry updates.
+// It returns ErrServiceNotFound for unknown services and ErrNoEndpoints for
+// services registered without endpoints.
 func (r DefaultRegistry) Lookup(name, version string) ([]string, error) {
 	lock.RLock()
 	targets, ok := r[name][version]
+	if ok {
+		targets = append([]string(nil), targets...)
+	}
 	lock.RUnlock()
 	if !ok {
 		return nil, ErrServiceNotFound
 	}
+	if len(targets) == 0 {
+		return nil, ErrNoEndpoints
+	}
 	return targets, nil
 }
 
+// Replacer is implemented by the registries able to atomically replace
+// their content, e.g. on configuration reload.
+type Replacer interface {
+	Replace(table DefaultRegistry) // Replace the registry content with the given table
+}
+
+// Replace atomically replaces the content of the registry with a copy of
+// table: lookups see either the previous or the new content, never a mix.
+func (r DefaultRegistry) Replace(table DefaultRegistry) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for name := range r {
+		delete(r, name)
+	}
+	for name, versions := range table {
+		service := make(map[string][]string, len(versions))
+		for version, endpoints := range versions {
+			service[version] = append([]string(nil), endpoints...)
+		}
+		r[name] = service
+	}
+}
+
 // Failure marks the given endpoint for service name/version as failed.
 func (r DefaultRegistry) Failure(name, version, endpoint string, err error) {
 	// Would be used to remove an endpoint from the rotation, log the failure, etc.
@@ -56,7 +96,10 @@ func (r DefaultRegistry) Failure(name, version, endpoint string, err error) {
 func (r DefaultRegistry) Add(name, version, endpoint string) {
 	lock.Lock()
 	defer lock.Unlock()
+	r.add(name, version, endpoint)
+}
 
+func (r DefaultRegistry) add(name, version, endpoint string) {
 	service, ok := r[name]
 	if !ok {
 		service = map[string][]string{}
@@ -66,10 +109,24 @@ func (r DefaultRegistry) Add(name, version, endpoint string) {
 }
 
 // Delete removes the given endpoit for the service name/version.
+// Versions left without endpoints are removed, as well as services left
+// without versions, so Lookup reports them as not found.
 func (r DefaultRegistry) Delete(name, version, endpoint string) {
 	lock.Lock()
 	defer lock.Unlock()
+	r.delete(name, version, endpoint)
+}
 
+// MoveEndpoint atomically moves the given endpoint of the service name from
+// fromVersion to toVersion: lookups never see it in both or neither.
+func (r DefaultRegistry) MoveEndpoint(name, fromVersion, toVersion, endpoint string) {
+	lock.Lock()
+	defer lock.Unlock()
+	r.delete(name, fromVersion, endpoint)
+	r.add(name, toVersion, endpoint)
+}
+
+func (r DefaultRegistry) delete(name, version, endpoint string) {
 	service, ok := r[name]
 	if !ok {
 		return
@@ -83,4 +140,10 @@ begin:
 			goto begin
 		}
 	}
+	if len(service[version]) == 0 {
+		delete(service, version)
+	}
+	if len(service) == 0 {
+		delete(r, name)
+	}
 }
@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeletePrunesEmptyEntries(t *testing.T) {
+	r := DefaultRegistry{}
+	r.Add("service1", "v1", "localhost:9091")
+	r.Add("service1", "v1", "localhost:9092")
+	r.Add("service1", "v2", "localhost:9093")
+
+	r.Delete("service1", "v1", "localhost:9091")
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 1 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	r.Delete("service1", "v1", "localhost:9092")
+	if _, ok := r["service1"]["v1"]; ok {
+		t.Fatal("Expected empty version to be removed")
+	}
+	if _, err := r.Lookup("service1", "v1"); err != ErrServiceNotFound {
+		t.Fatalf("Expected %v, got %v", ErrServiceNotFound, err)
+	}
+
+	r.Delete("service1", "v2", "localhost:9093")
+	if _, ok := r["service1"]; ok {
+		t.Fatal("Expected empty service to be removed")
+	}
+	if len(r) != 0 {
+		t.Fatalf("Expected empty registry, got %v", r)
+	}
+}
+
+func TestDeleteUnknownEndpoint(t *testing.T) {
+	r := DefaultRegistry{}
+	r.Add("service1", "v1", "localhost:9091")
+
+	r.Delete("service1", "v1", "localhost:9999")
+	r.Delete("service1", "v2", "localhost:9091")
+	r.Delete("service2", "v1", "localhost:9091")
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 1 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if len(r) != 1 || len(r["service1"]) != 1 {
+		t.Fatalf("Unexpected registry content: %v", r)
+	}
+}
+
+func TestPriorityRegistryTiers(t *testing.T) {
+	r := NewPriorityRegistry()
+	r.AddWithPriority("service1", "v1", "backup:80", 1)
+	r.Add("service1", "v1", "primary1:80")
+	r.AddWithPriority("service1", "v1", "primary2:80", 0)
+	r.AddWithPriority("service1", "v1", "last:80", 5)
+
+	tiers, err := r.LookupTiers("service1", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := "[[primary1:80 primary2:80] [backup:80] [last:80]]"; fmt.Sprint(tiers) != expect {
+		t.Fatalf("Unexpected tiers: %v, expected %s", tiers, expect)
+	}
+	if endpoints, _ := r.Lookup("service1", "v1"); len(endpoints) != 4 || endpoints[3] != "last:80" {
+		t.Fatalf("Unexpected endpoints: %v", endpoints)
+	}
+
+	// Re-adding an endpoint updates its priority.
+	r.AddWithPriority("service1", "v1", "last:80", 0)
+	if tiers, _ := r.LookupTiers("service1", "v1"); len(tiers) != 2 || len(tiers[0]) != 3 {
+		t.Fatalf("Unexpected tiers: %v", tiers)
+	}
+
+	for _, e := range []string{"primary1:80", "primary2:80", "last:80", "backup:80"} {
+		r.Delete("service1", "v1", e)
+	}
+	if _, err := r.LookupTiers("service1", "v1"); err != ErrServiceNotFound {
+		t.Fatalf("Expected %v, got %v", ErrServiceNotFound, err)
+	}
+}
+
+func TestNilDefaultRegistry(t *testing.T) {
+	var r DefaultRegistry
+	if _, err := r.Lookup("service1", "v1"); err != ErrServiceNotFound {
+		t.Fatalf("Expected %v, got %v", ErrServiceNotFound, err)
+	}
+	r.Failure("service1", "v1", "localhost:9091", ErrServiceNotFound)
+	r.Delete("service1", "v1", "localhost:9091")
+
+	r = NewDefaultRegistry()
+	r.Add("service1", "v1", "localhost:9091")
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 1 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+}
+
+func TestMoveEndpoint(t *testing.T) {
+	r := DefaultRegistry{}
+	r.Add("service1", "v1", "host1:80")
+	r.Add("service1", "v1", "host2:80")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			r.MoveEndpoint("service1", "v1", "v2", "host1:80")
+			r.MoveEndpoint("service1", "v2", "v1", "host1:80")
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 2 {
+				t.Fatalf("Unexpected endpoints after the moves: %v, %v", endpoints, err)
+			}
+			return
+		default:
+		}
+		// Read both versions at once, as an atomic observer.
+		lock.RLock()
+		n := 0
+		for _, version := range []string{"v1", "v2"} {
+			for _, endpoint := range r["service1"][version] {
+				if endpoint == "host1:80" {
+					n++
+				}
+			}
+		}
+		lock.RUnlock()
+		if n != 1 {
+			t.Fatalf("Endpoint found in %d versions", n)
+		}
+	}
+}
+
+func TestLookupNoEndpoints(t *testing.T) {
+	r := DefaultRegistry{"service1": {"v1": {}}}
+	if _, err := r.Lookup("service1", "v1"); err != ErrNoEndpoints {
+		t.Fatalf("Unexpected error for a service without endpoints: %v", err)
+	}
+	if _, err := r.Lookup("service1", "v2"); err != ErrServiceNotFound {
+		t.Fatalf("Unexpected error for an unknown service: %v", err)
+	}
+}
+
+func TestDefaultRegistryReplace(t *testing.T) {
+	r := DefaultRegistry{}
+	r.Add("service1", "v1", "host1:80")
+	table := DefaultRegistry{"service2": {"v1": {"host2:80"}}}
+	r.Replace(table)
+	if _, err := r.Lookup("service1", "v1"); err != ErrServiceNotFound {
+		t.Fatalf("Unexpected error for the replaced service: %v", err)
+	}
+	// The registry holds a copy of the table.
+	table["service2"]["v1"][0] = "host3:80"
+	if endpoints, err := r.Lookup("service2", "v1"); err != nil || len(endpoints) != 1 || endpoints[0] != "host2:80" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+}
@@ -0,0 +1,147 @@
+// Package registrytest provides a mock registry.Registry recording its calls,
+// for the tests of custom middlewares and balancers.
+package registrytest
+
+import (
+	"sync"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// Call is a recorded call of a MockRegistry method.
+type Call struct {
+	Method                  string // "Add", "Delete", "Failure" or "Lookup".
+	Name, Version, Endpoint string
+	Err                     error // Error reported to Failure.
+}
+
+type lookupResult struct {
+	endpoints []string
+	err       error
+}
+
+// MockRegistry is a registry.Registry recording its calls.
+// Lookup returns the endpoints added with Add and removed with Delete,
+// unless programmed otherwise with SetLookup or LookupFunc.
+// It is safe for concurrent use. The zero value is ready to use.
+type MockRegistry struct {
+	// LookupFunc, when set, returns the results of Lookup.
+	LookupFunc func(name, version string) ([]string, error)
+
+	lock    sync.Mutex
+	calls   []Call
+	results map[string]lookupResult
+}
+
+var _ registry.Registry = (*MockRegistry)(nil)
+
+// New creates an empty MockRegistry.
+func New() *MockRegistry {
+	return &MockRegistry{results: map[string]lookupResult{}}
+}
+
+// SetLookup programs the result of Lookup for the given service name/version.
+func (m *MockRegistry) SetLookup(name, version string, endpoints []string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.results == nil {
+		m.results = map[string]lookupResult{}
+	}
+	m.results[name+"/"+version] = lookupResult{endpoints: endpoints, err: err}
+}
+
+// Add records the call and adds the endpoint to the Lookup result.
+func (m *MockRegistry) Add(name, version, endpoint string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls = append(m.calls, Call{Method: "Add", Name: name, Version: version, Endpoint: endpoint})
+	if m.results == nil {
+		m.results = map[string]lookupResult{}
+	}
+	result := m.results[name+"/"+version]
+	result.endpoints = append(result.endpoints, endpoint)
+	m.results[name+"/"+version] = result
+}
+
+// Delete records the call and removes the endpoint from the Lookup result.
+func (m *MockRegistry) Delete(name, version, endpoint string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls = append(m.calls, Call{Method: "Delete", Name: name, Version: version, Endpoint: endpoint})
+	result, ok := m.results[name+"/"+version]
+	if !ok {
+		return
+	}
+	endpoints := make([]string, 0, len(result.endpoints))
+	for _, e := range result.endpoints {
+		if e != endpoint {
+			endpoints = append(endpoints, e)
+		}
+	}
+	if len(endpoints) == 0 && result.err == nil {
+		delete(m.results, name+"/"+version)
+		return
+	}
+	result.endpoints = endpoints
+	m.results[name+"/"+version] = result
+}
+
+// Failure records the call.
+func (m *MockRegistry) Failure(name, version, endpoint string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.calls = append(m.calls, Call{Method: "Failure", Name: name, Version: version, Endpoint: endpoint, Err: err})
+}
+
+// Lookup records the call and returns the result of LookupFunc when set,
+// the programmed result otherwise. Unknown services get registry.ErrServiceNotFound.
+// The returned list is a copy.
+func (m *MockRegistry) Lookup(name, version string) ([]string, error) {
+	m.lock.Lock()
+	m.calls = append(m.calls, Call{Method: "Lookup", Name: name, Version: version})
+	result, ok := m.results[name+"/"+version]
+	lookup := m.LookupFunc
+	m.lock.Unlock()
+
+	if lookup != nil {
+		return lookup(name, version)
+	}
+	if !ok {
+		return nil, registry.ErrServiceNotFound
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	return append([]string(nil), result.endpoints...), nil
+}
+
+// Calls returns the recorded calls, in order. When methods are given,
+// only the calls to these methods are returned.
+func (m *MockRegistry) Calls(methods ...string) []Call {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var calls []Call
+	for _, call := range m.calls {
+		if len(methods) == 0 || contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the recorded calls.
+func (m *MockRegistry) Reset() {
+	m.lock.Lock()
+	m.calls = nil
+	m.lock.Unlock()
+}
+
+func contains(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package registrytest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestMockRegistry(t *testing.T) {
+	m := New()
+	if _, err := m.Lookup("service1", "v1"); err != registry.ErrServiceNotFound {
+		t.Fatalf("Unexpected error for an unknown service: %v", err)
+	}
+	m.Add("service1", "v1", "host1:80")
+	m.Add("service1", "v1", "host2:80")
+	m.Delete("service1", "v1", "host1:80")
+	if endpoints, err := m.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host2:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	boom := errors.New("boom")
+	m.SetLookup("service2", "v1", nil, registry.ErrServicePaused)
+	if _, err := m.Lookup("service2", "v1"); err != registry.ErrServicePaused {
+		t.Fatalf("Unexpected programmed error: %v", err)
+	}
+	m.Failure("service1", "v1", "host2:80", boom)
+
+	failures := m.Calls("Failure")
+	if len(failures) != 1 || failures[0] != (Call{Method: "Failure", Name: "service1", Version: "v1", Endpoint: "host2:80", Err: boom}) {
+		t.Fatalf("Unexpected failure calls: %v", failures)
+	}
+	if calls := m.Calls(); len(calls) != 7 {
+		t.Fatalf("Unexpected calls: %v", calls)
+	}
+	m.Reset()
+	if calls := m.Calls(); len(calls) != 0 {
+		t.Fatalf("Unexpected calls after reset: %v", calls)
+	}
+
+	m.LookupFunc = func(name, version string) ([]string, error) { return []string{name + ":80"}, nil }
+	if endpoints, err := m.Lookup("service3", "v1"); err != nil || fmt.Sprint(endpoints) != "[service3:80]" {
+		t.Fatalf("Unexpected LookupFunc result: %v, %v", endpoints, err)
+	}
+}
+
+func TestMockRegistryZeroValue(t *testing.T) {
+	var m MockRegistry
+	m.Add("service1", "v1", "host1:80")
+	m.SetLookup("service2", "v1", []string{"host2:80"}, nil)
+	if endpoints, err := m.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host1:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	// LookupFunc takes precedence over the programmed results.
+	m2 := &MockRegistry{LookupFunc: func(name, version string) ([]string, error) { return []string{"func:80"}, nil }}
+	m2.SetLookup("service1", "v1", nil, nil)
+	m2.Add("service1", "v1", "host1:80")
+	if endpoints, err := m2.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[func:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if calls := m2.Calls("Add"); len(calls) != 1 || calls[0] != (Call{Method: "Add", Name: "service1", Version: "v1", Endpoint: "host1:80"}) {
+		t.Fatalf("Unexpected Add calls: %v", calls)
+	}
+}
@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StaleRegistry wraps a Registry fed by a discovery backend, e.g. Consul or
+// etcd, to fail open when the backend is unavailable: it caches the last
+// successful lookup of each service name/version and serves it when a
+// lookup fails, so that the traffic keeps flowing to the last known
+// endpoints instead of failing.
+//
+// ErrServiceNotFound and ErrNoEndpoints are answers of the backend, not
+// failures: they are returned as is and clear the cache of the service.
+// The lookups go through the LookupTiers of the wrapped registry, so that
+// the cached endpoints keep their tiers.
+type StaleRegistry struct {
+	Registry
+
+	// MaxStale bounds the age of the cached endpoints served on error.
+	// When zero, they are served regardless of their age.
+	MaxStale time.Duration
+
+	// Disabled turns the fail-open mode off: the lookup errors are returned.
+	// It can be toggled at any time.
+	Disabled atomic.Bool
+
+	lock  sync.Mutex
+	cache map[serviceKey]*staleEntry
+}
+
+type staleEntry struct {
+	tiers [][]string
+	at    time.Time
+	stale bool // Set while serving the entry on error, to log the transitions.
+}
+
+// NewStaleRegistry creates a StaleRegistry wrapping the given registry,
+// serving the cached endpoints up to maxStale on error.
+func NewStaleRegistry(reg Registry, maxStale time.Duration) *StaleRegistry {
+	return &StaleRegistry{Registry: reg, MaxStale: maxStale}
+}
+
+// Lookup return the endpoint list for the given service name/version, or the
+// last known one when the wrapped registry fails.
+func (r *StaleRegistry) Lookup(name, version string) ([]string, error) {
+	tiers, err := r.LookupTiers(name, version)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, tier := range tiers {
+		ret = append(ret, tier...)
+	}
+	return ret, nil
+}
+
+// LookupTiers returns the endpoint tiers for the given service name/version,
+// or the last known ones when the wrapped registry fails.
+func (r *StaleRegistry) LookupTiers(name, version string) ([][]string, error) {
+	tiers, err := LookupTiers(r.Registry, name, version)
+	if err == nil {
+		r.store(name, version, tiers)
+		return tiers, nil
+	}
+	if tiers, ok := r.stale(name, version, err); ok {
+		return tiers, nil
+	}
+	return nil, err
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *StaleRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// Unwrap returns the wrapped registry.
+func (r *StaleRegistry) Unwrap() Registry { return r.Registry }
+
+// store caches a copy of the tiers of the given service name/version.
+func (r *StaleRegistry) store(name, version string, tiers [][]string) {
+	cp := make([][]string, len(tiers))
+	for i, tier := range tiers {
+		cp[i] = append([]string(nil), tier...)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := serviceKey{name, version}
+	if entry := r.cache[key]; entry != nil && entry.stale {
+		log.Printf("Registry recovered for %s/%s", name, version)
+	}
+	if r.cache == nil {
+		r.cache = map[serviceKey]*staleEntry{}
+	}
+	r.cache[key] = &staleEntry{tiers: cp, at: time.Now()}
+}
+
+// stale returns a copy of the cached tiers of the given service name/version
+// to serve on the given lookup error, if any.
+func (r *StaleRegistry) stale(name, version string, err error) ([][]string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := serviceKey{name, version}
+	if errors.Is(err, ErrServiceNotFound) || errors.Is(err, ErrNoEndpoints) {
+		delete(r.cache, key)
+		return nil, false
+	}
+	entry := r.cache[key]
+	if r.Disabled.Load() || entry == nil {
+		return nil, false
+	}
+	if age := time.Since(entry.at); r.MaxStale > 0 && age > r.MaxStale {
+		if entry.stale {
+			log.Printf("Stale endpoints for %s/%s expired after %s: %s", name, version, age.Round(time.Millisecond), err)
+		}
+		delete(r.cache, key)
+		return nil, false
+	}
+	if !entry.stale {
+		entry.stale = true
+		log.Printf("Serving stale endpoints for %s/%s: %s", name, version, err)
+	}
+	tiers := make([][]string, len(entry.tiers))
+	for i, tier := range entry.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+	return tiers, true
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// unavailableRegistry fails the lookups while down is set.
+type unavailableRegistry struct {
+	DefaultRegistry
+	down bool
+}
+
+func (r *unavailableRegistry) Lookup(name, version string) ([]string, error) {
+	if r.down {
+		return nil, errors.New("backend unavailable")
+	}
+	return r.DefaultRegistry.Lookup(name, version)
+}
+
+func TestStaleRegistry(t *testing.T) {
+	backend := &unavailableRegistry{DefaultRegistry: DefaultRegistry{}}
+	backend.Add("service1", "v1", "host1:80")
+	r := &StaleRegistry{Registry: backend, MaxStale: 50 * time.Millisecond}
+
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host1:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	// The last known endpoints are served while the backend is down,
+	// up to MaxStale.
+	backend.down = true
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[host1:80]" {
+		t.Fatalf("Unexpected stale lookup result: %v, %v", endpoints, err)
+	}
+	if tiers, err := r.LookupTiers("service1", "v1"); err != nil || fmt.Sprint(tiers) != "[[host1:80]]" {
+		t.Fatalf("Unexpected stale tiers: %v, %v", tiers, err)
+	}
+	if _, err := r.Lookup("service2", "v1"); err == nil {
+		t.Fatal("Expected an error for a service never looked up")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := r.Lookup("service1", "v1"); err == nil {
+		t.Fatal("Expected an error past MaxStale")
+	}
+
+	// The answers of the backend are not failures.
+	backend.down = false
+	r.Lookup("service1", "v1")
+	backend.Delete("service1", "v1", "host1:80")
+	if _, err := r.Lookup("service1", "v1"); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	backend.down = true
+	if _, err := r.Lookup("service1", "v1"); err == nil {
+		t.Fatal("Expected the cache to be cleared by ErrServiceNotFound")
+	}
+
+	// Disabled returns the errors.
+	backend.down = false
+	backend.Add("service1", "v1", "host1:80")
+	r.Lookup("service1", "v1")
+	r.Disabled.Store(true)
+	backend.down = true
+	if _, err := r.Lookup("service1", "v1"); err == nil {
+		t.Fatal("Expected an error when disabled")
+	}
+}
+
+func TestStaleRegistryTiers(t *testing.T) {
+	backend := &unavailablePriorityRegistry{PriorityRegistry: NewPriorityRegistry()}
+	backend.AddWithPriority("service1", "v1", "host1:80", 0)
+	backend.AddWithPriority("service1", "v1", "host2:80", 1)
+	r := NewStaleRegistry(backend, 0)
+
+	// The tiers cached by Lookup are kept.
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 2 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	backend.down = true
+	if tiers, err := r.LookupTiers("service1", "v1"); err != nil || fmt.Sprint(tiers) != "[[host1:80] [host2:80]]" {
+		t.Fatalf("Unexpected stale tiers: %v, %v", tiers, err)
+	}
+}
+
+// unavailablePriorityRegistry fails the lookups while down is set.
+type unavailablePriorityRegistry struct {
+	*PriorityRegistry
+	down bool
+}
+
+func (r *unavailablePriorityRegistry) LookupTiers(name, version string) ([][]string, error) {
+	if r.down {
+		return nil, errors.New("backend unavailable")
+	}
+	return r.PriorityRegistry.LookupTiers(name, version)
+}
@@ -0,0 +1,123 @@
+package registry
+
+import "sync"
+
+// Tagger is implemented by the registries holding metadata about their
+// endpoints, e.g. their region, zone or canary status.
+type Tagger interface {
+	Tags(name, version, endpoint string) map[string]string // Return the tags of the given endpoint, nil when untagged.
+}
+
+// Tags returns the tags of the given endpoint from the first Tagger among reg
+// and the registries it wraps, following Unwrap. It returns nil when there is none.
+func Tags(reg Registry, name, version, endpoint string) map[string]string {
+	for ; reg != nil; reg = Unwrap(reg) {
+		if tagger, ok := reg.(Tagger); ok {
+			return tagger.Tags(name, version, endpoint)
+		}
+	}
+	return nil
+}
+
+// TaggedRegistry wraps a Registry to attach tags to its endpoints.
+type TaggedRegistry struct {
+	Registry
+
+	lock sync.RWMutex
+	tags map[endpointKey]map[string]string
+}
+
+// NewTaggedRegistry creates a TaggedRegistry wrapping the given registry.
+func NewTaggedRegistry(reg Registry) *TaggedRegistry {
+	return &TaggedRegistry{Registry: reg, tags: map[endpointKey]map[string]string{}}
+}
+
+// AddWithTags adds the given endpoint for the service name/version with the given tags.
+func (r *TaggedRegistry) AddWithTags(name, version, endpoint string, tags map[string]string) {
+	r.SetTags(name, version, endpoint, tags)
+	r.Registry.Add(name, version, endpoint)
+}
+
+// SetTags replaces the tags of the given endpoint for the service name/version
+// with a copy of tags.
+func (r *TaggedRegistry) SetTags(name, version, endpoint string, tags map[string]string) {
+	cp := make(map[string]string, len(tags))
+	for k, v := range tags {
+		cp[k] = v
+	}
+	r.lock.Lock()
+	r.tags[endpointKey{name, version, endpoint}] = cp
+	r.lock.Unlock()
+}
+
+// Tags returns the tags of the given endpoint for the service name/version,
+// nil when untagged. The returned map must not be modified.
+func (r *TaggedRegistry) Tags(name, version, endpoint string) map[string]string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.tags[endpointKey{name, version, endpoint}]
+}
+
+// Delete removes the given endpoint for the service name/version and forgets its tags.
+func (r *TaggedRegistry) Delete(name, version, endpoint string) {
+	r.Registry.Delete(name, version, endpoint)
+	r.lock.Lock()
+	delete(r.tags, endpointKey{name, version, endpoint})
+	r.lock.Unlock()
+}
+
+// Replace replaces the content of the wrapped registry when it is a Replacer,
+// and forgets the tags of the endpoints it no longer has. It is a no-op otherwise.
+func (r *TaggedRegistry) Replace(table DefaultRegistry) {
+	replacer, ok := r.Registry.(Replacer)
+	if !ok {
+		return
+	}
+	replacer.Replace(table)
+	r.Prune()
+}
+
+// Prune forgets the tags of the endpoints the wrapped registry no longer has,
+// e.g. after removing them without going through Delete. The endpoints are
+// listed from the innermost registry, following Unwrap: the ones filtered
+// out by a wrapper, e.g. paused, draining or expired, keep their tags.
+func (r *TaggedRegistry) Prune() {
+	base := r.Registry
+	for inner := Unwrap(base); inner != nil; inner = Unwrap(inner) {
+		base = inner
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	registered := map[serviceKey]map[string]bool{}
+	for key := range r.tags {
+		service := serviceKey{key.name, key.version}
+		endpoints, ok := registered[service]
+		if !ok {
+			endpoints = map[string]bool{}
+			tiers, _ := LookupTiers(base, key.name, key.version)
+			for _, tier := range tiers {
+				for _, endpoint := range tier {
+					endpoints[endpoint] = true
+				}
+			}
+			registered[service] = endpoints
+		}
+		if !endpoints[key.endpoint] {
+			delete(r.tags, key)
+		}
+	}
+}
+
+// LookupTiers returns the endpoint tiers of the wrapped registry.
+func (r *TaggedRegistry) LookupTiers(name, version string) ([][]string, error) {
+	return LookupTiers(r.Registry, name, version)
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *TaggedRegistry) Enumerate() map[string][]string {
+	return Enumerate(r.Registry)
+}
+
+// Unwrap returns the wrapped registry.
+func (r *TaggedRegistry) Unwrap() Registry { return r.Registry }
@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTaggedRegistry(t *testing.T) {
+	r := NewTaggedRegistry(DefaultRegistry{})
+	r.Add("service1", "v1", "host1:80")
+	tags := map[string]string{"zone": "a"}
+	r.AddWithTags("service1", "v1", "host2:80", tags)
+	tags["zone"] = "b"
+
+	if tags := r.Tags("service1", "v1", "host1:80"); tags != nil {
+		t.Fatalf("Unexpected tags for an untagged endpoint: %v", tags)
+	}
+	// The tags are found through the wrapping registries.
+	if tags := Tags(NewPausingRegistry(r), "service1", "v1", "host2:80"); fmt.Sprint(tags) != "map[zone:a]" {
+		t.Fatalf("Unexpected tags: %v", tags)
+	}
+	if tags := Tags(DefaultRegistry{}, "service1", "v1", "host2:80"); tags != nil {
+		t.Fatalf("Unexpected tags without Tagger: %v", tags)
+	}
+
+	r.Delete("service1", "v1", "host2:80")
+	if tags := r.Tags("service1", "v1", "host2:80"); tags != nil {
+		t.Fatalf("Expected tags to be forgotten, got %v", tags)
+	}
+
+	// Replace forgets the tags of the removed endpoints.
+	r.AddWithTags("service1", "v1", "host2:80", map[string]string{"zone": "a"})
+	r.AddWithTags("service1", "v1", "host1:80", map[string]string{"zone": "b"})
+	r.Replace(DefaultRegistry{"service1": {"v1": {"host1:80"}}})
+	r.Add("service1", "v1", "host2:80")
+	if tags := r.Tags("service1", "v1", "host2:80"); tags != nil {
+		t.Fatalf("Expected tags to be forgotten on replace, got %v", tags)
+	}
+	if tags := r.Tags("service1", "v1", "host1:80"); tags["zone"] != "b" {
+		t.Fatalf("Unexpected tags of a kept endpoint: %v", tags)
+	}
+}
+
+func TestTaggedRegistryPruneFiltered(t *testing.T) {
+	draining := NewDrainingRegistry(DefaultRegistry{})
+	pausing := NewPausingRegistry(draining)
+	r := NewTaggedRegistry(pausing)
+	r.AddWithTags("service1", "v1", "host1:80", map[string]string{"zone": "a"})
+	r.AddWithTags("service1", "v1", "host2:80", map[string]string{"zone": "b"})
+	r.AddWithTags("service2", "v1", "host3:80", map[string]string{"zone": "c"})
+
+	// The endpoints filtered out by the wrappers are still registered.
+	draining.Drain("service1", "v1", "host1:80")
+	pausing.Pause("service2", "v1")
+	r.Prune()
+	for _, endpoint := range []endpointKey{{"service1", "v1", "host1:80"}, {"service1", "v1", "host2:80"}, {"service2", "v1", "host3:80"}} {
+		if tags := r.Tags(endpoint.name, endpoint.version, endpoint.endpoint); tags == nil {
+			t.Fatalf("Unexpected pruned tags of %v", endpoint)
+		}
+	}
+}
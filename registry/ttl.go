@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// TTLRegistry wraps a Registry to expire the endpoints which are not
+// refreshed in time, e.g. for agents re-registering periodically.
+// Expired endpoints are excluded from lookups right away and removed
+// from the wrapped registry by Sweep.
+type TTLRegistry struct {
+	Registry
+
+	lock    sync.Mutex
+	expires map[endpointKey]ttlEntry
+}
+
+type ttlEntry struct {
+	ttl      time.Duration
+	deadline time.Time
+}
+
+// NewTTLRegistry creates a TTLRegistry wrapping the given registry.
+func NewTTLRegistry(reg Registry) *TTLRegistry {
+	return &TTLRegistry{Registry: reg, expires: map[endpointKey]ttlEntry{}}
+}
+
+// AddWithTTL adds the given endpoint for the service name/version,
+// expiring after ttl unless refreshed by a new Add or AddWithTTL.
+func (r *TTLRegistry) AddWithTTL(name, version, endpoint string, ttl time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := endpointKey{name, version, endpoint}
+	if _, ok := r.expires[key]; !ok && !r.contains(name, version, endpoint) {
+		r.Registry.Add(name, version, endpoint)
+	}
+	r.expires[key] = ttlEntry{ttl: ttl, deadline: time.Now().Add(ttl)}
+}
+
+// Add adds the given endpoint for the service name/version.
+// When the endpoint was added with a TTL, its deadline is refreshed instead.
+func (r *TTLRegistry) Add(name, version, endpoint string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	key := endpointKey{name, version, endpoint}
+	if entry, ok := r.expires[key]; ok {
+		entry.deadline = time.Now().Add(entry.ttl)
+		r.expires[key] = entry
+		return
+	}
+	r.Registry.Add(name, version, endpoint)
+}
+
+// Delete removes the given endpoint for the service name/version.
+func (r *TTLRegistry) Delete(name, version, endpoint string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.expires, endpointKey{name, version, endpoint})
+	r.Registry.Delete(name, version, endpoint)
+}
+
+// Lookup return the endpoint list for the given service name/version,
+// excluding the expired endpoints.
+func (r *TTLRegistry) Lookup(name, version string) ([]string, error) {
+	endpoints, err := r.Registry.Lookup(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return r.filter(name, version, endpoints), nil
+}
+
+// LookupTiers returns the endpoint tiers for the given service name/version,
+// excluding the expired endpoints.
+func (r *TTLRegistry) LookupTiers(name, version string) ([][]string, error) {
+	tiers, err := LookupTiers(r.Registry, name, version)
+	if err != nil {
+		return nil, err
+	}
+	for i, tier := range tiers {
+		tiers[i] = r.filter(name, version, tier)
+	}
+	return tiers, nil
+}
+
+// Sweep removes the expired endpoints from the wrapped registry.
+func (r *TTLRegistry) Sweep() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for key, entry := range r.expires {
+		if now.After(entry.deadline) {
+			delete(r.expires, key)
+			r.Registry.Delete(key.name, key.version, key.endpoint)
+		}
+	}
+}
+
+// StartSweeper calls Sweep every interval in a new goroutine.
+// The returned function stops it and waits for its termination.
+func (r *TTLRegistry) StartSweeper(interval time.Duration) (stop func()) {
+	return startSweeper(interval, r.Sweep)
+}
+
+// startSweeper calls sweep every interval in a new goroutine.
+// The returned function stops it and waits for its termination.
+func startSweeper(interval time.Duration, sweep func()) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
+
+// contains reports whether the wrapped registry has the given endpoint.
+func (r *TTLRegistry) contains(name, version, endpoint string) bool {
+	endpoints, _ := r.Registry.Lookup(name, version)
+	for _, e := range endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns a copy of endpoints without the expired ones.
+func (r *TTLRegistry) filter(name, version string, endpoints []string) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	ret := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if entry, ok := r.expires[endpointKey{name, version, endpoint}]; !ok || !now.After(entry.deadline) {
+			ret = append(ret, endpoint)
+		}
+	}
+	return ret
+}
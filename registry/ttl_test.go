@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTTLRegistry(t *testing.T) {
+	reg := DefaultRegistry{}
+	r := NewTTLRegistry(reg)
+	r.Add("service1", "v1", "static:80")
+	r.AddWithTTL("service1", "v1", "agent1:80", 100*time.Millisecond)
+	r.AddWithTTL("service1", "v1", "agent2:80", 100*time.Millisecond)
+	r.AddWithTTL("service1", "v1", "agent2:80", 100*time.Millisecond)
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[static:80 agent1:80 agent2:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	r.Add("service1", "v1", "agent2:80")
+	time.Sleep(60 * time.Millisecond)
+
+	// Expired endpoints are excluded before being swept.
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || fmt.Sprint(endpoints) != "[static:80 agent2:80]" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if endpoints, _ := reg.Lookup("service1", "v1"); len(endpoints) != 3 {
+		t.Fatalf("Unexpected wrapped registry content before sweep: %v", endpoints)
+	}
+	r.Sweep()
+	if endpoints, _ := reg.Lookup("service1", "v1"); fmt.Sprint(endpoints) != "[static:80 agent2:80]" {
+		t.Fatalf("Unexpected wrapped registry content after sweep: %v", endpoints)
+	}
+}
+
+func TestTTLRegistrySweeper(t *testing.T) {
+	reg := DefaultRegistry{}
+	r := NewTTLRegistry(reg)
+	r.AddWithTTL("service1", "v1", "agent1:80", time.Millisecond)
+
+	stop := r.StartSweeper(time.Millisecond)
+	defer stop()
+	for i := 0; ; i++ {
+		if _, err := reg.Lookup("service1", "v1"); err == ErrServiceNotFound {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("The expired endpoint was not swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+}
@@ -0,0 +1,30 @@
+package registry
+
+// Wrapper is implemented by the registries wrapping another one, e.g. to
+// filter its endpoints, so that their states can be inspected layer by layer.
+type Wrapper interface {
+	Unwrap() Registry // Return the wrapped registry
+}
+
+// Unwrap returns the registry wrapped by reg, nil when reg is not a Wrapper.
+func Unwrap(reg Registry) Registry {
+	if w, ok := reg.(Wrapper); ok {
+		return w.Unwrap()
+	}
+	return nil
+}
+
+// Unwrap returns the wrapped registry.
+func (r *DrainingRegistry) Unwrap() Registry { return r.Registry }
+
+// Unwrap returns the wrapped registry.
+func (r *WeightedRegistry) Unwrap() Registry { return r.Registry }
+
+// Unwrap returns the wrapped registry.
+func (r *TTLRegistry) Unwrap() Registry { return r.Registry }
+
+// Unwrap returns the wrapped registry.
+func (r *DelayedRegistry) Unwrap() Registry { return r.Registry }
+
+// Unwrap returns the wrapped registry.
+func (r *PausingRegistry) Unwrap() Registry { return r.Registry }
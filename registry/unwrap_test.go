@@ -0,0 +1,15 @@
+package registry
+
+import "testing"
+
+func TestUnwrap(t *testing.T) {
+	base := DefaultRegistry{}
+	weighted := NewWeightedRegistry(base)
+	draining := NewDrainingRegistry(weighted)
+	if Unwrap(draining) != weighted || Unwrap(weighted) == nil {
+		t.Fatal("Unexpected wrapped registry")
+	}
+	if reg := Unwrap(base); reg != nil {
+		t.Fatalf("Unexpected wrapped registry: %v", reg)
+	}
+}
@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"strconv"
+	"strings"
+)
+
+// VersionMatcher selects, among the registered versions, the one
+// which best satisfies the requested version.
+type VersionMatcher func(requested string, registered []string) (version string, ok bool)
+
+// ExactVersion only matches the registered version equal to the requested one.
+func ExactVersion(requested string, registered []string) (string, bool) {
+	for _, v := range registered {
+		if v == requested {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// PrefixVersion matches the most specific registered version which is a dot
+// separated prefix of the requested one: `v1.2.3` resolves to `v1.2.3`, `v1.2`
+// or `v1`, whichever is registered first in that order.
+func PrefixVersion(requested string, registered []string) (string, bool) {
+	best, ok := "", false
+	for _, v := range registered {
+		if v != requested && !strings.HasPrefix(requested, v+".") {
+			continue
+		}
+		if !ok || len(v) > len(best) {
+			best, ok = v, true
+		}
+	}
+	return best, ok
+}
+
+// WildcardVersion treats the `*` and `x` components of the requested version
+// as wildcards and resolves to the highest matching registered version:
+// with `v1.2` and `v1.10` registered, `v1.*` resolves to `v1.10`.
+// A trailing wildcard matches any number of components.
+func WildcardVersion(requested string, registered []string) (string, bool) {
+	pattern := strings.Split(requested, ".")
+	best, ok := "", false
+	for _, v := range registered {
+		if !matchWildcard(pattern, strings.Split(v, ".")) {
+			continue
+		}
+		if !ok || compareVersions(v, best) > 0 {
+			best, ok = v, true
+		}
+	}
+	return best, ok
+}
+
+func isWildcard(component string) bool {
+	return component == "*" || component == "x"
+}
+
+func matchWildcard(pattern, version []string) bool {
+	for i, p := range pattern {
+		if isWildcard(p) && i == len(pattern)-1 {
+			return len(version) >= i
+		}
+		if i >= len(version) || (!isWildcard(p) && p != version[i]) {
+			return false
+		}
+	}
+	return len(version) == len(pattern)
+}
+
+// compareVersions compares dot separated versions component by component,
+// numerically when both components are numbers (ignoring a `v` prefix).
+// Numeric components rank above the other ones.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(strings.TrimPrefix(a, "v"), "."), strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil && bErr != nil:
+			return 1
+		case aErr != nil && bErr == nil:
+			return -1
+		case aErr != nil && bErr != nil && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// MatchingRegistry is a DefaultRegistry resolving the version requested
+// to Lookup among the registered ones using Match.
+// When Match is nil, only exact matches are allowed.
+type MatchingRegistry struct {
+	DefaultRegistry
+	Match VersionMatcher
+}
+
+// Lookup return the endpoint list for the registered version
+// which best matches the requested one.
+func (r MatchingRegistry) Lookup(name, version string) ([]string, error) {
+	lock.RLock()
+	versions := make([]string, 0, len(r.DefaultRegistry[name]))
+	for v := range r.DefaultRegistry[name] {
+		versions = append(versions, v)
+	}
+	lock.RUnlock()
+
+	match := r.Match
+	if match == nil {
+		match = ExactVersion
+	}
+	resolved, ok := match(version, versions)
+	if !ok {
+		return nil, ErrServiceNotFound
+	}
+	return r.DefaultRegistry.Lookup(name, resolved)
+}
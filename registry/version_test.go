@@ -0,0 +1,50 @@
+package registry
+
+import "testing"
+
+func TestVersionMatchers(t *testing.T) {
+	registered := []string{"v1", "v1.2", "v1.10", "v2.0.1", "beta"}
+
+	for _, tc := range []struct {
+		match     VersionMatcher
+		requested string
+		expect    string
+	}{
+		{ExactVersion, "v1.2", "v1.2"},
+		{ExactVersion, "v1.3", ""},
+		{PrefixVersion, "v1.2", "v1.2"},
+		{PrefixVersion, "v1.2.5", "v1.2"},
+		{PrefixVersion, "v1.3", "v1"},
+		{PrefixVersion, "v11", ""},
+		{PrefixVersion, "v2", ""},
+		{WildcardVersion, "v1.*", "v1.10"},
+		{WildcardVersion, "v1.x", "v1.10"},
+		{WildcardVersion, "v2.*", "v2.0.1"},
+		{WildcardVersion, "v2.x.1", "v2.0.1"},
+		{WildcardVersion, "v2.x", "v2.0.1"},
+		{WildcardVersion, "v1.2.*", "v1.2"},
+		{WildcardVersion, "*", "v2.0.1"},
+		{WildcardVersion, "v3.*", ""},
+	} {
+		v, ok := tc.match(tc.requested, registered)
+		if v != tc.expect || ok != (tc.expect != "") {
+			t.Errorf("Unexpected match for %s: %q (%t), expected %q", tc.requested, v, ok, tc.expect)
+		}
+	}
+}
+
+func TestMatchingRegistry(t *testing.T) {
+	r := MatchingRegistry{DefaultRegistry: DefaultRegistry{}}
+	r.Add("service1", "v1", "localhost:9091")
+
+	if _, err := r.Lookup("service1", "v1.2"); err != ErrServiceNotFound {
+		t.Fatalf("Expected exact matching by default, got %v", err)
+	}
+	r.Match = PrefixVersion
+	if endpoints, err := r.Lookup("service1", "v1.2"); err != nil || len(endpoints) != 1 || endpoints[0] != "localhost:9091" {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+	if _, err := r.Lookup("service2", "v1.2"); err != ErrServiceNotFound {
+		t.Fatalf("Expected %v, got %v", ErrServiceNotFound, err)
+	}
+}
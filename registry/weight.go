@@ -0,0 +1,70 @@
+package registry
+
+import "sync"
+
+// Weighter is implemented by registries assigning weights to endpoints.
+// Balancers send more traffic to endpoints with higher weights.
+type Weighter interface {
+	Weight(name, version, endpoint string) int // Return the weight of the given endpoint, 1 by default.
+}
+
+// Weight returns the weight of the given endpoint from the first Weighter
+// found in reg or the registries it wraps, 1 without.
+func Weight(reg Registry, name, version, endpoint string) int {
+	for ; reg != nil; reg = Unwrap(reg) {
+		if weighter, ok := reg.(Weighter); ok {
+			return weighter.Weight(name, version, endpoint)
+		}
+	}
+	return 1
+}
+
+// WeightedRegistry wraps a Registry to assign weights to its endpoints.
+type WeightedRegistry struct {
+	Registry
+
+	lock    sync.RWMutex
+	weights map[endpointKey]int
+}
+
+// NewWeightedRegistry creates a WeightedRegistry wrapping the given registry.
+func NewWeightedRegistry(reg Registry) *WeightedRegistry {
+	return &WeightedRegistry{Registry: reg, weights: map[endpointKey]int{}}
+}
+
+// AddWithWeight adds the given endpoint for the service name/version with the given weight.
+func (r *WeightedRegistry) AddWithWeight(name, version, endpoint string, weight int) {
+	r.SetWeight(name, version, endpoint, weight)
+	r.Registry.Add(name, version, endpoint)
+}
+
+// SetWeight updates the weight of the given endpoint for the service name/version.
+func (r *WeightedRegistry) SetWeight(name, version, endpoint string, weight int) {
+	r.lock.Lock()
+	r.weights[endpointKey{name, version, endpoint}] = weight
+	r.lock.Unlock()
+}
+
+// Weight returns the weight of the given endpoint for the service name/version.
+// Endpoints without explicit weight have a weight of 1.
+func (r *WeightedRegistry) Weight(name, version, endpoint string) int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if weight, ok := r.weights[endpointKey{name, version, endpoint}]; ok {
+		return weight
+	}
+	return 1
+}
+
+// Delete removes the given endpoint for the service name/version and forgets its weight.
+func (r *WeightedRegistry) Delete(name, version, endpoint string) {
+	r.Registry.Delete(name, version, endpoint)
+	r.lock.Lock()
+	delete(r.weights, endpointKey{name, version, endpoint})
+	r.lock.Unlock()
+}
+
+// LookupTiers returns the endpoint tiers of the wrapped registry.
+func (r *WeightedRegistry) LookupTiers(name, version string) ([][]string, error) {
+	return LookupTiers(r.Registry, name, version)
+}
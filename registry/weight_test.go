@@ -0,0 +1,36 @@
+package registry
+
+import "testing"
+
+func TestWeightedRegistry(t *testing.T) {
+	r := NewWeightedRegistry(DefaultRegistry{})
+	r.Add("service1", "v1", "host1:80")
+	r.AddWithWeight("service1", "v1", "host2:80", 5)
+
+	if w := r.Weight("service1", "v1", "host1:80"); w != 1 {
+		t.Fatalf("Expected default weight 1, got %d", w)
+	}
+	if w := r.Weight("service1", "v1", "host2:80"); w != 5 {
+		t.Fatalf("Expected weight 5, got %d", w)
+	}
+	if endpoints, err := r.Lookup("service1", "v1"); err != nil || len(endpoints) != 2 {
+		t.Fatalf("Unexpected lookup result: %v, %v", endpoints, err)
+	}
+
+	r.Delete("service1", "v1", "host2:80")
+	if w := r.Weight("service1", "v1", "host2:80"); w != 1 {
+		t.Fatalf("Expected weight to be forgotten, got %d", w)
+	}
+}
+
+func TestWeight(t *testing.T) {
+	r := NewWeightedRegistry(DefaultRegistry{})
+	r.AddWithWeight("service1", "v1", "host1:80", 5)
+
+	if w := Weight(NewDrainingRegistry(NewPausingRegistry(r)), "service1", "v1", "host1:80"); w != 5 {
+		t.Fatalf("Expected the wrapped weight 5, got %d", w)
+	}
+	if w := Weight(DefaultRegistry{}, "service1", "v1", "host1:80"); w != 1 {
+		t.Fatalf("Expected default weight 1, got %d", w)
+	}
+}
@@ -0,0 +1,178 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// RetryTransport is an http.RoundTripper retrying the requests which failed
+// before any byte of the request was sent upstream, so they can't have been
+// processed twice. Each retry goes through the dial, hence LoadBalance, again.
+//
+// A failure is retriable when the request headers were not written: the
+// connection could not be established (refused, unreachable, timeout) or was
+// closed before sending. Failures once the request is written, such as a
+// reset while waiting for the response, and errors reading the response body
+// are never retried. Routing errors (unknown or paused service, connection
+// cap reached) and canceled requests are not retried either.
+//
+// The responses with one of StatusCodes are retried as well, for the
+// requests with one of Methods: unlike the failures before sending, these
+// requests may have been processed upstream.
+//
+// Requests with a body are only retried when it can be rewound through
+// GetBody, which is not the case of incoming server requests: the body is
+// streamed, never buffered.
+type RetryTransport struct {
+	Transport   http.RoundTripper // Transport used for each attempt.
+	MaxRetries  int               // Maximum number of retries after the first attempt.
+	Backoff     BackoffPolicy     // Delay between attempts, DefaultBackoff when nil.
+	StatusCodes []int             // Response status codes retried, e.g. 502, 503.
+	Methods     []string          // Methods retried on StatusCodes, DefaultRetryMethods when nil.
+}
+
+// DefaultRetryMethods are the idempotent methods, retried on the
+// RetryTransport StatusCodes by default.
+var DefaultRetryMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+
+// RetryPolicy configures the retries of the requests to a service, see
+// RetryTransport. The zero policy disables the retries.
+type RetryPolicy struct {
+	MaxRetries  int           // Maximum number of retries after the first attempt.
+	Backoff     BackoffPolicy // Delay between attempts, DefaultBackoff when nil.
+	StatusCodes []int         // Response status codes retried.
+	Methods     []string      // Methods retried on StatusCodes, DefaultRetryMethods when nil.
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var wrote bool
+		ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			WroteHeaderField: func(string, []string) { wrote = true },
+		})
+		resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+		if err == nil {
+			if attempt >= t.MaxRetries || !t.retriableStatus(req, resp.StatusCode) {
+				return resp, nil
+			}
+			// Discard the response to retry, keeping the connection reusable when small.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		} else if wrote || attempt >= t.MaxRetries || !isRetriable(req, err) {
+			return resp, err
+		}
+		if !t.wait(req.Context(), attempt) {
+			if err == nil {
+				err = req.Context().Err()
+			}
+			return nil, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				if err == nil {
+					err = bodyErr
+				}
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retriableStatus reports whether the response status of the request is
+// worth a new attempt.
+func (t *RetryTransport) retriableStatus(req *http.Request, status int) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	methods := t.Methods
+	if methods == nil {
+		methods = DefaultRetryMethods
+	}
+	retriable := false
+	for _, code := range t.StatusCodes {
+		if code == status {
+			retriable = true
+			break
+		}
+	}
+	if !retriable {
+		return false
+	}
+	for _, method := range methods {
+		if method == req.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceRetryTransport is an http.RoundTripper retrying the requests with
+// the RetryPolicy of their service.
+type serviceRetryTransport struct {
+	Transport http.RoundTripper
+	proxy     *Proxy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t serviceRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := routeFromContext(req.Context())
+	if !ok {
+		return t.Transport.RoundTrip(req)
+	}
+	policy := t.proxy.retryPolicy(r.name, r.version)
+	if policy.MaxRetries <= 0 {
+		return t.Transport.RoundTrip(req)
+	}
+	retry := &RetryTransport{
+		Transport:   t.Transport,
+		MaxRetries:  policy.MaxRetries,
+		Backoff:     policy.Backoff,
+		StatusCodes: policy.StatusCodes,
+		Methods:     policy.Methods,
+	}
+	return retry.RoundTrip(req)
+}
+
+// wait sleeps before the given retry attempt. It returns false
+// when the request is canceled meanwhile.
+func (t *RetryTransport) wait(ctx context.Context, attempt int) bool {
+	backoff := t.Backoff
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+	timer := time.NewTimer(backoff.Next(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isRetriable reports whether err, which happened before the request was
+// written, is worth a new attempt.
+func isRetriable(req *http.Request, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if req.Context().Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	for _, target := range []error{ErrInvalidService, ErrTooManyConns, registry.ErrServiceNotFound, registry.ErrNoEndpoints, registry.ErrServicePaused} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package goproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// roundTripFunc is an http.RoundTripper calling itself.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRetryTransport(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	var dials int
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(down.URL, "http://"))
+	transport := &http.Transport{Dial: func(network, addr string) (net.Conn, error) {
+		// Fail the first dial, as if all the endpoints were down.
+		if dials++; dials == 1 {
+			return LoadBalance(network, "service1", "v1", reg)
+		}
+		return net.Dial(network, strings.TrimPrefix(backend.URL, "http://"))
+	}}
+
+	var backoffs []int
+	backoff := backoffFunc(func(attempt int) time.Duration {
+		backoffs = append(backoffs, attempt)
+		return time.Millisecond
+	})
+	client := &http.Client{Transport: &RetryTransport{Transport: transport, MaxRetries: 2, Backoff: backoff}}
+	resp, err := client.Get("http://service1/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "backend1 /v1/users" || dials != 2 {
+		t.Fatalf("Unexpected response after %d dials: %q", dials, body)
+	}
+	if fmt.Sprint(backoffs) != "[0]" {
+		t.Fatalf("Unexpected backoff calls: %v", backoffs)
+	}
+}
+
+// backoffFunc is a BackoffPolicy calling itself.
+type backoffFunc func(attempt int) time.Duration
+
+func (f backoffFunc) Next(attempt int) time.Duration { return f(attempt) }
+
+func TestRetryTransportNotAfterWrite(t *testing.T) {
+	// The upstream closes the connection after reading the request.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Read(make([]byte, 1024))
+			conn.Close()
+		}
+	}()
+
+	var attempts int
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return (&http.Transport{DisableKeepAlives: true}).RoundTrip(req)
+	})
+	client := &http.Client{Transport: &RetryTransport{Transport: transport, MaxRetries: 3}}
+	if _, err := client.Get("http://" + l.Addr().String()); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if attempts != 1 {
+		t.Fatalf("Requests should not be retried once written, got %d attempts", attempts)
+	}
+}
+
+func TestRetryTransportRoutingErrors(t *testing.T) {
+	var attempts int
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, registry.ErrServiceNotFound
+	})
+	_, err := (&RetryTransport{Transport: transport, MaxRetries: 3}).RoundTrip(httptest.NewRequest("GET", "/", nil))
+	if !errors.Is(err, registry.ErrServiceNotFound) || attempts != 1 {
+		t.Fatalf("Unexpected result after %d attempts: %v", attempts, err)
+	}
+}
+
+func TestProxyServiceRetryPolicy(t *testing.T) {
+	// Each path fails with a 503 on its first hit.
+	var lock sync.Mutex
+	hits := map[string]int{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		hits[req.Method+" "+req.URL.Path]++
+		n := hits[req.Method+" "+req.URL.Path]
+		lock.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("reads", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("payments", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.MaxRetries = 2
+	p.RetryStatusCodes = []int{http.StatusServiceUnavailable}
+	p.RetryBackoff = backoffFunc(func(int) time.Duration { return time.Millisecond })
+	p.ServiceConfig = ServiceConfigs(map[string]ServiceConfig{
+		"payments": {Retry: &RetryPolicy{}},
+	})
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/reads/v1/a", http.StatusOK},
+		// Non-idempotent methods are not retried on status codes.
+		{"POST", "/reads/v1/b", http.StatusServiceUnavailable},
+		{"GET", "/payments/v1/c", http.StatusServiceUnavailable},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Fatalf("Unexpected status for %s %s: %d, expected %d", tc.method, tc.path, w.Code, tc.status)
+		}
+	}
+}
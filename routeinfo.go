@@ -0,0 +1,58 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// RouteInfo describes how a path would be routed by the proxy.
+type RouteInfo struct {
+	Path      string   `json:"path"`
+	Name      string   `json:"name,omitempty"`
+	Version   string   `json:"version,omitempty"`
+	Rewritten string   `json:"rewritten,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
+	Paused    bool     `json:"paused,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// RouteInfoHandler creates a handler reporting, as JSON, how a path would be
+// routed without proxying it: the extracted name/version, the rewritten path
+// and the current endpoint list.
+// The path is taken from the `path` query parameter, defaulting to the request path.
+// When extract is nil, ExtractNameVersion is used.
+func RouteInfoHandler(reg registry.Registry, extract func(*url.URL) (string, string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		fn := extract
+		if fn == nil {
+			fn = ExtractNameVersion
+		}
+		info := RouteInfo{Path: req.URL.Path}
+		if path := req.URL.Query().Get("path"); path != "" {
+			info.Path = path
+		}
+
+		status := http.StatusOK
+		target := &url.URL{Path: info.Path}
+		name, version, err := fn(target)
+		if err != nil {
+			status, info.Error = http.StatusBadRequest, err.Error()
+		} else {
+			info.Name, info.Version, info.Rewritten = name, version, target.Path
+			info.Paused = registry.Paused(reg, name, version)
+			if info.Endpoints, err = reg.Lookup(name, version); errors.Is(err, registry.ErrNoEndpoints) || errors.Is(err, registry.ErrServicePaused) {
+				status, info.Error = http.StatusServiceUnavailable, err.Error()
+			} else if err != nil {
+				status, info.Error = http.StatusNotFound, err.Error()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,41 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestRouteInfoHandler(t *testing.T) {
+	reg := registry.NewPausingRegistry(registry.DefaultRegistry{})
+	reg.Add("service1", "v1", "localhost:9091")
+	reg.Add("service3", "v1", "localhost:9093")
+	reg.Pause("service3", "v1")
+	handler := RouteInfoHandler(reg, nil)
+
+	for _, tc := range []struct {
+		url    string
+		status int
+		expect RouteInfo
+	}{
+		{"/?path=/service1/v1/users", http.StatusOK, RouteInfo{Path: "/service1/v1/users", Name: "service1", Version: "v1", Rewritten: "/users", Endpoints: []string{"localhost:9091"}}},
+		{"/service1/v1/users", http.StatusOK, RouteInfo{Path: "/service1/v1/users", Name: "service1", Version: "v1", Rewritten: "/users", Endpoints: []string{"localhost:9091"}}},
+		{"/?path=/service2/v1", http.StatusNotFound, RouteInfo{Path: "/service2/v1", Name: "service2", Version: "v1", Rewritten: "/", Error: registry.ErrServiceNotFound.Error()}},
+		{"/?path=/service3/v1", http.StatusServiceUnavailable, RouteInfo{Path: "/service3/v1", Name: "service3", Version: "v1", Rewritten: "/", Paused: true, Error: registry.ErrServicePaused.Error()}},
+		{"/?path=/service1", http.StatusBadRequest, RouteInfo{Path: "/service1", Error: "Invalid path"}},
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", tc.url, nil))
+		var info RouteInfo
+		if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tc.status || fmt.Sprint(info) != fmt.Sprint(tc.expect) {
+			t.Errorf("Unexpected result for %s: %d %+v, expected %d %+v", tc.url, w.Code, info, tc.status, tc.expect)
+		}
+	}
+}
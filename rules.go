@@ -0,0 +1,32 @@
+package goproxy
+
+import "regexp"
+
+// VersionRule remaps the version of the requests to a service whose path,
+// as left by ExtractNameVersion, matches Path, e.g. to send `/service1/v1/beta/...`
+// to the v2 endpoints with Name "service1", Version "v1", Path `^/beta/` and
+// Target "v2".
+type VersionRule struct {
+	Name    string         // Service name, any when empty.
+	Version string         // Extracted version, any when empty.
+	Path    *regexp.Regexp // Pattern of the path left by ExtractNameVersion, any when nil.
+	Target  string         // Version used instead of the extracted one.
+}
+
+// match reports whether the rule applies to the given service name/version and path.
+func (r VersionRule) match(name, version, path string) bool {
+	return (r.Name == "" || r.Name == name) &&
+		(r.Version == "" || r.Version == version) &&
+		(r.Path == nil || r.Path.MatchString(path))
+}
+
+// applyVersionRules returns the Target of the first rule matching the
+// given service name/version and path, version when none matches.
+func applyVersionRules(rules []VersionRule, name, version, path string) string {
+	for _, rule := range rules {
+		if rule.match(name, version, path) {
+			return rule.Target
+		}
+	}
+	return version
+}
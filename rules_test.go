@@ -0,0 +1,36 @@
+package goproxy
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyVersionRules(t *testing.T) {
+	v1, v2 := newBackend(t, "v1"), newBackend(t, "v2")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(v1.URL, "http://"))
+	reg.Add("service1", "v2", strings.TrimPrefix(v2.URL, "http://"))
+	reg.Add("service2", "v1", strings.TrimPrefix(v1.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.VersionRules = []VersionRule{
+		{Name: "service1", Version: "v1", Path: regexp.MustCompile(`^/beta/stable`), Target: "v1"},
+		{Name: "service1", Version: "v1", Path: regexp.MustCompile(`^/beta/`), Target: "v2"},
+	}
+	for path, expect := range map[string]string{
+		"/service1/v1/users":            "v1 /users",
+		"/service1/v1/beta/users":       "v2 /beta/users",
+		"/service1/v1/beta/stable/user": "v1 /beta/stable/user",
+		"/service2/v1/beta/users":       "v1 /beta/users",
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != expect {
+			t.Errorf("Unexpected response for %s: %q, expected %q", path, w.Body.String(), expect)
+		}
+	}
+}
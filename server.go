@@ -0,0 +1,137 @@
+package goproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Server serves a single Proxy on several listeners, e.g. plain HTTP on an
+// internal interface along with HTTPS on the external one, so that they
+// share its registry, balancers, health state, metrics and upstream
+// connection pools instead of duplicating them with separate Proxies.
+type Server struct {
+	// Proxy serves the requests and is shut down along with the listeners.
+	Proxy *Proxy
+
+	// Handler, when set, serves the requests instead of the Proxy,
+	// e.g. the Proxy wrapped with middlewares.
+	Handler http.Handler
+
+	// ConfigureServer, when set, is called with the http.Server of each
+	// listener before it starts serving, e.g. to set its timeouts.
+	ConfigureServer func(*http.Server)
+
+	lock    sync.Mutex
+	closed  bool
+	servers []*http.Server
+}
+
+// NewServer creates a Server for the given Proxy.
+func NewServer(p *Proxy) *Server {
+	return &Server{Proxy: p}
+}
+
+// ListenAndServe listens on the given TCP addresses and serves them, see Serve.
+// The listeners are all opened before serving: it fails when one of the
+// addresses can't be listened on.
+func (s *Server) ListenAndServe(addrs ...string) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	return s.Serve(listeners...)
+}
+
+// Serve serves the given listeners until Shutdown or Close, wrapped with
+// tls.NewListener for HTTPS. When one of them fails, the others are closed
+// and the error is returned. It returns nil once they are all shut down.
+func (s *Server) Serve(listeners ...net.Listener) error {
+	handler := s.Handler
+	if handler == nil {
+		handler = s.Proxy
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		for _, l := range listeners {
+			l.Close()
+		}
+		return http.ErrServerClosed
+	}
+	servers := make([]*http.Server, len(listeners))
+	for i := range listeners {
+		servers[i] = &http.Server{Handler: handler}
+		if s.ConfigureServer != nil {
+			s.ConfigureServer(servers[i])
+		}
+	}
+	s.servers = append(s.servers, servers...)
+	s.lock.Unlock()
+
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		go func(srv *http.Server, l net.Listener) {
+			errs <- srv.Serve(l)
+		}(servers[i], l)
+	}
+	var first error
+	for range listeners {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) && first == nil {
+			first = err
+			for _, srv := range servers {
+				srv.Close()
+			}
+		}
+	}
+	return first
+}
+
+// Shutdown gracefully stops the Proxy, then the listeners: the proxied
+// requests are drained first, see Proxy.Shutdown, then the servers wait for
+// the other requests and close the client connections.
+// It returns the context error when ctx is done first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	servers := s.close()
+	if err := s.Proxy.Shutdown(ctx); err != nil {
+		return err
+	}
+	var first error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close stops the Proxy and closes the listeners and the client
+// connections without waiting for the in-flight requests.
+func (s *Server) Close() error {
+	servers := s.close()
+	s.Proxy.Close()
+	var first error
+	for _, srv := range servers {
+		if err := srv.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// close prevents new Serve calls and returns the servers to stop.
+func (s *Server) close() []*http.Server {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return s.servers
+}
@@ -0,0 +1,56 @@
+package goproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestServer(t *testing.T) {
+	backend := newBackend(t, "backend")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", backend.Listener.Addr().String())
+
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, l)
+	}
+	p := NewProxy(reg)
+	s := NewServer(p)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(listeners...) }()
+
+	for _, l := range listeners {
+		resp, err := http.Get("http://" + l.Addr().String() + "/service1/v1/users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "backend /users" {
+			t.Fatalf("Unexpected response on %s: %q", l.Addr(), body)
+		}
+	}
+	// Both listeners share the Proxy upstream connections.
+	if n := p.conns.Load(); n != 1 {
+		t.Fatalf("Unexpected upstream connections: %d", n)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Unexpected Serve error: %v", err)
+	}
+	if _, err := http.Get("http://" + listeners[0].Addr().String() + "/service1/v1/users"); err == nil {
+		t.Fatal("Unexpected request served after Shutdown")
+	}
+}
@@ -0,0 +1,89 @@
+package goproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// ServiceConfig groups the per-service settings of the Proxy, as an
+// alternative to the dedicated per-service callbacks. The zero fields fall
+// back to the callbacks, then to the Proxy defaults.
+type ServiceConfig struct {
+	Balancer        LoadBalancer      // See Proxy.Balancer.
+	TLSConfig       *tls.Config       // See Proxy.UpstreamTLSConfig.
+	PathPrefix      string            // See Proxy.PathPrefix.
+	ResponseHeaders http.Header       // See Proxy.ServiceResponseHeaders.
+	Transport       http.RoundTripper // See Proxy.Transport.
+
+	// Retry, when set, replaces the Proxy MaxRetries, RetryStatusCodes,
+	// RetryMethods and RetryBackoff for the service. The zero policy
+	// disables the retries, e.g. for non-idempotent services.
+	Retry *RetryPolicy
+}
+
+// ServiceConfigs creates a Proxy.ServiceConfig selecting configs by
+// `<name>/<version>` first, then by `<name>`.
+func ServiceConfigs(configs map[string]ServiceConfig) func(name, version string) *ServiceConfig {
+	return func(name, version string) *ServiceConfig {
+		if config, ok := configs[name+"/"+version]; ok {
+			return &config
+		}
+		if config, ok := configs[name]; ok {
+			return &config
+		}
+		return nil
+	}
+}
+
+// serviceConfig returns the config of the given service name/version,
+// the zero config when it has none.
+func (p *Proxy) serviceConfig(name, version string) ServiceConfig {
+	if p.ServiceConfig != nil {
+		if config := p.ServiceConfig(name, version); config != nil {
+			return *config
+		}
+	}
+	return ServiceConfig{}
+}
+
+// pathPrefix returns the base path of the endpoints of the given service name/version.
+func (p *Proxy) pathPrefix(name, version string) string {
+	if prefix := p.serviceConfig(name, version).PathPrefix; prefix != "" {
+		return prefix
+	}
+	if p.PathPrefix != nil {
+		return p.PathPrefix(name, version)
+	}
+	return ""
+}
+
+// serviceResponseHeaders returns the response headers of the given service name/version.
+func (p *Proxy) serviceResponseHeaders(name, version string) http.Header {
+	if h := p.serviceConfig(name, version).ResponseHeaders; h != nil {
+		return h
+	}
+	if p.ServiceResponseHeaders != nil {
+		return p.ServiceResponseHeaders(name, version)
+	}
+	return nil
+}
+
+// retryPolicy returns the retry policy of the given service name/version.
+func (p *Proxy) retryPolicy(name, version string) RetryPolicy {
+	if policy := p.serviceConfig(name, version).Retry; policy != nil {
+		return *policy
+	}
+	return RetryPolicy{MaxRetries: p.MaxRetries, Backoff: p.RetryBackoff, StatusCodes: p.RetryStatusCodes, Methods: p.RetryMethods}
+}
+
+// serviceTransport returns the transport of the given service name/version,
+// nil for the Proxy transport.
+func (p *Proxy) serviceTransport(name, version string) http.RoundTripper {
+	if rt := p.serviceConfig(name, version).Transport; rt != nil {
+		return rt
+	}
+	if p.Transport != nil {
+		return p.Transport(name, version)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyServiceConfig(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.Path))
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	for _, name := range []string{"service1", "service2"} {
+		reg.Add(name, "v1", strings.TrimPrefix(backend.URL, "http://"))
+		reg.Add(name, "v2", strings.TrimPrefix(backend.URL, "http://"))
+	}
+
+	var transported int
+	p := NewProxy(reg)
+	p.ServiceConfig = ServiceConfigs(map[string]ServiceConfig{
+		"service1": {PathPrefix: "/api", ResponseHeaders: http.Header{"X-Service": {"service1"}}},
+		"service1/v2": {PathPrefix: "/api/v2", Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			transported++
+			return p.transport.RoundTrip(req)
+		})},
+	})
+	// The dedicated callbacks apply to the zero fields.
+	p.PathPrefix = PathPrefixes(map[string]string{"service2": "/legacy"})
+
+	for path, expect := range map[string]struct {
+		body, header string
+	}{
+		"/service1/v1/users": {"/api/users", "service1"},
+		"/service1/v2/users": {"/api/v2/users", ""},
+		"/service2/v1/users": {"/legacy/users", ""},
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != expect.body || w.Header().Get("X-Service") != expect.header {
+			t.Errorf("Unexpected response for %s: %q, X-Service %q", path, w.Body.String(), w.Header().Get("X-Service"))
+		}
+	}
+	if transported != 1 {
+		t.Fatalf("Unexpected requests through the service transport: %d", transported)
+	}
+}
@@ -0,0 +1,75 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// Shutdown gracefully stops the Proxy: new requests get a 503 Service Unavailable,
+// the in-flight requests are waited for, then the idle upstream connections are closed.
+// The websocket bridges and CONNECT tunnels are not waited for.
+// It returns the context error when ctx is done first.
+//
+// When the Proxy is embedded in a larger server, call Shutdown before
+// http.Server.Shutdown, so the proxied requests are drained while the
+// server keeps serving the other routes. When the Proxy is the only handler,
+// http.Server.Shutdown drains the requests and Close releases the upstream
+// connections.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closing = true
+	var drained chan struct{}
+	if p.active > 0 {
+		if p.drained == nil {
+			p.drained = make(chan struct{})
+		}
+		drained = p.drained
+	}
+	p.mu.Unlock()
+
+	if drained != nil {
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	p.transport.CloseIdleConnections()
+	return nil
+}
+
+// Close stops the Proxy without waiting for the in-flight requests:
+// new requests get a 503 Service Unavailable and the idle upstream
+// connections are closed.
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+	p.transport.CloseIdleConnections()
+	return nil
+}
+
+// enter registers a new request, waited for by Shutdown unless it is an upgrade.
+// It returns false when the Proxy is shut down.
+func (p *Proxy) enter(req *http.Request) (tracked, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closing {
+		return false, false
+	}
+	if req.Method == http.MethodConnect || isUpgrade(req) {
+		return false, true
+	}
+	p.active++
+	return true, true
+}
+
+// leave unregisters a request registered by enter.
+func (p *Proxy) leave() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active--; p.active == 0 && p.drained != nil {
+		close(p.drained)
+		p.drained = nil
+	}
+}
@@ -0,0 +1,74 @@
+package goproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyShutdown(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	p := NewProxy(reg)
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	done := make(chan int)
+	go func() {
+		resp, err := http.Get(proxy.URL + "/service1/v1/")
+		if err != nil {
+			t.Error(err)
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	for p.Stats().Conns != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The in-flight request is waited for.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected shutdown error with a request in flight: %v", err)
+	}
+
+	// New requests are rejected.
+	resp, err := http.Get(proxy.URL + "/service1/v1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Unexpected status during shutdown: %d", resp.StatusCode)
+	}
+
+	shutdown := make(chan error)
+	go func() { shutdown <- p.Shutdown(context.Background()) }()
+	close(release)
+	if status := <-done; status != http.StatusOK {
+		t.Fatalf("Unexpected status for the in-flight request: %d", status)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	// The idle upstream connection was closed.
+	for i := 0; p.Stats().Conns != 0; i++ {
+		if i == 1000 {
+			t.Fatal("The idle upstream connection was not closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,153 @@
+package goproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// SNIProxy is a TLS pass-through proxy. It routes raw TCP connections
+// based on the server name (SNI) sent in the TLS ClientHello, so any
+// protocol over TLS can be proxied without terminating TLS.
+type SNIProxy struct {
+	// Route maps the requested server name to a service name/version.
+	// When nil, SNIRoute is used.
+	Route func(serverName string) (name, version string, err error)
+
+	// HandshakeTimeout bounds the time allowed to receive the ClientHello.
+	// When zero, 10 seconds is used.
+	HandshakeTimeout time.Duration
+
+	registry registry.Registry
+}
+
+// NewSNIProxy creates a SNIProxy routing connections to the endpoints of
+// the given registry using LoadBalance.
+func NewSNIProxy(reg registry.Registry) *SNIProxy {
+	return &SNIProxy{registry: reg}
+}
+
+// SNIRoute extracts the service name/version from the first two labels
+// of the server name: `<name>.<version>.example.com`.
+func SNIRoute(serverName string) (name, version string, err error) {
+	tmp := strings.SplitN(serverName, ".", 3)
+	if len(tmp) < 2 || tmp[0] == "" || tmp[1] == "" {
+		return "", "", fmt.Errorf("Invalid server name %q", serverName)
+	}
+	return tmp[0], tmp[1], nil
+}
+
+// Serve accepts the connections of l and proxies them until l is closed.
+func (p *SNIProxy) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.ServeConn(conn)
+	}
+}
+
+// ServeConn proxies the given TLS connection and closes it when done.
+func (p *SNIProxy) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	timeout := p.HandshakeTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	serverName, clientHello, err := peekServerName(conn)
+	if err != nil {
+		log.Printf("sni: error reading ClientHello from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	route := p.Route
+	if route == nil {
+		route = SNIRoute
+	}
+	name, version, err := route(serverName)
+	if err != nil {
+		log.Printf("sni: error routing %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	target, err := LoadBalance("tcp", name, version, p.registry)
+	if err != nil {
+		log.Printf("sni: error connecting to %s/%s: %s", name, version, err)
+		return
+	}
+	defer target.Close()
+
+	// Replay the ClientHello then bridge both connections.
+	tunnel(conn, io.MultiReader(clientHello, conn), target)
+}
+
+// tunnel copies r, read from conn, to target and target to conn
+// until both directions are done.
+func tunnel(conn net.Conn, r io.Reader, target net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		io.Copy(target, r)
+		closeWrite(target)
+	}()
+	io.Copy(conn, target)
+	closeWrite(conn)
+	wg.Wait()
+}
+
+// closeWrite half-closes the connection when supported, closes it otherwise.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// peekServerName reads the TLS ClientHello from r and returns the requested
+// server name along with the consumed bytes.
+func peekServerName(r io.Reader) (string, io.Reader, error) {
+	peeked := &bytes.Buffer{}
+	var serverName string
+	var found bool
+	err := tls.Server(readOnlyConn{Reader: io.TeeReader(r, peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, found = hello.ServerName, true
+			// Abort the handshake, only the ClientHello is needed.
+			return nil, errSNIPeeked
+		},
+	}).Handshake()
+	if !found {
+		return "", nil, err
+	}
+	return serverName, peeked, nil
+}
+
+var errSNIPeeked = errors.New("sni peeked")
+
+// readOnlyConn is a net.Conn only able to read from the underlying reader.
+// It lets crypto/tls parse a ClientHello without answering it.
+type readOnlyConn struct {
+	io.Reader
+}
+
+func (readOnlyConn) Write([]byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (readOnlyConn) Close() error                     { return nil }
+func (readOnlyConn) LocalAddr() net.Addr              { return nil }
+func (readOnlyConn) RemoteAddr() net.Addr             { return nil }
+func (readOnlyConn) SetDeadline(time.Time) error      { return nil }
+func (readOnlyConn) SetReadDeadline(time.Time) error  { return nil }
+func (readOnlyConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,56 @@
+package goproxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestSNIRoute(t *testing.T) {
+	if name, version, err := SNIRoute("service1.v1.example.com"); err != nil || name != "service1" || version != "v1" {
+		t.Fatalf("Unexpected route: %s/%s, %v", name, version, err)
+	}
+	if _, _, err := SNIRoute("localhost"); err == nil {
+		t.Fatal("Expected an error for a single label server name")
+	}
+}
+
+func TestSNIProxy(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, req.TLS.ServerName)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "https://"))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go NewSNIProxy(reg).Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialTLS: func(network, addr string) (net.Conn, error) {
+			return tls.Dial(network, l.Addr().String(), &tls.Config{ServerName: "service1.v1.example.com", InsecureSkipVerify: true})
+		},
+	}}
+	resp, err := client.Get("https://service1.v1.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "service1.v1.example.com" {
+		t.Fatalf("Unexpected response: %q", body)
+	}
+}
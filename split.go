@@ -0,0 +1,211 @@
+package goproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// TrafficSplit shifts the requests for a service name/version to weighted
+// target versions, e.g. to send 10% of the v1 traffic to a v2 canary.
+// The weights can be changed at runtime, e.g. with TrafficSplitHandler:
+// the changes apply to the next requests. It is safe for concurrent use.
+type TrafficSplit struct {
+	lock   sync.RWMutex
+	splits map[string]*versionSplit // By `<name>/<version>`.
+}
+
+// versionSplit holds the weights of the target versions, sorted by version.
+type versionSplit struct {
+	versions []string
+	weights  []int
+	total    int
+}
+
+// VersionWeights describes the split of a service name/version.
+type VersionWeights struct {
+	Name    string         `json:"name"`
+	Version string         `json:"version"`
+	Weights map[string]int `json:"weights"`
+}
+
+// NewTrafficSplit creates an empty TrafficSplit.
+func NewTrafficSplit() *TrafficSplit {
+	return &TrafficSplit{splits: map[string]*versionSplit{}}
+}
+
+// Set shifts the requests for the given service name/version to the target
+// versions, proportionally to their weights, e.g. {"v1": 90, "v2": 10}.
+// The weights must be non-negative, and not all zero.
+func (s *TrafficSplit) Set(name, version string, weights map[string]int) error {
+	split := &versionSplit{}
+	for target := range weights {
+		split.versions = append(split.versions, target)
+	}
+	sort.Strings(split.versions)
+	for _, target := range split.versions {
+		weight := weights[target]
+		if weight < 0 {
+			return fmt.Errorf("Invalid negative weight %d for version %q", weight, target)
+		}
+		split.weights = append(split.weights, weight)
+		split.total += weight
+	}
+	if split.total == 0 {
+		return errors.New("No positive weight")
+	}
+
+	s.lock.Lock()
+	s.splits[name+"/"+version] = split
+	s.lock.Unlock()
+	return nil
+}
+
+// Delete stops shifting the requests for the given service name/version.
+func (s *TrafficSplit) Delete(name, version string) {
+	s.lock.Lock()
+	delete(s.splits, name+"/"+version)
+	s.lock.Unlock()
+}
+
+// Weights returns the weights of the target versions of the given service
+// name/version, nil when its requests are not shifted.
+func (s *TrafficSplit) Weights(name, version string) map[string]int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.splits[name+"/"+version].weightMap()
+}
+
+// Snapshot returns the splits sorted by service name/version.
+func (s *TrafficSplit) Snapshot() []VersionWeights {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := make([]VersionWeights, 0, len(s.splits))
+	for key, split := range s.splits {
+		name, version, _ := strings.Cut(key, "/")
+		ret = append(ret, VersionWeights{Name: name, Version: version, Weights: split.weightMap()})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Version < ret[j].Version
+	})
+	return ret
+}
+
+// weightMap returns the weights by target version, nil for a nil split.
+func (s *versionSplit) weightMap() map[string]int {
+	if s == nil {
+		return nil
+	}
+	weights := make(map[string]int, len(s.versions))
+	for i, target := range s.versions {
+		weights[target] = s.weights[i]
+	}
+	return weights
+}
+
+// pick returns the target version of a request for the given service
+// name/version, version itself when its requests are not shifted.
+// The version is selected randomly, or by hashing the seed when not empty.
+func (s *TrafficSplit) pick(name, version, seed string) string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	split, ok := s.splits[name+"/"+version]
+	if !ok {
+		return version
+	}
+	if seed == "" {
+		return split.versions[pickWeighted(split.weights, split.total, -1)]
+	}
+	n := int(rendezvousHash(seed, name+"/"+version) % uint64(split.total))
+	for i, w := range split.weights {
+		if n < w {
+			return split.versions[i]
+		}
+		n -= w
+	}
+	return version
+}
+
+// TrafficSplitHandler creates an admin handler getting and setting the
+// weights of split, as JSON, at the `/<name>/<version>` path: mount it with
+// http.StripPrefix.
+//
+//	GET    /                 lists the splits
+//	GET    /<name>/<version> returns the weights of the service
+//	PUT    /<name>/<version> sets the weights, e.g. {"v1": 90, "v2": 10}
+//	DELETE /<name>/<version> stops the split
+//
+// The target versions of the weights must exist in the registry.
+func TrafficSplitHandler(split *TrafficSplit, reg registry.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(req.URL.Path, "/")
+		if path == "" {
+			if req.Method != http.MethodGet {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, split.Snapshot())
+			return
+		}
+		name, version, ok := strings.Cut(path, "/")
+		if !ok || name == "" || version == "" || strings.Contains(version, "/") {
+			http.Error(w, "Invalid service path, expected /<name>/<version>", http.StatusNotFound)
+			return
+		}
+
+		switch req.Method {
+		case http.MethodGet:
+			weights := split.Weights(name, version)
+			if weights == nil {
+				http.Error(w, "No split for "+name+"/"+version, http.StatusNotFound)
+				return
+			}
+			writeJSON(w, VersionWeights{Name: name, Version: version, Weights: weights})
+		case http.MethodPut:
+			var weights map[string]int
+			if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20)).Decode(&weights); err != nil {
+				http.Error(w, "Invalid weights: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			for target := range weights {
+				_, err := reg.Lookup(name, target)
+				if errors.Is(err, registry.ErrServiceNotFound) {
+					http.Error(w, fmt.Sprintf("Unknown version %s/%s", name, target), http.StatusBadRequest)
+					return
+				}
+				// A version without endpoints is known, any other error
+				// leaves it unvalidated.
+				if err != nil && !errors.Is(err, registry.ErrNoEndpoints) {
+					http.Error(w, fmt.Sprintf("Failed to validate version %s/%s: %s", name, target, err), http.StatusServiceUnavailable)
+					return
+				}
+			}
+			if err := split.Set(name, version, weights); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			split.Delete(name, version)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// writeJSON writes v as JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
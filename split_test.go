@@ -0,0 +1,95 @@
+package goproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+	"github.com/creack/goproxy/registry/registrytest"
+)
+
+func TestTrafficSplit(t *testing.T) {
+	s := NewTrafficSplit()
+	if err := s.Set("service1", "v1", map[string]int{"v1": 90, "v2": -10}); err == nil {
+		t.Fatal("Unexpected success with a negative weight")
+	}
+	if err := s.Set("service1", "v1", map[string]int{"v1": 0}); err == nil {
+		t.Fatal("Unexpected success without positive weight")
+	}
+	if err := s.Set("service1", "v1", map[string]int{"v1": 3, "v2": 1}); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		counts[s.pick("service1", "v1", "")]++
+	}
+	if counts["v2"] < 800 || counts["v2"] > 1200 || counts["v1"]+counts["v2"] != 4000 {
+		t.Fatalf("Unexpected split: %v", counts)
+	}
+	if version := s.pick("service1", "v2", ""); version != "v2" {
+		t.Fatalf("Unexpected version without split: %s", version)
+	}
+}
+
+func TestTrafficSplitHandler(t *testing.T) {
+	v1, v2 := newBackend(t, "v1"), newBackend(t, "v2")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", v1.Listener.Addr().String())
+	reg.Add("service1", "v2", v2.Listener.Addr().String())
+
+	p := NewProxy(reg)
+	p.TrafficSplit = NewTrafficSplit()
+	mux := http.NewServeMux()
+	mux.Handle("/split/", http.StripPrefix("/split", TrafficSplitHandler(p.TrafficSplit, reg)))
+	mux.Handle("/", p)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+		expect             string
+	}{
+		{"PUT", "/split/service1/v1", `{"v1": 0, "v2": -1}`, http.StatusBadRequest, ""},
+		{"PUT", "/split/service1/v1", `{"v3": 1}`, http.StatusBadRequest, ""},
+		{"PUT", "/split/service1", `{"v2": 1}`, http.StatusNotFound, ""},
+		{"GET", "/split/service1/v1", "", http.StatusNotFound, ""},
+		{"GET", "/service1/v1/", "", http.StatusOK, "v1 /"},
+		{"PUT", "/split/service1/v1", `{"v1": 0, "v2": 1}`, http.StatusNoContent, ""},
+		{"GET", "/split/service1/v1", "", http.StatusOK, `{"name":"service1","version":"v1","weights":{"v1":0,"v2":1}}` + "\n"},
+		{"GET", "/split/", "", http.StatusOK, `[{"name":"service1","version":"v1","weights":{"v1":0,"v2":1}}]` + "\n"},
+		{"GET", "/service1/v1/", "", http.StatusOK, "v2 /"},
+		{"DELETE", "/split/service1/v1", "", http.StatusNoContent, ""},
+		{"GET", "/service1/v1/", "", http.StatusOK, "v1 /"},
+	} {
+		w := serve(tc.method, tc.path, tc.body)
+		if w.Code != tc.status || (tc.expect != "" && w.Body.String() != tc.expect) {
+			t.Fatalf("Unexpected response for %s %s: %d %q", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestTrafficSplitHandlerLookupError(t *testing.T) {
+	reg := registrytest.New()
+	reg.SetLookup("service1", "v2", nil, registry.ErrNoEndpoints)
+	reg.SetLookup("service1", "v3", nil, errors.New("backend unavailable"))
+	handler := http.StripPrefix("/split", TrafficSplitHandler(NewTrafficSplit(), reg))
+
+	// Only the versions known to the registry are accepted.
+	for body, status := range map[string]int{
+		`{"v2": 1}`: http.StatusNoContent,
+		`{"v3": 1}`: http.StatusServiceUnavailable,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/split/service1/v1", strings.NewReader(body)))
+		if w.Code != status {
+			t.Fatalf("Unexpected status for %s: %d %q, expected %d", body, w.Code, w.Body.String(), status)
+		}
+	}
+}
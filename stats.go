@@ -0,0 +1,128 @@
+package goproxy
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats holds a snapshot of the Proxy state.
+type Stats struct {
+	Conns      int         // Open upstream connections, active or idle.
+	MaxConns   int         // Upstream connections cap, 0 when unlimited.
+	Selections []Selection // Endpoint selections of the default balancers.
+	BytesIn    int64       // Bytes read from upstream connections wrapped by Traffic.
+	BytesOut   int64       // Bytes written to upstream connections wrapped by Traffic.
+}
+
+// Stats returns a snapshot of the Proxy state.
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Conns:      int(p.conns.Load()),
+		MaxConns:   p.MaxConns,
+		Selections: Selections.Snapshot(),
+		BytesIn:    Traffic.In.Load(),
+		BytesOut:   Traffic.Out.Load(),
+	}
+}
+
+// Selections counts the endpoints selected by the default balancers.
+var Selections = &SelectionCounter{}
+
+// Selection is the number of times an endpoint has been selected.
+type Selection struct {
+	Name, Version, Endpoint string
+	Count                   int64
+}
+
+type selectionKey struct {
+	name, version, endpoint string
+}
+
+// SelectionCounter counts the selections per service name/version endpoint.
+// Counting an already known endpoint is atomic and doesn't allocate.
+type SelectionCounter struct {
+	lock   sync.RWMutex
+	counts map[selectionKey]*atomic.Int64
+}
+
+// Inc counts a selection of the given endpoint.
+func (c *SelectionCounter) Inc(name, version, endpoint string) {
+	key := selectionKey{name, version, endpoint}
+	c.lock.RLock()
+	count, ok := c.counts[key]
+	c.lock.RUnlock()
+	if !ok {
+		c.lock.Lock()
+		if count, ok = c.counts[key]; !ok {
+			if c.counts == nil {
+				c.counts = map[selectionKey]*atomic.Int64{}
+			}
+			count = &atomic.Int64{}
+			c.counts[key] = count
+		}
+		c.lock.Unlock()
+	}
+	count.Add(1)
+}
+
+// Snapshot returns the current counts, sorted by name, version and endpoint.
+func (c *SelectionCounter) Snapshot() []Selection {
+	c.lock.RLock()
+	selections := make([]Selection, 0, len(c.counts))
+	for key, count := range c.counts {
+		selections = append(selections, Selection{Name: key.name, Version: key.version, Endpoint: key.endpoint, Count: count.Load()})
+	}
+	c.lock.RUnlock()
+	sort.Slice(selections, func(i, j int) bool {
+		a, b := selections[i], selections[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		return a.Endpoint < b.Endpoint
+	})
+	return selections
+}
+
+// Traffic counts the bytes exchanged with the upstreams when
+// its Wrap method is used as ConnWrapper.
+var Traffic = &ByteCounter{}
+
+// ByteCounter counts the bytes read from and written to connections.
+type ByteCounter struct {
+	In, Out atomic.Int64
+}
+
+// Wrap returns a connection counting its traffic in c. It can be used as ConnWrapper.
+func (c *ByteCounter) Wrap(conn net.Conn, name, version, endpoint string) net.Conn {
+	return &countingConn{Conn: conn, counter: c}
+}
+
+type countingConn struct {
+	net.Conn
+	counter *ByteCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counter.In.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.Out.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite shuts down the writing side of the connection when supported.
+func (c *countingConn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return c.Conn.Close()
+}
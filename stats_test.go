@@ -0,0 +1,53 @@
+package goproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestSelectionCounter(t *testing.T) {
+	c := &SelectionCounter{}
+	c.Inc("service1", "v1", "host2:80")
+	c.Inc("service1", "v1", "host1:80")
+	c.Inc("service1", "v1", "host2:80")
+	c.Inc("service0", "v2", "host1:80")
+
+	if s := fmt.Sprint(c.Snapshot()); s != "[{service0 v2 host1:80 1} {service1 v1 host1:80 1} {service1 v1 host2:80 2}]" {
+		t.Fatalf("Unexpected snapshot: %s", s)
+	}
+	if n := testing.AllocsPerRun(100, func() { c.Inc("service1", "v1", "host1:80") }); n != 0 {
+		t.Fatalf("Expected no allocation counting a known endpoint, got %v", n)
+	}
+}
+
+func TestTrafficConnWrapper(t *testing.T) {
+	backend := newBackend(t, "backend1")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	defer func(w func(net.Conn, string, string, string) net.Conn) { ConnWrapper = w }(ConnWrapper)
+	counter := &ByteCounter{}
+	var wrapped []string
+	ConnWrapper = func(conn net.Conn, name, version, endpoint string) net.Conn {
+		wrapped = append(wrapped, name+"/"+version)
+		return counter.Wrap(conn, name, version, endpoint)
+	}
+
+	w := httptest.NewRecorder()
+	NewProxy(reg).ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/users", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", w.Code)
+	}
+	if fmt.Sprint(wrapped) != "[service1/v1]" {
+		t.Fatalf("Unexpected wrapped connections: %v", wrapped)
+	}
+	if counter.In.Load() == 0 || counter.Out.Load() == 0 {
+		t.Fatalf("Expected traffic to be counted, got in: %d, out: %d", counter.In.Load(), counter.Out.Load())
+	}
+}
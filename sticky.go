@@ -0,0 +1,127 @@
+package goproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// StickyCookie creates a Proxy.StickyKey using the value of the given cookie as affinity key.
+func StickyCookie(name string) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// SelectionSeedHeader creates a Proxy.SelectionSeed using the value of the given header as seed.
+func SelectionSeedHeader(name string) func(req *http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// pin returns the endpoint of the service name/version the given affinity
+// key is pinned to, if any.
+func (p *Proxy) pin(key, name, version string) string {
+	tiers, err := registry.LookupTiers(p.registry, name, version)
+	if err != nil {
+		return ""
+	}
+	for _, endpoints := range tiers {
+		if endpoint := rendezvous(endpoints, key); endpoint != "" {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// rendezvous returns the endpoint with the highest hash for the given key.
+func rendezvous(endpoints []string, key string) string {
+	var selected string
+	var max uint64
+	for _, endpoint := range endpoints {
+		if endpoint == "" {
+			continue
+		}
+		if h := rendezvousHash(key, endpoint); selected == "" || h > max {
+			selected, max = endpoint, h
+		}
+	}
+	return selected
+}
+
+// rendezvousHash hashes the key/endpoint pair with FNV-1a, mixed with
+// the splitmix64 finalizer for a uniform distribution.
+func rendezvousHash(key, endpoint string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint64(key[i])) * 1099511628211
+	}
+	h *= 1099511628211 // Zero byte separator.
+	for i := 0; i < len(endpoint); i++ {
+		h = (h ^ uint64(endpoint[i])) * 1099511628211
+	}
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
+
+// errPinnedDown is returned by the pinned balancer when the pinned endpoint
+// can't be dialed and the affinity is lenient.
+var errPinnedDown = errors.New("pinned endpoint down")
+
+// pinnedBalancer returns a balancer connecting to the given pinned endpoint.
+// The connections are pooled by the Transport for the pinned requests only.
+func (p *Proxy) pinnedBalancer(endpoint string) LoadBalancer {
+	return func(network, name, version string, reg registry.Registry) (net.Conn, error) {
+		conn, err := dial(network, endpoint)
+		if err != nil {
+			dialFailed(reg, name, version, endpoint, err)
+			if !p.StrictAffinity {
+				err = fmt.Errorf("%w: %s", errPinnedDown, err)
+			}
+			return nil, err
+		}
+		return connected(conn, name, version, endpoint), nil
+	}
+}
+
+// fallbackTransport is an http.RoundTripper sending the requests whose pinned
+// endpoint is down to the other endpoints of the service. The fallback
+// connections are pooled with the unpinned ones, so the next pinned request
+// tries the pinned endpoint again.
+type fallbackTransport struct {
+	Transport http.RoundTripper
+	proxy     *Proxy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r, ok := routeFromContext(req.Context())
+	if !ok || r.pinned == "" || r.forced {
+		return t.Transport.RoundTrip(req)
+	}
+	// The body is not read when the dial fails: keep it open for the fallback.
+	body := req.Body
+	attempt := req
+	if body != nil {
+		attempt = req.Clone(req.Context())
+		attempt.Body = io.NopCloser(body)
+	}
+	resp, err := t.Transport.RoundTrip(attempt)
+	if !errors.Is(err, errPinnedDown) {
+		return resp, err
+	}
+	fallback := req.Clone(req.Context())
+	fallback.Body = body
+	fallback.URL.Host = encodeServiceHost(r.name, r.version, t.proxy.hostSeparator())
+	return t.Transport.RoundTrip(fallback)
+}
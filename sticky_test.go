@@ -0,0 +1,139 @@
+package goproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyStickyKey(t *testing.T) {
+	backend1, backend2 := newBackend(t, "backend1"), newBackend(t, "backend2")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend1.URL, "http://"))
+	reg.Add("service1", "v1", strings.TrimPrefix(backend2.URL, "http://"))
+	p := NewProxy(reg)
+	p.StickyKey = StickyCookie("session")
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	get := func(session string) string {
+		req, _ := http.NewRequest("GET", proxy.URL+"/service1/v1/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	backends := map[string]int{}
+	for i := 0; i < 20; i++ {
+		session := fmt.Sprintf("session%d", i)
+		first := get(session)
+		for j := 0; j < 5; j++ {
+			if got := get(session); got != first {
+				t.Fatalf("Session %s moved from %q to %q", session, first, got)
+			}
+		}
+		backends[first]++
+	}
+	if len(backends) != 2 {
+		t.Fatalf("Sessions are not spread across the endpoints: %v", backends)
+	}
+}
+
+func TestProxyStickyFallback(t *testing.T) {
+	backend := newBackend(t, "backend")
+	pinned := newBackend(t, "pinned")
+	addr := strings.TrimPrefix(pinned.URL, "http://")
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("service1", "v1", addr)
+
+	// Find a session pinned to the second backend.
+	endpoints, _ := reg.Lookup("service1", "v1")
+	var session string
+	for i := 0; session == "" || rendezvous(endpoints, session) != addr; i++ {
+		session = fmt.Sprintf("session%d", i)
+	}
+
+	for _, strict := range []bool{false, true} {
+		p := NewProxy(reg)
+		p.StickyKey = func(*http.Request) string { return session }
+		p.StrictAffinity = strict
+		get := func() (int, string) {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest("GET", "/service1/v1/", nil))
+			return w.Code, w.Body.String()
+		}
+		if _, body := get(); body != "pinned /" {
+			t.Fatalf("Unexpected response from the pinned endpoint: %q", body)
+		}
+
+		pinned.Close()
+		code, body := get()
+		if strict && code != http.StatusBadGateway {
+			t.Fatalf("Unexpected strict fallback: %d %q", code, body)
+		}
+		if !strict && body != "backend /" {
+			t.Fatalf("Unexpected fallback: %d %q", code, body)
+		}
+
+		// The pinned endpoint is tried again once back.
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Skipf("Can't listen again on %s: %s", addr, err)
+		}
+		pinned = httptest.NewUnstartedServer(pinned.Config.Handler)
+		pinned.Listener.Close()
+		pinned.Listener = l
+		pinned.Start()
+		if _, body := get(); body != "pinned /" {
+			t.Fatalf("Unexpected response once the pinned endpoint is back: %q", body)
+		}
+	}
+	pinned.Close()
+}
+
+func TestProxySelectionSeed(t *testing.T) {
+	reg := registry.DefaultRegistry{}
+	for _, name := range []string{"v1a", "v1b", "v2a", "v2b"} {
+		reg.Add("service1", name[:2], newBackend(t, name).Listener.Addr().String())
+	}
+	p := NewProxy(reg)
+	p.SelectionSeed = SelectionSeedHeader("X-User")
+	p.TrafficSplit = NewTrafficSplit()
+	if err := p.TrafficSplit.Set("service1", "v1", map[string]int{"v1": 1, "v2": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(user string) string {
+		req := httptest.NewRequest("GET", "/service1/v1/", nil)
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	backends := map[string]int{}
+	for i := 0; i < 40; i++ {
+		user := fmt.Sprintf("user%d", i)
+		first := get(user)
+		for j := 0; j < 5; j++ {
+			if got := get(user); got != first {
+				t.Fatalf("User %s moved from %q to %q", user, first, got)
+			}
+		}
+		backends[first]++
+	}
+	if len(backends) != 4 {
+		t.Fatalf("Users are not spread across the versions and endpoints: %v", backends)
+	}
+}
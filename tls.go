@@ -0,0 +1,76 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// upstreamTLSConfig returns the TLS config of the given service name/version,
+// nil when its endpoints are plain HTTP.
+func (p *Proxy) upstreamTLSConfig(name, version string) *tls.Config {
+	if config := p.serviceConfig(name, version).TLSConfig; config != nil {
+		return config
+	}
+	if p.UpstreamTLSConfig == nil {
+		return nil
+	}
+	return p.UpstreamTLSConfig(name, version)
+}
+
+// DialTLS connects to an endpoint of the service name/version set as host
+// by the Director and performs the TLS handshake with it, using the
+// UpstreamTLSConfig of the service.
+// When the service config has no ServerName, the host of the registered
+// endpoint is verified.
+func (p *Proxy) DialTLS(network, addr string) (net.Conn, error) {
+	name, version, _, err := parseRoutingHost(addr, p.hostSeparator())
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	upstream := conn.(*proxyConn)
+	endpoint := upstream.Endpoint()
+
+	config := p.upstreamTLSConfig(name, version)
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		if host, _, err := net.SplitHostPort(endpoint); err == nil {
+			config.ServerName = host
+		} else {
+			config.ServerName = endpoint
+		}
+	}
+
+	ctx := context.Background()
+	if timeout := p.transport.TLSHandshakeTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &upstreamTLSConn{Conn: tlsConn, endpoint: endpoint, proxyConn: upstream}, nil
+}
+
+// upstreamTLSConn is a TLS connection to a registry endpoint.
+type upstreamTLSConn struct {
+	*tls.Conn
+	endpoint  string
+	proxyConn *proxyConn
+}
+
+// Endpoint returns the registry endpoint the connection was dialed to.
+func (c *upstreamTLSConn) Endpoint() string { return c.endpoint }
+
+// upstream returns the underlying connection dialed by the Proxy.
+func (c *upstreamTLSConn) upstream() *proxyConn { return c.proxyConn }
@@ -0,0 +1,62 @@
+package goproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyUpstreamTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.TLS.ServerName + " " + req.URL.Path))
+	}))
+	defer backend.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(backend.Certificate())
+
+	reg := registry.DefaultRegistry{}
+	// The test certificate is valid for 127.0.0.1 and example.com.
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "https://"))
+	reg.Add("service2", "v1", strings.TrimPrefix(backend.URL, "https://"))
+	reg.Add("service3", "v1", strings.TrimPrefix(backend.URL, "https://"))
+	p := NewProxy(reg)
+	p.UpstreamTLSConfig = func(name, version string) *tls.Config {
+		switch name {
+		case "service1":
+			return &tls.Config{RootCAs: roots}
+		case "service2":
+			return &tls.Config{RootCAs: roots, ServerName: "example.com"}
+		case "service3":
+			return &tls.Config{RootCAs: roots, ServerName: "other.example.org"}
+		}
+		return nil
+	}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+
+	for _, elem := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/service1/v1/users", http.StatusOK, " /users"}, // No SNI is sent for IP addresses.
+		{"/service2/v1/users", http.StatusOK, "example.com /users"},
+		{"/service3/v1/users", http.StatusBadGateway, ""},
+	} {
+		resp, err := http.Get(proxy.URL + elem.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != elem.status || string(body) != elem.body {
+			t.Fatalf("Unexpected response for %s: %d %q", elem.path, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package goproxy
+
+import "net/http"
+
+// Transports creates a Proxy.Transport selecting transports by
+// `<name>/<version>` first, then by `<name>`.
+func Transports(transports map[string]http.RoundTripper) func(name, version string) http.RoundTripper {
+	return func(name, version string) http.RoundTripper {
+		if rt, ok := transports[name+"/"+version]; ok {
+			return rt
+		}
+		return transports[name]
+	}
+}
+
+// serviceTransport is an http.RoundTripper sending the requests through
+// the Transport of their service, falling back to the Proxy transport.
+type serviceTransport struct {
+	proxy *Proxy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t serviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r, ok := routeFromContext(req.Context()); ok {
+		if rt := t.proxy.serviceTransport(r.name, r.version); rt != nil {
+			return rt.RoundTrip(req)
+		}
+	}
+	return t.proxy.transport.RoundTrip(req)
+}
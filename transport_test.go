@@ -0,0 +1,47 @@
+package goproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestProxyTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer backend.Close()
+	reg := registry.DefaultRegistry{}
+	reg.Add("service1", "v1", strings.TrimPrefix(backend.URL, "http://"))
+	reg.Add("service2", "v1", strings.TrimPrefix(backend.URL, "http://"))
+
+	p := NewProxy(reg)
+	p.Transport = Transports(map[string]http.RoundTripper{
+		"service1": &http.Transport{Dial: p.Dial, ResponseHeaderTimeout: 20 * time.Millisecond},
+	})
+	for path, status := range map[string]int{
+		"/service1/v1/": http.StatusGatewayTimeout,
+		"/service2/v1/": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != status {
+			t.Errorf("Unexpected status for %s: %d, expected %d", path, w.Code, status)
+		}
+	}
+}
+
+func TestTransports(t *testing.T) {
+	byName, byVersion := &http.Transport{}, &http.Transport{}
+	transport := Transports(map[string]http.RoundTripper{
+		"service1":    byName,
+		"service1/v2": byVersion,
+	})
+	if transport("service1", "v1") != byName || transport("service1", "v2") != byVersion || transport("service2", "v1") != nil {
+		t.Fatal("Unexpected transport selection")
+	}
+}
@@ -0,0 +1,144 @@
+package goproxy
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+// WarmingRegistry wraps a Registry to open a connection to the endpoints as
+// they are added, so the first request to a new endpoint doesn't pay the dial
+// cost. The warm connection is used by the next dial of the default balancers
+// to the endpoint, or closed once idle for MaxIdle, e.g. when the endpoint is
+// deleted meanwhile.
+//
+// The warm-ups are bounded: the endpoints added while MaxBurst warm-ups are
+// in flight are not warmed, to avoid connection storms on bulk adds.
+type WarmingRegistry struct {
+	registry.Registry
+
+	// MaxBurst caps the number of warm-ups in flight. When zero, 8 is used.
+	MaxBurst int
+
+	// MaxIdle is the time a warm connection is kept unused. It should be lower
+	// than the idle timeout of the endpoints. When zero, 10 seconds is used.
+	MaxIdle time.Duration
+
+	once     sync.Once
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewWarmingRegistry creates a WarmingRegistry wrapping the given registry.
+func NewWarmingRegistry(reg registry.Registry) *WarmingRegistry {
+	return &WarmingRegistry{Registry: reg}
+}
+
+// Add adds the given endpoint for the service name/version and opens a warm
+// connection to it in a new goroutine, unless MaxBurst warm-ups are in flight.
+func (r *WarmingRegistry) Add(name, version, endpoint string) {
+	r.Registry.Add(name, version, endpoint)
+	r.once.Do(func() {
+		n := r.MaxBurst
+		if n <= 0 {
+			n = 8
+		}
+		r.inFlight = make(chan struct{}, n)
+	})
+	select {
+	case r.inFlight <- struct{}{}:
+	default:
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() { <-r.inFlight }()
+		conn, err := dialNew("tcp", endpoint)
+		if err != nil {
+			return
+		}
+		maxIdle := r.MaxIdle
+		if maxIdle == 0 {
+			maxIdle = 10 * time.Second
+		}
+		warmConns.put("tcp", endpoint, conn, maxIdle)
+	}()
+}
+
+// Wait waits for the warm-ups in flight.
+func (r *WarmingRegistry) Wait() {
+	r.wg.Wait()
+}
+
+// Enumerate returns the sorted registered versions by service name of the wrapped registry.
+func (r *WarmingRegistry) Enumerate() map[string][]string {
+	return registry.Enumerate(r.Registry)
+}
+
+// LookupTiers returns the endpoint tiers of the wrapped registry.
+func (r *WarmingRegistry) LookupTiers(name, version string) ([][]string, error) {
+	return registry.LookupTiers(r.Registry, name, version)
+}
+
+// warmConns holds the warm connections opened by the WarmingRegistries.
+var warmConns = &warmPool{}
+
+type warmKey struct {
+	network, endpoint string
+}
+
+// warmPool holds at most one warm connection per endpoint.
+type warmPool struct {
+	lock  sync.Mutex
+	conns map[warmKey]*warmConn
+}
+
+type warmConn struct {
+	net.Conn
+	timer *time.Timer
+}
+
+// put holds conn as warm connection to the endpoint for maxIdle.
+// It is closed when the endpoint has a warm connection already.
+func (p *warmPool) put(network, endpoint string, conn net.Conn, maxIdle time.Duration) {
+	key := warmKey{network, endpoint}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.conns[key]; ok {
+		conn.Close()
+		return
+	}
+	if p.conns == nil {
+		p.conns = map[warmKey]*warmConn{}
+	}
+	warm := &warmConn{Conn: conn}
+	warm.timer = time.AfterFunc(maxIdle, func() {
+		p.lock.Lock()
+		if p.conns[key] == warm {
+			delete(p.conns, key)
+		}
+		p.lock.Unlock()
+		conn.Close()
+	})
+	p.conns[key] = warm
+}
+
+// take returns the warm connection to the endpoint, nil when there is none.
+func (p *warmPool) take(network, endpoint string) net.Conn {
+	key := warmKey{network, endpoint}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	warm, ok := p.conns[key]
+	if !ok {
+		return nil
+	}
+	delete(p.conns, key)
+	if !warm.timer.Stop() {
+		// Expired, being closed.
+		return nil
+	}
+	return warm.Conn
+}
@@ -0,0 +1,76 @@
+package goproxy
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/creack/goproxy/registry"
+)
+
+func TestWarmingRegistry(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			defer conn.Close()
+		}
+	}()
+
+	reg := NewWarmingRegistry(registry.DefaultRegistry{})
+	reg.Add("service1", "v1", l.Addr().String())
+	reg.Wait()
+
+	// The balancer uses the warm connection.
+	conn, err := LoadBalance("tcp", "service1", "v1", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	for i := 0; accepted.Load() == 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("Unexpected accepted connections: %d", n)
+	}
+	if warmConns.take("tcp", l.Addr().String()) != nil {
+		t.Fatal("The warm connection was not taken")
+	}
+}
+
+func TestWarmPoolMaxIdle(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	pool := &warmPool{}
+	pool.put("tcp", "host1:80", c1, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if pool.take("tcp", "host1:80") != nil {
+		t.Fatal("Idle warm connection was not expired")
+	}
+	if _, err := c1.Write([]byte("x")); err == nil {
+		t.Fatal("Expired warm connection was not closed")
+	}
+}
+
+func TestWarmingRegistryMaxBurst(t *testing.T) {
+	reg := NewWarmingRegistry(registry.DefaultRegistry{})
+	reg.MaxBurst = 1
+	// The burst slot is taken: the endpoint is not warmed.
+	reg.once.Do(func() { reg.inFlight = make(chan struct{}, 1) })
+	reg.inFlight <- struct{}{}
+	reg.Add("service1", "v1", "host1:80")
+	reg.Wait()
+	if warmConns.take("tcp", "host1:80") != nil {
+		t.Fatal("Endpoint warmed past the burst limit")
+	}
+}